@@ -3,7 +3,7 @@ package bootstrap
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
+	"strings"
 
 	"github.com/tomogoma/go-typed-errors"
 	"github.com/tomogoma/imagems/pkg/api"
@@ -13,6 +13,9 @@ import (
 	"github.com/tomogoma/imagems/pkg/logging"
 	"github.com/tomogoma/imagems/pkg/model"
 	"github.com/tomogoma/imagems/pkg/roach"
+	"github.com/tomogoma/imagems/pkg/storage"
+	"github.com/tomogoma/imagems/pkg/storage/s3"
+	"github.com/tomogoma/imagems/pkg/urlsign"
 	jwt2 "github.com/tomogoma/jwt"
 	netHttp "net/http"
 )
@@ -42,11 +45,6 @@ func Bootstrap(log logging.Logger, conf config.Config) (netHttp.Handler, error)
 		log.Warnf("Unable to initialize database connection: %v", err)
 	}
 
-	m, err := model.New(conf.Service, tknVal, d, FileWriter(ioutil.WriteFile))
-	if err != nil {
-		return nil, fmt.Errorf("new model: %v", err)
-	}
-
 	genAPIKey, err := ioutil.ReadFile(conf.Auth.GenAPIKeyFile)
 	if err != nil {
 		log.Warnf("No general API key found: %v", err)
@@ -54,15 +52,71 @@ func Bootstrap(log logging.Logger, conf config.Config) (netHttp.Handler, error)
 	}
 	g, err := api.NewGuard(d, api.WithMasterKey(string(genAPIKey)))
 
-	handler, err := http.NewHandler(conf.Service, m, g, log, conf.Service.AllowedOrigins...)
+	var urlVerifier http.URLVerifier
+	modelOpts := []model.Option{model.WithLogger(log)}
+	if conf.Auth.URLSigningKeyFile != "" {
+		signingKey, err := ioutil.ReadFile(conf.Auth.URLSigningKeyFile)
+		if err != nil {
+			return nil, errors.Newf("read URL signing key file: %v", err)
+		}
+		signer, err := urlsign.New(signingKey)
+		if err != nil {
+			return nil, errors.Newf("new URL signer: %v", err)
+		}
+		urlVerifier = signer
+		modelOpts = append(modelOpts, model.WithURLSigner(signer))
+	} else {
+		log.Warnf("No URL signing key file configured, image transformations will not require signed URLs")
+	}
+
+	store, err := newStorage(conf.Service)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := model.New(conf.Service, tknVal, d, store, modelOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("new model: %v", err)
+	}
+
+	handler, err := http.NewHandler(conf.Service, m, store, g, urlVerifier, log, conf.Service.AllowedOrigins...)
 	if err != nil {
 		return nil, fmt.Errorf("new HTTP handler: %s", err)
 	}
 	return handler, nil
 }
 
-type FileWriter func(fileName string, data []byte, perm os.FileMode) error
-
-func (f FileWriter) WriteFile(fName string, dt []byte, perm os.FileMode) error {
-	return f(fName, dt, perm)
+// newStorage creates the storage backend selected in conf.
+func newStorage(conf config.Service) (model.Storage, error) {
+	switch conf.Storage.Backend {
+	case "", config.StorageLocal:
+		l, err := storage.NewLocal(conf.ImagesDir())
+		if err != nil {
+			return nil, errors.Newf("new local storage: %v", err)
+		}
+		return l, nil
+	case config.StorageS3:
+		c := conf.Storage.S3
+		secret, err := ioutil.ReadFile(c.SecretAccessKeyFile)
+		if err != nil {
+			return nil, errors.Newf("read S3 secret access key file: %v", err)
+		}
+		opts := []s3.Option{
+			s3.WithCredentials(c.AccessKeyID, strings.TrimSpace(string(secret))),
+			s3.WithPrefix(c.Prefix),
+			s3.WithPathStyle(c.PathStyle),
+		}
+		if c.Region != "" {
+			opts = append(opts, s3.WithRegion(c.Region))
+		}
+		if c.PartSize > 0 {
+			opts = append(opts, s3.WithPartSize(c.PartSize))
+		}
+		b, err := s3.New(c.Endpoint, c.Bucket, opts...)
+		if err != nil {
+			return nil, errors.Newf("new S3 storage: %v", err)
+		}
+		return b, nil
+	}
+	return nil, errors.Newf("unknown storage backend '%s'", conf.Storage.Backend)
 }
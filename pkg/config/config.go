@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"github.com/tomogoma/crdb"
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/model"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"path"
@@ -13,15 +15,92 @@ import (
 type Auth struct {
 	TokenKeyFile  string `json:"tokenKeyFile" yaml:"tokenKeyFile"`
 	GenAPIKeyFile string `json:"genAPIKeyFile" yaml:"genAPIKeyFile"`
+
+	// URLSigningKeyFile holds the key used to sign transformation URLs.
+	// Transformations need not be signed if it is empty.
+	URLSigningKeyFile string `json:"urlSigningKeyFile" yaml:"urlSigningKeyFile"`
+}
+
+type Upload struct {
+	AutoOrient    bool     `yaml:"autoOrient" json:"autoOrient"`
+	StripMetadata bool     `yaml:"stripMetadata" json:"stripMetadata"`
+	MaxBytes      int64    `yaml:"maxBytes" json:"maxBytes"`
+	MaxWidth      int      `yaml:"maxWidth" json:"maxWidth"`
+	MaxHeight     int      `yaml:"maxHeight" json:"maxHeight"`
+	MaxMegapixels float64  `yaml:"maxMegapixels" json:"maxMegapixels"`
+	AllowedTypes  []string `yaml:"allowedTypes" json:"allowedTypes"`
+
+	MaxFrames      int   `yaml:"maxFrames" json:"maxFrames"`
+	MaxTotalPixels int64 `yaml:"maxTotalPixels" json:"maxTotalPixels"`
+
+	Optimise Optimise `yaml:"optimise" json:"optimise"`
+
+	// MemoryBytes is the largest base64 upload request body, which is
+	// read into memory. Zero means DefaultUploadMemoryBytes.
+	MemoryBytes int64 `yaml:"memoryBytes" json:"memoryBytes"`
+}
+
+type Optimise struct {
+	Enabled         bool `yaml:"enabled" json:"enabled"`
+	JPEGQuality     int  `yaml:"jpegQuality" json:"jpegQuality"`
+	JPEGProgressive bool `yaml:"jpegProgressive" json:"jpegProgressive"`
+}
+
+type Watermark struct {
+	Image    string   `yaml:"image" json:"image"`
+	Users    []string `yaml:"users" json:"users"`
+	Folders  []string `yaml:"folders" json:"folders"`
+	Mode     string   `yaml:"mode" json:"mode"`
+	Position string   `yaml:"position" json:"position"`
+	Opacity  float64  `yaml:"opacity" json:"opacity"`
+	Margin   int      `yaml:"margin" json:"margin"`
+	Scale    float64  `yaml:"scale" json:"scale"`
+}
+
+// Storage backends, see Storage.
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// Storage selects where image files are stored.
+type Storage struct {
+	// Backend is StorageLocal (the default), which stores images in
+	// DataDir, or StorageS3.
+	Backend string `yaml:"backend" json:"backend"`
+	S3      S3     `yaml:"s3" json:"s3"`
+}
+
+// S3 configures storing images in a bucket of an S3 compatible object
+// store, see s3.New.
+type S3 struct {
+	Endpoint  string `yaml:"endpoint" json:"endpoint"`
+	Region    string `yaml:"region" json:"region"`
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	Prefix    string `yaml:"prefix" json:"prefix"`
+	PathStyle bool   `yaml:"pathStyle" json:"pathStyle"`
+	PartSize  int64  `yaml:"partSize" json:"partSize"`
+
+	AccessKeyID string `yaml:"accessKeyID" json:"accessKeyID"`
+	// SecretAccessKeyFile holds the secret access key. Surrounding
+	// white space is ignored.
+	SecretAccessKeyFile string `yaml:"secretAccessKeyFile" json:"secretAccessKeyFile"`
 }
 
 type Service struct {
-	RegisterInterval   time.Duration `yaml:"registerInterval" json:"registerInterval"`
-	DataDir            string        `yaml:"dataDir" json:"dataDir"`
-	ImgURL             string        `yaml:"imgURL" json:"imgURL"`
-	LoadBalanceVersion string        `yaml:"loadBalanceVersion" json:"loadBalanceVersion"`
-	Address            string        `yaml:"address" json:"address"`
-	AllowedOrigins     []string      `yaml:"allowedOrigins" json:"allowedOrigins"`
+	RegisterInterval   time.Duration     `yaml:"registerInterval" json:"registerInterval"`
+	DataDir            string            `yaml:"dataDir" json:"dataDir"`
+	ImgURL             string            `yaml:"imgURL" json:"imgURL"`
+	LoadBalanceVersion string            `yaml:"loadBalanceVersion" json:"loadBalanceVersion"`
+	Address            string            `yaml:"address" json:"address"`
+	AllowedOrigins     []string          `yaml:"allowedOrigins" json:"allowedOrigins"`
+	Variants           map[string]string `yaml:"variants" json:"variants"`
+	JPEGQuality        int               `yaml:"jpegQuality" json:"jpegQuality"`
+	JPEGBackground     string            `yaml:"jpegBackground" json:"jpegBackground"`
+	ConvertSRGB        bool              `yaml:"convertSRGB" json:"convertSRGB"`
+	Upload             Upload            `yaml:"upload" json:"upload"`
+	Watermarks         []Watermark       `yaml:"watermarks" json:"watermarks"`
+	Storage            Storage           `yaml:"storage" json:"storage"`
 }
 
 func (sc Service) ImagesDir() string {
@@ -32,6 +111,80 @@ func (sc Service) DefaultFolderName() string {
 	return "general"
 }
 
+func (sc Service) ImageVariants() map[string]string {
+	return sc.Variants
+}
+
+func (sc Service) ImageJPEGQuality() int {
+	return sc.JPEGQuality
+}
+
+func (sc Service) ImageJPEGBackground() string {
+	return sc.JPEGBackground
+}
+
+func (sc Service) ConvertToSRGB() bool {
+	return sc.ConvertSRGB
+}
+
+func (sc Service) AutoOrientUploads() bool {
+	return sc.Upload.AutoOrient
+}
+
+func (sc Service) StripUploadMetadata() bool {
+	return sc.Upload.StripMetadata
+}
+
+func (sc Service) OptimiseUploads() bool {
+	return sc.Upload.Optimise.Enabled
+}
+
+func (sc Service) OptimisedJPEGQuality() int {
+	return sc.Upload.Optimise.JPEGQuality
+}
+
+func (sc Service) OptimisedJPEGProgressive() bool {
+	return sc.Upload.Optimise.JPEGProgressive
+}
+
+func (sc Service) UploadMemoryBytes() int64 {
+	if sc.Upload.MemoryBytes == 0 {
+		return DefaultUploadMemoryBytes
+	}
+	return sc.Upload.MemoryBytes
+}
+
+func (sc Service) UploadPolicy() model.UploadPolicy {
+	return model.UploadPolicy{
+		MaxBytes:       sc.Upload.MaxBytes,
+		MaxWidth:       sc.Upload.MaxWidth,
+		MaxHeight:      sc.Upload.MaxHeight,
+		MaxMegapixels:  sc.Upload.MaxMegapixels,
+		AllowedTypes:   sc.Upload.AllowedTypes,
+		MaxFrames:      sc.Upload.MaxFrames,
+		MaxTotalPixels: sc.Upload.MaxTotalPixels,
+	}
+}
+
+func (sc Service) ImageWatermarks() []model.Watermark {
+	wms := make([]model.Watermark, len(sc.Watermarks))
+	for i, wm := range sc.Watermarks {
+		wms[i] = model.Watermark{
+			ImagePath: wm.Image,
+			Users:     wm.Users,
+			Folders:   wm.Folders,
+			Mode:      wm.Mode,
+			WatermarkOptions: imaging.WatermarkOptions{
+				Position: imaging.Position(wm.Position),
+				Opacity:  wm.Opacity,
+				Margin:   wm.Margin,
+				Scale:    wm.Scale,
+			},
+		}
+	}
+	return wms
+}
+
 func (sc Service) ImgURLRoot() string {
 	return strings.TrimSuffix(sc.ImgURL, "/") + WebRootURL()
 }
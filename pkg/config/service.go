@@ -21,6 +21,8 @@ const (
 
 	DocsPath = "docs"
 
+	DefaultUploadMemoryBytes = 32 << 20
+
 	imgsDirName = "images"
 )
 
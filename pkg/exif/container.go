@@ -0,0 +1,430 @@
+package exif
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"sort"
+)
+
+var (
+	xmpHeader     = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtHeader  = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	pngXMPKeyword = []byte("XML:com.adobe.xmp\x00")
+	iccHeader     = []byte("ICC_PROFILE\x00")
+
+	errMalformed = errors.New("malformed image container")
+)
+
+// ErrNoICCProfile is returned by ICCProfile when an image has no embedded
+// ICC profile.
+var ErrNoICCProfile = errors.New("no ICC profile found")
+
+// Strip returns data with EXIF and XMP metadata removed. data may be a JPEG,
+// PNG or WebP image. Other formats are returned unchanged.
+func Strip(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, pngSig):
+		return stripPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebP(data)
+	}
+	return data, nil
+}
+
+// pngMetadataChunks are the PNG chunks copied by CopyMetadata.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true, "iTXt": true, "tEXt": true, "zTXt": true, "tIME": true,
+	"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true, "pHYs": true,
+}
+
+// CopyMetadata returns dst with the metadata of src inserted. src and dst
+// must be of the same format and dst is expected to have been written by
+// an encoder that writes no metadata e.g. a re-encoded src. JPEG APPn and
+// comment segments and PNG EXIF, text, time, colour space and physical
+// dimension chunks preceding the image data are copied. Other formats are
+// returned unchanged.
+func CopyMetadata(src, dst []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(src, jpegSOI) && bytes.HasPrefix(dst, jpegSOI):
+		return copyJPEGMetadata(src, dst, func(s jpegSegment) bool {
+			return (s.marker >= 0xE0 && s.marker <= 0xEF) || s.marker == 0xFE
+		})
+	case bytes.HasPrefix(src, pngSig) && bytes.HasPrefix(dst, pngSig):
+		return copyPNGMetadata(src, dst, func(c pngChunk) bool {
+			return pngMetadataChunks[c.typ]
+		})
+	}
+	return dst, nil
+}
+
+// CopyICCProfile is like CopyMetadata but only copies the ICC profile.
+func CopyICCProfile(src, dst []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(src, jpegSOI) && bytes.HasPrefix(dst, jpegSOI):
+		return copyJPEGMetadata(src, dst, isJPEGICCSegment)
+	case bytes.HasPrefix(src, pngSig) && bytes.HasPrefix(dst, pngSig):
+		return copyPNGMetadata(src, dst, func(c pngChunk) bool {
+			return c.typ == "iCCP"
+		})
+	}
+	return dst, nil
+}
+
+// ICCProfile returns the ICC profile embedded in a JPEG (APP2 segments)
+// or PNG (iCCP chunk) image. It returns ErrNoICCProfile if there is none
+// or data is of another format.
+func ICCProfile(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return jpegICCProfile(data)
+	case bytes.HasPrefix(data, pngSig):
+		return pngICCProfile(data)
+	}
+	return nil, ErrNoICCProfile
+}
+
+// jpegSegment is a marker segment; data excludes the marker and length.
+type jpegSegment struct {
+	marker byte
+	start  int
+	end    int
+	data   []byte
+}
+
+// walkJPEG calls fn for each marker segment preceding the start of scan.
+// It returns the offset of the start of scan marker.
+func walkJPEG(data []byte, fn func(s jpegSegment) bool) (int, error) {
+	i := len(jpegSOI)
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return 0, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA {
+			return i, nil
+		}
+		segLen := int(binary.BigEndian.Uint16(data[i+2:]))
+		if segLen < 2 || i+2+segLen > len(data) {
+			return 0, errMalformed
+		}
+		s := jpegSegment{marker: marker, start: i, end: i + 2 + segLen,
+			data: data[i+4 : i+2+segLen]}
+		if !fn(s) {
+			return i, nil
+		}
+		i = s.end
+	}
+}
+
+func jpegPayload(data []byte) ([]byte, error) {
+	var payload []byte
+	_, err := walkJPEG(data, func(s jpegSegment) bool {
+		if s.marker == 0xE1 && bytes.HasPrefix(s.data, exifHeader) {
+			payload = s.data[len(exifHeader):]
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, ErrNotFound
+	}
+	return payload, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(jpegSOI)
+	sos, err := walkJPEG(data, func(s jpegSegment) bool {
+		if s.marker == 0xE1 && (bytes.HasPrefix(s.data, exifHeader) ||
+			bytes.HasPrefix(s.data, xmpHeader) ||
+			bytes.HasPrefix(s.data, xmpExtHeader)) {
+			return true
+		}
+		out.Write(data[s.start:s.end])
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Write(data[sos:])
+	return out.Bytes(), nil
+}
+
+func copyJPEGMetadata(src, dst []byte, copies func(s jpegSegment) bool) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(dst)))
+	out.Write(jpegSOI)
+	_, err := walkJPEG(src, func(s jpegSegment) bool {
+		if copies(s) {
+			out.Write(src[s.start:s.end])
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Write(dst[len(jpegSOI):])
+	return out.Bytes(), nil
+}
+
+func isJPEGICCSegment(s jpegSegment) bool {
+	// the header is followed by the chunk's sequence number and the
+	// number of chunks, both counted from 1.
+	return s.marker == 0xE2 && bytes.HasPrefix(s.data, iccHeader) &&
+		len(s.data) >= len(iccHeader)+2
+}
+
+// jpegICCProfile joins the profile chunks, which may be split over
+// several APP2 segments.
+func jpegICCProfile(data []byte) ([]byte, error) {
+	type iccChunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []iccChunk
+	_, err := walkJPEG(data, func(s jpegSegment) bool {
+		if isJPEGICCSegment(s) {
+			chunks = append(chunks, iccChunk{seq: s.data[len(iccHeader)],
+				data: s.data[len(iccHeader)+2:]})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, ErrNoICCProfile
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	profile := &bytes.Buffer{}
+	for _, c := range chunks {
+		profile.Write(c.data)
+	}
+	return profile.Bytes(), nil
+}
+
+type pngChunk struct {
+	typ   string
+	start int
+	end   int
+	data  []byte
+}
+
+func walkPNG(data []byte, fn func(c pngChunk) bool) error {
+	i := len(pngSig)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return errMalformed
+		}
+		l := int(binary.BigEndian.Uint32(data[i:]))
+		if l < 0 || i+12+l > len(data) {
+			return errMalformed
+		}
+		c := pngChunk{typ: string(data[i+4 : i+8]), start: i, end: i + 12 + l,
+			data: data[i+8 : i+8+l]}
+		if !fn(c) {
+			return nil
+		}
+		i = c.end
+	}
+	return nil
+}
+
+func pngPayload(data []byte) ([]byte, error) {
+	var payload []byte
+	err := walkPNG(data, func(c pngChunk) bool {
+		if c.typ == "eXIf" {
+			payload = c.data
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, ErrNotFound
+	}
+	return payload, nil
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSig)
+	err := walkPNG(data, func(c pngChunk) bool {
+		if c.typ == "eXIf" || (c.typ == "iTXt" && bytes.HasPrefix(c.data, pngXMPKeyword)) {
+			return true
+		}
+		out.Write(data[c.start:c.end])
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// pngICCProfile decompresses the profile in the iCCP chunk which holds
+// the profile's name, a compression method byte and the zlib compressed
+// profile.
+func pngICCProfile(data []byte) ([]byte, error) {
+	var iccp []byte
+	err := walkPNG(data, func(c pngChunk) bool {
+		if c.typ == "IDAT" {
+			return false
+		}
+		if c.typ == "iCCP" {
+			iccp = c.data
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if iccp == nil {
+		return nil, ErrNoICCProfile
+	}
+	nameEnd := bytes.IndexByte(iccp, 0)
+	if nameEnd < 0 || nameEnd+2 > len(iccp) || iccp[nameEnd+1] != 0 {
+		return nil, errMalformed
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(iccp[nameEnd+2:]))
+	if err != nil {
+		return nil, errMalformed
+	}
+	defer zr.Close()
+	profile, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errMalformed
+	}
+	return profile, nil
+}
+
+func copyPNGMetadata(src, dst []byte, copies func(c pngChunk) bool) ([]byte, error) {
+	meta := &bytes.Buffer{}
+	err := walkPNG(src, func(c pngChunk) bool {
+		if c.typ == "IDAT" {
+			return false
+		}
+		if copies(c) {
+			meta.Write(src[c.start:c.end])
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// metadata goes right after the IHDR chunk which must come first.
+	ihdrEnd := -1
+	err = walkPNG(dst, func(c pngChunk) bool {
+		if c.typ == "IHDR" {
+			ihdrEnd = c.end
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ihdrEnd < 0 {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(dst)+meta.Len()))
+	out.Write(dst[:ihdrEnd])
+	out.Write(meta.Bytes())
+	out.Write(dst[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+type riffChunk struct {
+	fourCC string
+	start  int
+	end    int
+	data   []byte
+}
+
+func walkWebP(data []byte, fn func(c riffChunk) bool) error {
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return errMalformed
+		}
+		l := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + l + l%2
+		if l < 0 || i+8+l > len(data) {
+			return errMalformed
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		c := riffChunk{fourCC: string(data[i : i+4]), start: i, end: end,
+			data: data[i+8 : i+8+l]}
+		if !fn(c) {
+			return nil
+		}
+		i = end
+	}
+	return nil
+}
+
+func webpPayload(data []byte) ([]byte, error) {
+	var payload []byte
+	err := walkWebP(data, func(c riffChunk) bool {
+		if c.fourCC == "EXIF" {
+			payload = bytes.TrimPrefix(c.data, exifHeader)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, ErrNotFound
+	}
+	return payload, nil
+}
+
+// VP8X feature flags.
+const (
+	vp8xFlagXMP  = 1 << 2
+	vp8xFlagEXIF = 1 << 3
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	err := walkWebP(data, func(c riffChunk) bool {
+		switch c.fourCC {
+		case "EXIF", "XMP ":
+			return true
+		case "VP8X":
+			start := out.Len()
+			out.Write(data[c.start:c.end])
+			if len(c.data) > 0 {
+				out.Bytes()[start+8] &^= vp8xFlagXMP | vp8xFlagEXIF
+			}
+			return true
+		}
+		out.Write(data[c.start:c.end])
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:8], uint32(len(stripped)-8))
+	return stripped, nil
+}
@@ -0,0 +1,297 @@
+// Package exif reads EXIF metadata embedded in JPEG, PNG, TIFF and WebP
+// images.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Tags of interest, as defined by the EXIF 2.3 specification.
+const (
+	// IFD0 tags.
+	TagOrientation = 0x0112
+	TagMake        = 0x010F
+	TagModel       = 0x0110
+	TagDateTime    = 0x0132
+
+	// EXIF IFD tags.
+	TagExposureTime     = 0x829A
+	TagFNumber          = 0x829D
+	TagISOSpeed         = 0x8827
+	TagDateTimeOriginal = 0x9003
+	TagFocalLength      = 0x920A
+
+	// GPS IFD tags.
+	TagGPSLatitudeRef  = 0x0001
+	TagGPSLatitude     = 0x0002
+	TagGPSLongitudeRef = 0x0003
+	TagGPSLongitude    = 0x0004
+
+	tagExifIFDPointer = 0x8769
+	tagGPSIFDPointer  = 0x8825
+)
+
+// ErrNotFound is returned by Read when an image has no EXIF metadata.
+var ErrNotFound = errors.New("no EXIF metadata found")
+
+var (
+	jpegSOI    = []byte{0xFF, 0xD8}
+	pngSig     = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader = []byte("Exif\x00\x00")
+	tiffLE     = []byte("II*\x00")
+	tiffBE     = []byte("MM\x00*")
+)
+
+// Tag is a single EXIF IFD entry.
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint32
+	// Value holds Count values of Type in the EXIF's byte order.
+	Value []byte
+}
+
+// Exif is the parsed EXIF metadata of an image.
+type Exif struct {
+	order binary.ByteOrder
+	// IFD0 holds tags describing the main image.
+	IFD0 map[uint16]Tag
+	// ExifIFD holds tags describing capture conditions.
+	ExifIFD map[uint16]Tag
+	// GPS holds GPS tags.
+	GPS map[uint16]Tag
+}
+
+// Read parses the EXIF metadata embedded in data which may be a JPEG,
+// PNG, TIFF or WebP image. ErrNotFound is returned if data contains no
+// EXIF metadata.
+func Read(data []byte) (*Exif, error) {
+	payload, err := Payload(data)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(payload)
+}
+
+// Payload returns the raw TIFF-structured EXIF block embedded in data.
+// ErrNotFound is returned if data contains no EXIF metadata.
+func Payload(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		return jpegPayload(data)
+	case bytes.HasPrefix(data, pngSig):
+		return pngPayload(data)
+	case bytes.HasPrefix(data, tiffLE), bytes.HasPrefix(data, tiffBE):
+		return data, nil
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return webpPayload(data)
+	}
+	return nil, ErrNotFound
+}
+
+// Parse parses a TIFF-structured EXIF block.
+func Parse(payload []byte) (*Exif, error) {
+	payload = bytes.TrimPrefix(payload, exifHeader)
+	if len(payload) < 8 {
+		return nil, errors.New("EXIF block too short")
+	}
+	e := &Exif{}
+	switch {
+	case bytes.HasPrefix(payload, tiffLE):
+		e.order = binary.LittleEndian
+	case bytes.HasPrefix(payload, tiffBE):
+		e.order = binary.BigEndian
+	default:
+		return nil, errors.New("EXIF block has an invalid byte order mark")
+	}
+	var err error
+	e.IFD0, err = e.readIFD(payload, e.order.Uint32(payload[4:8]))
+	if err != nil {
+		return nil, fmt.Errorf("read IFD0: %v", err)
+	}
+	if off, ok := e.Uint(e.IFD0, tagExifIFDPointer); ok {
+		if e.ExifIFD, err = e.readIFD(payload, off); err != nil {
+			return nil, fmt.Errorf("read EXIF IFD: %v", err)
+		}
+	}
+	if off, ok := e.Uint(e.IFD0, tagGPSIFDPointer); ok {
+		if e.GPS, err = e.readIFD(payload, off); err != nil {
+			return nil, fmt.Errorf("read GPS IFD: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// Orientation returns the orientation tag value (1-8) or 1 if the tag is
+// absent or invalid.
+func (e *Exif) Orientation() int {
+	o, ok := e.Uint(e.IFD0, TagOrientation)
+	if !ok || o < 1 || o > 8 {
+		return 1
+	}
+	return int(o)
+}
+
+// CaptureTime returns the time the image was captured falling back to the
+// time the image was last modified. EXIF times carry no time zone so they
+// are interpreted as UTC.
+func (e *Exif) CaptureTime() (time.Time, bool) {
+	dt, ok := e.String(e.ExifIFD, TagDateTimeOriginal)
+	if !ok {
+		dt, ok = e.String(e.IFD0, TagDateTime)
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(dateTimeFormat, dt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// LatLong returns the GPS coordinates in decimal degrees, negative for
+// south and west.
+func (e *Exif) LatLong() (float64, float64, bool) {
+	lat, ok := e.gpsCoordinate(TagGPSLatitude, TagGPSLatitudeRef, "S")
+	if !ok {
+		return 0, 0, false
+	}
+	long, ok := e.gpsCoordinate(TagGPSLongitude, TagGPSLongitudeRef, "W")
+	if !ok {
+		return 0, 0, false
+	}
+	return lat, long, true
+}
+
+// Rational returns the first value of a rational tag in ifd.
+func (e *Exif) Rational(ifd map[uint16]Tag, id uint16) (float64, bool) {
+	vals, ok := e.Rationals(ifd, id)
+	if !ok || len(vals) == 0 {
+		return 0, false
+	}
+	return vals[0], true
+}
+
+func (e *Exif) gpsCoordinate(valTag, refTag uint16, negRef string) (float64, bool) {
+	dms, ok := e.Rationals(e.GPS, valTag)
+	if !ok || len(dms) != 3 {
+		return 0, false
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref, _ := e.String(e.GPS, refTag); ref == negRef {
+		deg = -deg
+	}
+	return deg, true
+}
+
+// Uint returns the first value of an unsigned integer tag in ifd.
+func (e *Exif) Uint(ifd map[uint16]Tag, id uint16) (uint32, bool) {
+	t, ok := ifd[id]
+	if !ok || t.Count == 0 {
+		return 0, false
+	}
+	switch t.Type {
+	case typeByte:
+		return uint32(t.Value[0]), true
+	case typeShort:
+		return uint32(e.order.Uint16(t.Value)), true
+	case typeLong:
+		return e.order.Uint32(t.Value), true
+	}
+	return 0, false
+}
+
+// String returns the value of an ASCII tag in ifd.
+func (e *Exif) String(ifd map[uint16]Tag, id uint16) (string, bool) {
+	t, ok := ifd[id]
+	if !ok || t.Type != typeASCII {
+		return "", false
+	}
+	return string(bytes.TrimRight(t.Value, "\x00 ")), true
+}
+
+// Rationals returns the values of an unsigned or signed rational tag
+// in ifd as float64s.
+func (e *Exif) Rationals(ifd map[uint16]Tag, id uint16) ([]float64, bool) {
+	t, ok := ifd[id]
+	if !ok || (t.Type != typeRational && t.Type != typeSRational) {
+		return nil, false
+	}
+	vals := make([]float64, t.Count)
+	for i := range vals {
+		num, denom := e.order.Uint32(t.Value[i*8:]), e.order.Uint32(t.Value[i*8+4:])
+		if denom == 0 {
+			return nil, false
+		}
+		if t.Type == typeSRational {
+			vals[i] = float64(int32(num)) / float64(int32(denom))
+		} else {
+			vals[i] = float64(num) / float64(denom)
+		}
+	}
+	return vals, true
+}
+
+const dateTimeFormat = "2006:01:02 15:04:05"
+
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeSByte     = 6
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+	typeFloat     = 11
+	typeDouble    = 12
+)
+
+var typeSizes = map[uint16]uint32{
+	typeByte: 1, typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8,
+	typeSByte: 1, typeUndefined: 1, typeSShort: 2, typeSLong: 4,
+	typeSRational: 8, typeFloat: 4, typeDouble: 8,
+}
+
+func (e *Exif) readIFD(payload []byte, off uint32) (map[uint16]Tag, error) {
+	if uint64(off)+2 > uint64(len(payload)) {
+		return nil, errors.New("IFD offset out of bounds")
+	}
+	n := uint32(e.order.Uint16(payload[off:]))
+	start := off + 2
+	if uint64(start)+uint64(n)*12 > uint64(len(payload)) {
+		return nil, errors.New("IFD entries out of bounds")
+	}
+	tags := make(map[uint16]Tag, n)
+	for i := uint32(0); i < n; i++ {
+		entry := payload[start+i*12 : start+i*12+12]
+		t := Tag{
+			ID:    e.order.Uint16(entry[0:2]),
+			Type:  e.order.Uint16(entry[2:4]),
+			Count: e.order.Uint32(entry[4:8]),
+		}
+		size, ok := typeSizes[t.Type]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(t.Count)
+		if total <= 4 {
+			t.Value = entry[8 : 8+total]
+		} else {
+			valOff := uint64(e.order.Uint32(entry[8:12]))
+			if valOff+total > uint64(len(payload)) {
+				continue
+			}
+			t.Value = payload[valOff : valOff+total]
+		}
+		tags[t.ID] = t
+	}
+	return tags, nil
+}
@@ -0,0 +1,251 @@
+package exif_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/exif"
+)
+
+func TestRead(t *testing.T) {
+	tt := []struct {
+		name           string
+		img            []byte
+		expNotFound    bool
+		expOrientation int
+		expMake        string
+	}{
+		{name: "jpeg", img: jpegWithEXIF(t, 6, "Acme"), expOrientation: 6, expMake: "Acme"},
+		{name: "png", img: pngWithEXIF(t, 3, "Acme"), expOrientation: 3, expMake: "Acme"},
+		{name: "jpeg no EXIF", img: encodeJPEG(t), expNotFound: true},
+		{name: "not an image", img: []byte("hello world"), expNotFound: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := exif.Read(tc.img)
+			if tc.expNotFound {
+				if err != exif.ErrNotFound {
+					t.Fatalf("Expected ErrNotFound, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if o := e.Orientation(); o != tc.expOrientation {
+				t.Errorf("Orientation mismatch: expect %d, got %d",
+					tc.expOrientation, o)
+			}
+			if mk, _ := e.String(e.IFD0, exif.TagMake); mk != tc.expMake {
+				t.Errorf("Make mismatch: expect '%s', got '%s'", tc.expMake, mk)
+			}
+		})
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tt := []struct {
+		name string
+		img  []byte
+	}{
+		{name: "jpeg", img: jpegWithEXIF(t, 6, "Acme")},
+		{name: "png", img: pngWithEXIF(t, 6, "Acme")},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			stripped, err := exif.Strip(tc.img)
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if _, err := exif.Read(stripped); err != exif.ErrNotFound {
+				t.Errorf("Expected EXIF to be stripped, got %v", err)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Errorf("Stripped image not decodable: %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyMetadata(t *testing.T) {
+	tt := []struct {
+		name string
+		src  []byte
+		dst  []byte
+	}{
+		{name: "jpeg", src: jpegWithEXIF(t, 6, "Acme"), dst: encodeJPEG(t)},
+		{name: "png", src: pngWithEXIF(t, 6, "Acme"), dst: encodePNG(t)},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := exif.CopyMetadata(tc.src, tc.dst)
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			e, err := exif.Read(out)
+			if err != nil {
+				t.Fatalf("Expected EXIF to be copied, got %v", err)
+			}
+			if o := e.Orientation(); o != 6 {
+				t.Errorf("Orientation mismatch: expect 6, got %d", o)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("Result not decodable: %v", err)
+			}
+		})
+	}
+}
+
+func TestICCProfile(t *testing.T) {
+	profile := bytes.Repeat([]byte("profile-"), 10)
+	tt := []struct {
+		name        string
+		img         []byte
+		expNotFound bool
+	}{
+		{name: "jpeg", img: jpegWithICC(t, profile)},
+		{name: "png", img: pngWithICC(t, profile)},
+		{name: "jpeg copied", img: copyICC(t, jpegWithICC(t, profile), encodeJPEG(t))},
+		{name: "png copied", img: copyICC(t, pngWithICC(t, profile), encodePNG(t))},
+		{name: "jpeg none", img: jpegWithEXIF(t, 6, "Acme"), expNotFound: true},
+		{name: "png none", img: encodePNG(t), expNotFound: true},
+		{name: "not an image", img: []byte("hello world"), expNotFound: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := exif.ICCProfile(tc.img)
+			if tc.expNotFound {
+				if err != exif.ErrNoICCProfile {
+					t.Fatalf("Expected ErrNoICCProfile, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if !bytes.Equal(p, profile) {
+				t.Errorf("Profile mismatch: expect %q, got %q", profile, p)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(tc.img)); err != nil {
+				t.Errorf("Image not decodable: %v", err)
+			}
+		})
+	}
+}
+
+func copyICC(t *testing.T, src, dst []byte) []byte {
+	out, err := exif.CopyICCProfile(src, dst)
+	if err != nil {
+		t.Fatalf("Error setting up: copy ICC profile: %v", err)
+	}
+	return out
+}
+
+// jpegWithICC returns a JPEG with profile split over two APP2 segments
+// which are written out of order.
+func jpegWithICC(t *testing.T, profile []byte) []byte {
+	img := encodeJPEG(t)
+	half := len(profile) / 2
+	out := append([]byte{}, img[:2]...)
+	for _, chunk := range []struct {
+		seq  byte
+		data []byte
+	}{{2, profile[half:]}, {1, profile[:half]}} {
+		seg := append([]byte("ICC_PROFILE\x00"), chunk.seq, 2)
+		seg = append(seg, chunk.data...)
+		app2 := []byte{0xFF, 0xE2, 0, 0}
+		binary.BigEndian.PutUint16(app2[2:], uint16(len(seg)+2))
+		out = append(out, app2...)
+		out = append(out, seg...)
+	}
+	return append(out, img[2:]...)
+}
+
+func pngWithICC(t *testing.T, profile []byte) []byte {
+	img := encodePNG(t)
+	zipped := &bytes.Buffer{}
+	zw := zlib.NewWriter(zipped)
+	zw.Write(profile)
+	zw.Close()
+	data := append([]byte("test\x00\x00"), zipped.Bytes()...)
+	chunk := &bytes.Buffer{}
+	binary.Write(chunk, binary.BigEndian, uint32(len(data)))
+	chunk.WriteString("iCCP")
+	chunk.Write(data)
+	binary.Write(chunk, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("iCCP"), data...)))
+	out := append([]byte{}, img[:33]...)
+	out = append(out, chunk.Bytes()...)
+	return append(out, img[33:]...)
+}
+
+// tiffBlock returns a little endian EXIF block whose IFD0 holds an
+// orientation and a make tag.
+func tiffBlock(orientation uint16, mk string) []byte {
+	makeB := append([]byte(mk), 0)
+	for len(makeB) <= 4 {
+		makeB = append(makeB, 0)
+	}
+	le := binary.LittleEndian
+	b := &bytes.Buffer{}
+	b.WriteString("II*\x00")
+	binary.Write(b, le, uint32(8))
+	binary.Write(b, le, uint16(2))
+	// orientation: SHORT, inline.
+	binary.Write(b, le, []uint16{exif.TagOrientation, 3})
+	binary.Write(b, le, uint32(1))
+	binary.Write(b, le, []uint16{orientation, 0})
+	// make: ASCII, stored after the IFD.
+	binary.Write(b, le, []uint16{exif.TagMake, 2})
+	binary.Write(b, le, uint32(len(makeB)))
+	binary.Write(b, le, uint32(8+2+2*12+4))
+	binary.Write(b, le, uint32(0))
+	b.Write(makeB)
+	return b.Bytes()
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil); err != nil {
+		t.Fatalf("Error setting up: encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func jpegWithEXIF(t *testing.T, orientation uint16, mk string) []byte {
+	img := encodeJPEG(t)
+	seg := append([]byte("Exif\x00\x00"), tiffBlock(orientation, mk)...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+	out := append([]byte{}, img[:2]...)
+	out = append(out, app1...)
+	out = append(out, seg...)
+	return append(out, img[2:]...)
+}
+
+func encodePNG(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatalf("Error setting up: encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func pngWithEXIF(t *testing.T, orientation uint16, mk string) []byte {
+	img := encodePNG(t)
+	// insert eXIf right after the IHDR chunk (8 byte signature + 25 bytes).
+	data := tiffBlock(orientation, mk)
+	chunk := &bytes.Buffer{}
+	binary.Write(chunk, binary.BigEndian, uint32(len(data)))
+	chunk.WriteString("eXIf")
+	chunk.Write(data)
+	binary.Write(chunk, binary.BigEndian, crc32.ChecksumIEEE(append([]byte("eXIf"), data...)))
+	out := append([]byte{}, img[:33]...)
+	out = append(out, chunk.Bytes()...)
+	return append(out, img[33:]...)
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/gorilla/mux"
 	"net/http"
 	"github.com/tomogoma/go-typed-errors"
-	"os"
 	"github.com/pborman/uuid"
 	"github.com/tomogoma/imagems/pkg/logging"
 	"context"
@@ -17,6 +16,12 @@ import (
 	"io/ioutil"
 	"io"
 	"github.com/gorilla/handlers"
+	"github.com/tomogoma/imagems/pkg/model"
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"net/url"
+	"bytes"
+	"strconv"
+	"path"
 )
 
 const (
@@ -27,18 +32,53 @@ const (
 	bearerPrefix        = "bearer "
 )
 
+// svgContentSecurityPolicy allows SVGs only their inline styles and
+// embedded images.
+const svgContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
 type TokenValidator interface {
 	Validate(token string, claims jwt.Claims) (*jwt.Token, error)
 	IsAuthError(error) bool
 }
 
+// URLVerifier verifies the signatures of image URLs.
+type URLVerifier interface {
+	Verify(imgPath string, q url.Values, now time.Time) error
+}
+
+// Storage reads stored image files, see model.Storage.
+type Storage interface {
+	Get(path string, offset, length int64) (io.ReadCloser, error)
+	Stat(path string) (*model.ObjectInfo, error)
+	ListFolder(folder string) ([]model.ObjectInfo, []string, error)
+	IsNotFoundError(error) bool
+}
+
 type Config interface {
-	ImagesDir() string
+	// UploadMemoryBytes is the largest base64 upload request body, which
+	// is read into memory. Multipart uploads are streamed.
+	UploadMemoryBytes() int64
+	// UploadPolicy is checked against uploads. Request bodies larger than
+	// its MaxBytes, plus some room for the rest of the request, are
+	// refused before they are read in full.
+	UploadPolicy() model.UploadPolicy
 }
 
 type Model interface {
-	NewBase64Image(token, folder, img string) (time.Time, string, error)
-	NewImage(token, folder string, img io.ReadCloser) (time.Time, string, error)
+	NewBase64Image(token, folder, img string) (time.Time, *model.Upload, error)
+	NewImage(token, folder, declaredType string, img io.ReadCloser) (time.Time, *model.Upload, error)
+	TransformImage(imgPath string, t model.Transformation) (*model.TransformedImage, error)
+	IsWatermarked(imgPath string) (bool, error)
+	ResolveImage(imgPath string) (string, error)
+	ImageMeta(token, imageID string) (*model.ImageMeta, error)
+	ImageMetas(token, sortBy string, offset, count int64) ([]model.ImageMeta, error)
+	ImageMetasByColor(token, hexColor string, maxDistance float64, offset, count int64) ([]model.ImageMeta, error)
+	DuplicateClusters(token string, maxDistance int) ([][]model.ImageMeta, error)
+	DeleteImage(token, imageID string) error
+	EditImage(token, imageID string, ops []model.EditOp) (*model.ImageMeta, error)
+	ContactSheet(token, folder string, imageIDs []string, o model.ContactSheetOptions) (*model.ContactSheet, error)
+	DiffImages(token, imageID, otherID string, tolerance int, withImage bool) (*model.ImageDiff, error)
+	ImageManifest(token, imageID string, o model.ManifestOptions) (*model.ImageManifest, error)
 	errors.ToHTTPResponser
 }
 
@@ -47,18 +87,35 @@ type Guard interface {
 }
 
 type handler struct {
-	log        logging.Logger
-	guard      Guard
-	id         string
-	fileServer http.Handler
-	model      Model
+	log       logging.Logger
+	guard     Guard
+	id        string
+	store     Storage
+	model     Model
+	maxMemory int64
+	maxBytes  int64
+	verifier  URLVerifier
 }
 
-func NewHandler(c Config, m Model, g Guard, lg logging.Logger, allowedOrigins ...string) (http.Handler, error) {
+// uploadOverheadBytes is the room allowed in an upload request body for
+// everything other than the image e.g. multipart headers and form fields.
+const uploadOverheadBytes = 64 << 10
+
+// editBodyBytes is the largest edit request body accepted. It allows far
+// more operations than any sensible edit.
+const editBodyBytes = 64 << 10
+
+// NewHandler creates the HTTP handler of the service. Images are served
+// from s. Requests to transform images must be signed and are verified
+// using v unless v is nil.
+func NewHandler(c Config, m Model, s Storage, g Guard, v URLVerifier, lg logging.Logger, allowedOrigins ...string) (http.Handler, error) {
 
 	if m == nil {
 		return nil, errors.New("Model was nil")
 	}
+	if s == nil {
+		return nil, errors.New("Storage was nil")
+	}
 	if g == nil {
 		return nil, errors.New("Guard was nil")
 	}
@@ -70,7 +127,8 @@ func NewHandler(c Config, m Model, g Guard, lg logging.Logger, allowedOrigins ..
 	}
 
 	h := handler{id: config.CanonicalName(), model: m, log: lg, guard: g,
-		fileServer: http.FileServer(http.Dir(c.ImagesDir()))}
+		store: s, maxMemory: c.UploadMemoryBytes(),
+		maxBytes: c.UploadPolicy().MaxBytes, verifier: v}
 
 	r := mux.NewRouter().PathPrefix(config.WebRootURL()).Subrouter()
 	r.NotFoundHandler = http.HandlerFunc(h.prepLogger(h.notFoundHandler))
@@ -87,6 +145,38 @@ func NewHandler(c Config, m Model, g Guard, lg logging.Logger, allowedOrigins ..
 		Methods(http.MethodPut).
 		HandlerFunc(h.middleWare(h.newImage))
 
+	r.Path("/meta/duplicates").
+		Methods(http.MethodGet).
+		HandlerFunc(h.middleWare(h.duplicateClusters))
+
+	r.Path("/meta/{imageID}").
+		Methods(http.MethodGet).
+		HandlerFunc(h.middleWare(h.imageMeta))
+
+	r.Path("/meta/{imageID}").
+		Methods(http.MethodDelete).
+		HandlerFunc(h.middleWare(h.deleteImage))
+
+	r.Path("/meta/{imageID}/edit").
+		Methods(http.MethodPost).
+		HandlerFunc(h.middleWare(h.editImage))
+
+	r.Path("/meta/{imageID}/manifest").
+		Methods(http.MethodGet).
+		HandlerFunc(h.middleWare(h.imageManifest))
+
+	r.Path("/meta/{imageID}/diff/{otherID}").
+		Methods(http.MethodGet).
+		HandlerFunc(h.middleWare(h.diffImages))
+
+	r.Path("/meta").
+		Methods(http.MethodGet).
+		HandlerFunc(h.middleWare(h.imageMetas))
+
+	r.Path("/contactsheet").
+		Methods(http.MethodGet).
+		HandlerFunc(h.middleWare(h.contactSheet))
+
 	r.PathPrefix("/" + config.DocsPath).
 		Handler(http.FileServer(http.Dir(config.DefaultDocsDir())))
 
@@ -100,7 +190,8 @@ func NewHandler(c Config, m Model, g Guard, lg logging.Logger, allowedOrigins ..
 	})
 	originsOk := handlers.AllowedOrigins(allowedOrigins)
 	methodsOk := handlers.AllowedMethods([]string{http.MethodGet,
-		http.MethodHead, http.MethodPost, http.MethodPut, http.MethodOptions})
+		http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete,
+		http.MethodOptions})
 	return handlers.CORS(headersOk, originsOk, methodsOk)(r), nil
 }
 
@@ -177,12 +268,46 @@ func (h *handler) status(w http.ResponseWriter, r *http.Request) {
  * @apiVersion 0.1.0
  * @apiPermission any with API key
  * @apiGroup Service
+ * @apiDescription Uploaded images are served from
+ *	/{userID}/{folder}/{imageID}.{type}, which serves the image's current
+ *	content even after it is edited, and generated variants from
+ *	/{userID}/{folder}/{imageID}_{variant}.{type}. Content is stored once
+ *	per unique content at /blobs/{digest[0:2]}/{digest}.{type}.
+ *	Use the URLs returned on upload or in the image metadata.
+ *	Images, including their variants, may be watermarked depending on the
+ *	service's watermark configuration; content shared by several users'
+ *	uploads is watermarked if any of their images is.
+ *	SVGs are served as uploaded, after sanitising, with a restrictive
+ *	Content-Security-Policy; they cannot be resized, converted or filtered.
  *
  * @apiHeader x-api-key the api key
  *
  * @apiParam (Query) {String} userID	The userID of the image owner.
  * @apiParam (Query) {String} folder	The folder containing the image.
  * @apiParam (Query) {String} imageName	The name of the image.
+ * @apiParam (URL Query) {Number} [w]	Width to resize the image to.
+ * @apiParam (URL Query) {Number} [h]	Height to resize the image to.
+ * @apiParam (URL Query) {String=contain,cover,fill} [fit=contain] How to fit
+ *	the image into the box when both w and h are provided.
+ * @apiParam (URL Query) {String=jpeg,png,gif,bmp,tiff} [format] Format to
+ *	convert the image to. If not provided the image is converted to the
+ *	most preferred format in the Accept header only if its own format is
+ *	not acceptable. Images only converted to another format are converted
+ *	once and cached.
+ * @apiParam (URL Query) {Boolean} [poster=false] Serve only the first frame of
+ *	an animated GIF as a still. Resized animated GIFs otherwise keep all
+ *	their frames unless converted to another format.
+ * @apiParam (URL Query) {String} [ops] Comma separated filters applied in
+ *	order after resizing e.g.
+ *	"grayscale,blur:2,sharpen:1,brightness:10,contrast:-5". blur takes a
+ *	radius of up to 20px, sharpen an amount of up to 10 and brightness and
+ *	contrast a percentage from -100 to 100. At most 10 filters are allowed.
+ * @apiParam (URL Query) {String} [sig] HMAC-SHA256 signature, in unpadded URL
+ *	safe base64, of the image path (relative to this endpoint's root) followed
+ *	by "?" and the remaining query parameters sorted and URL encoded. Required
+ *	with any of w, h, format, poster or ops when URL signing is configured.
+ * @apiParam (URL Query) {Number} [expires] Unix time after which the signed
+ *	URL is rejected. Covered by the signature.
  *
  * @apiSuccess (200) {ImageFile} file The requested image file or xml listing of
  *	files contained in the specified folder.
@@ -190,7 +315,54 @@ func (h *handler) status(w http.ResponseWriter, r *http.Request) {
  */
 func (h *handler) viewImage(w http.ResponseWriter, r *http.Request) {
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, config.WebRootURL())
-	h.fileServer.ServeHTTP(w, r)
+
+	t, err := readTransformation(r.URL.Query())
+	if err != nil {
+		h.handleError(w, r, r.URL.Query(), err)
+		return
+	}
+	if !t.IsZero() && h.verifier != nil {
+		if err := h.verifier.Verify(r.URL.Path, r.URL.Query(), time.Now()); err != nil {
+			h.handleError(w, r, r.URL.Query(), err)
+			return
+		}
+	}
+	if t.Format == "" {
+		t.Format = negotiateFormat(r.Header.Get("Accept"), r.URL.Path)
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if path.Ext(r.URL.Path) == "."+imaging.FormatSVG {
+		// stop anything that survived sanitising from running or
+		// fetching resources if the SVG is opened directly.
+		w.Header().Set("Content-Security-Policy", svgContentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	storePath, err := h.model.ResolveImage(r.URL.Path)
+	if err != nil {
+		h.handleError(w, r, r.URL.Query(), err)
+		return
+	}
+	if t.IsZero() {
+		watermarked, err := h.model.IsWatermarked(storePath)
+		if err != nil {
+			h.handleError(w, r, r.URL.Query(), err)
+			return
+		}
+		if !watermarked {
+			h.serveFile(w, r, storePath)
+			return
+		}
+	}
+
+	img, err := h.model.TransformImage(storePath, t)
+	if err != nil {
+		h.handleError(w, r, r.URL.Query(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.MimeType)
+	http.ServeContent(w, r, "", img.ModTime, bytes.NewReader(img.Data))
 }
 
 /**
@@ -203,37 +375,49 @@ func (h *handler) viewImage(w http.ResponseWriter, r *http.Request) {
  * @apiHeader x-api-key the api key
  * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
  *
- * @apiParam (Form) {String} folder	The folder to place the image in.
- * @apiParam (Form) {File} image	file input containing upload image
+ * @apiParam (Form) {String} folder	The folder to place the image in. It
+ *	must precede the image in the form.
+ * @apiParam (Form) {File} image	file input containing upload image. The
+ *	file's Content-Type, if provided, must match the image's actual type.
+ *	SVGs are stored without scripts, event handler attributes, foreign
+ *	objects and references to external resources; their dimensions are
+ *	taken from their viewBox.
  *
  * @apiSuccess (200) {String} time Most recent server time as an ISO8601 string.
- * @apiSuccess (200) {String} URL The URL to the uploaded image.
+ * @apiSuccess (200) {String} ID The ID of the uploaded image.
+ * @apiSuccess (200) {Object} URLs Map of variant name to URL of the uploaded
+ *	image. The uploaded image itself is keyed as "original".
+ * @apiSuccess (200) {String} [blurHash] BlurHash of the image for painting a
+ *	placeholder.
+ * @apiSuccess (200) {String} [LQIP] Data URI of a 16px wide low quality
+ *	version of the image for painting a placeholder.
  *
  */
 func (h *handler) newImage(w http.ResponseWriter, r *http.Request) {
 
 	req := struct {
-		Token  string         `json:"token,omitempty"`
-		Folder string         `json:"folder,omitempty"`
-		Image  multipart.File `json:"image,omitempty"`
+		Token    string `json:"token,omitempty"`
+		Folder   string `json:"folder,omitempty"`
+		MimeType string `json:"mimeType,omitempty"`
 	}{}
 	req.Token = getToken(r)
 
-	r.ParseMultipartForm(32 << 20)
-	req.Folder = r.FormValue("folder")
-	var err error
-	req.Image, _, err = r.FormFile("image")
+	if h.maxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBytes+uploadOverheadBytes)
+	}
+	img, err := readMultipartImage(r, &req.Folder)
 	if err != nil {
-		h.handleError(w, r, req, errors.NewClientf("unable to read image form-file: %v", err))
+		h.handleError(w, r, req, err)
 		return
 	}
+	req.MimeType = img.Header.Get("Content-Type")
 
-	st, imgURL, err := h.model.NewImage(req.Token, req.Folder, req.Image)
+	st, upload, err := h.model.NewImage(req.Token, req.Folder, req.MimeType, img)
 
 	respData := struct {
 		Time string `json:"time,omitempty"`
-		URL  string `json:"URL,omitempty"`
-	}{st.Format(config.TimeFormat), imgURL}
+		*model.Upload
+	}{st.Format(config.TimeFormat), upload}
 
 	h.respondOn(w, r, req, respData, http.StatusCreated, err)
 }
@@ -252,7 +436,13 @@ func (h *handler) newImage(w http.ResponseWriter, r *http.Request) {
  * @apiParam (JSON) {String} image		The base64 encoded image string.
  *
  * @apiSuccess (200) {String} time	Most recent server time as an ISO8601 string.
- * @apiSuccess (200) {String} URL	The URL to the uploaded image.
+ * @apiSuccess (200) {String} ID	The ID of the uploaded image.
+ * @apiSuccess (200) {Object} URLs	Map of variant name to URL of the uploaded
+ *	image. The uploaded image itself is keyed as "original".
+ * @apiSuccess (200) {String} [blurHash]	BlurHash of the image for painting a
+ *	placeholder.
+ * @apiSuccess (200) {String} [LQIP]	Data URI of a 16px wide low quality
+ *	version of the image for painting a placeholder.
  *
  */
 func (h *handler) newB64Image(w http.ResponseWriter, r *http.Request) {
@@ -263,22 +453,529 @@ func (h *handler) newB64Image(w http.ResponseWriter, r *http.Request) {
 		Image  string `json:"image,omitempty"`
 	}{}
 
+	maxBody := h.maxMemory
+	if h.maxBytes > 0 {
+		// base64 encodes every 3 bytes as 4 characters.
+		if maxB64 := (h.maxBytes+2)/3*4 + uploadOverheadBytes; maxB64 < maxBody {
+			maxBody = maxB64
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 	if err := readJSONBody(r, &req); err != nil {
 		h.handleError(w, r, req, err)
 		return
 	}
 	req.Token = getToken(r)
 
-	st, imgURL, err := h.model.NewBase64Image(req.Token, req.Folder, req.Image)
+	st, upload, err := h.model.NewBase64Image(req.Token, req.Folder, req.Image)
 
 	respData := struct {
 		Time string `json:"time,omitempty"`
-		URL  string `json:"URL,omitempty"`
-	}{st.Format(config.TimeFormat), imgURL}
+		*model.Upload
+	}{st.Format(config.TimeFormat), upload}
 
 	h.respondOn(w, r, req, respData, http.StatusCreated, err)
 }
 
+/**
+ * @api {get} /meta/{imageID} Image Metadata
+ * @apiName ImageMeta
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Param) {String} imageID	The ID of the image.
+ *
+ * @apiSuccess (200) {Object} meta The image metadata. See List Image Metadata
+ *	for the fields.
+ *
+ */
+func (h *handler) imageMeta(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token   string `json:"token,omitempty"`
+		ImageID string `json:"imageID,omitempty"`
+	}{}
+	req.Token = getToken(r)
+	req.ImageID = mux.Vars(r)["imageID"]
+
+	meta, err := h.model.ImageMeta(req.Token, req.ImageID)
+
+	h.respondOn(w, r, req, meta, http.StatusOK, err)
+}
+
+/**
+ * @api {delete} /meta/:imageID Delete Image
+ * @apiName DeleteImage
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Param) {String} imageID	The ID of the image.
+ *
+ * @apiSuccess (204) {empty} body The image was deleted. Its content is kept
+ *	for as long as other uploads of identical content exist.
+ *
+ */
+func (h *handler) deleteImage(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token   string `json:"token,omitempty"`
+		ImageID string `json:"imageID,omitempty"`
+	}{}
+	req.Token = getToken(r)
+	req.ImageID = mux.Vars(r)["imageID"]
+
+	if err := h.model.DeleteImage(req.Token, req.ImageID); err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+ * @api {post} /meta/:imageID/edit Edit Image
+ * @apiName EditImage
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Param) {String} imageID	The ID of the image.
+ * @apiParam (JSON Body) {Object[]} operations Edits applied in order to the
+ *	image as it is displayed i.e. after applying its EXIF orientation.
+ * @apiParam (JSON Body) {String=rotate,flip,crop} operations.op The edit.
+ * @apiParam (JSON Body) {Number=90,180,270} [operations.angle] Clockwise
+ *	rotation in degrees for rotate.
+ * @apiParam (JSON Body) {String=horizontal,vertical} [operations.direction]
+ *	Direction of flip.
+ * @apiParam (JSON Body) {Number} [operations.x] Left edge in pixels of the
+ *	rectangle kept by crop.
+ * @apiParam (JSON Body) {Number} [operations.y] Top edge in pixels of the
+ *	rectangle kept by crop.
+ * @apiParam (JSON Body) {Number} [operations.width] Width in pixels of the
+ *	rectangle kept by crop.
+ * @apiParam (JSON Body) {Number} [operations.height] Height in pixels of the
+ *	rectangle kept by crop.
+ *
+ * @apiSuccess (200) {Object} meta The updated image metadata. See List Image
+ *	Metadata for the fields. The image keeps its ID and URLs which serve
+ *	the edited image.
+ *
+ */
+func (h *handler) editImage(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token      string         `json:"token,omitempty"`
+		ImageID    string         `json:"imageID,omitempty"`
+		Operations []model.EditOp `json:"operations,omitempty"`
+	}{}
+	r.Body = http.MaxBytesReader(w, r.Body, editBodyBytes)
+	if err := readJSONBody(r, &req); err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	req.Token = getToken(r)
+	req.ImageID = mux.Vars(r)["imageID"]
+
+	meta, err := h.model.EditImage(req.Token, req.ImageID, req.Operations)
+
+	h.respondOn(w, r, req, meta, http.StatusOK, err)
+}
+
+/**
+ * @api {get} /meta/:imageID/manifest Responsive Image Manifest
+ * @apiName ImageManifest
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ * @apiDescription Lists URLs of an image at several widths and in several
+ *	formats along with srcset and sizes attribute values for responsive
+ *	HTML markup. URLs other than the image's own are transformation URLs,
+ *	signed without expiry if the service requires signed transformations.
+ *	SVGs only list their own URL.
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Param) {String} imageID	The ID of the image.
+ * @apiParam (URL Query) {String} [widths=320,640,960,1280,1920,2560] Comma
+ *	separated widths in pixels, up to 12. Widths greater than the image's
+ *	are left out; the image's own width is always listed.
+ * @apiParam (URL Query) {String} [formats] Comma separated formats out of
+ *	jpeg, png, gif, bmp and tiff. Defaults to the image's own format or png
+ *	if the image's format cannot be encoded.
+ *
+ * @apiSuccess (200) {String} imageID ID of the image.
+ * @apiSuccess (200) {Number} width Width of the image in pixels.
+ * @apiSuccess (200) {Number} height Height of the image in pixels.
+ * @apiSuccess (200) {Number[]} widths Widths listed, narrowest first.
+ * @apiSuccess (200) {Object[]} formats Renditions per format, in the
+ *	requested order.
+ * @apiSuccess (200) {String} formats.format The format.
+ * @apiSuccess (200) {String} formats.mimeType MIME type of the format e.g.
+ *	for the type attribute of a source element.
+ * @apiSuccess (200) {String} formats.srcset srcset attribute value listing
+ *	the format's renditions.
+ * @apiSuccess (200) {Object[]} formats.renditions The format's renditions,
+ *	narrowest first.
+ * @apiSuccess (200) {Number} formats.renditions.width Width in pixels.
+ * @apiSuccess (200) {Number} formats.renditions.height Height in pixels.
+ * @apiSuccess (200) {String} formats.renditions.URL URL of the rendition.
+ * @apiSuccess (200) {String} src URL of the widest rendition of the first
+ *	format for the src attribute of an img element.
+ * @apiSuccess (200) {String} srcset srcset attribute value of the first
+ *	format.
+ * @apiSuccess (200) {String} sizes sizes attribute value for an image laid
+ *	out at full viewport width up to its own width.
+ *
+ */
+func (h *handler) imageManifest(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token   string `json:"token,omitempty"`
+		ImageID string `json:"imageID,omitempty"`
+		model.ManifestOptions
+	}{}
+	req.Token = getToken(r)
+	req.ImageID = mux.Vars(r)["imageID"]
+
+	q := r.URL.Query()
+	if widths := q.Get("widths"); widths != "" {
+		for _, wStr := range strings.Split(widths, ",") {
+			width, err := strconv.Atoi(strings.TrimSpace(wStr))
+			if err != nil {
+				h.handleError(w, r, req, errors.NewClient("widths must be comma separated numbers"))
+				return
+			}
+			req.Widths = append(req.Widths, width)
+		}
+	}
+	if formats := q.Get("formats"); formats != "" {
+		for _, f := range strings.Split(strings.ToLower(formats), ",") {
+			f = strings.TrimSpace(f)
+			if f == "jpg" {
+				f = imaging.FormatJPEG
+			}
+			req.Formats = append(req.Formats, f)
+		}
+	}
+
+	manifest, err := h.model.ImageManifest(req.Token, req.ImageID, req.ManifestOptions)
+
+	h.respondOn(w, r, req, manifest, http.StatusOK, err)
+}
+
+/**
+ * @api {get} /meta/:imageID/diff/:otherID Compare Images
+ * @apiName DiffImages
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ * @apiDescription Compares two images of equal dimensions pixel by pixel as
+ *	they are displayed i.e. after applying their EXIF orientation. Animated
+ *	images are compared by their first frames.
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Param) {String} imageID	The ID of the first image.
+ * @apiParam (URL Param) {String} otherID	The ID of the second image.
+ * @apiParam (URL Query) {Number{0-255}} [tolerance=0] Largest difference in
+ *	any RGBA channel for which a pixel is not counted as differing.
+ * @apiParam (URL Query) {Boolean} [image=false] Whether to include an image
+ *	visualising the differences.
+ *
+ * @apiSuccess (200) {String} imageID ID of the first image.
+ * @apiSuccess (200) {String} otherID ID of the second image.
+ * @apiSuccess (200) {Number} width Width of the images in pixels.
+ * @apiSuccess (200) {Number} height Height of the images in pixels.
+ * @apiSuccess (200) {Number} mae Mean absolute difference per RGBA channel on
+ *	a scale of 0-255.
+ * @apiSuccess (200) {Number} psnr Peak signal to noise ratio in decibels,
+ *	null if the images are identical.
+ * @apiSuccess (200) {Number} differingPercent Percentage of pixels that
+ *	differ by more than tolerance.
+ * @apiSuccess (200) {Number} differingPixels Number of pixels that differ by
+ *	more than tolerance.
+ * @apiSuccess (200) {String} [image] PNG data URI showing differing pixels
+ *	in red over a faded grayscale copy of the first image.
+ *
+ */
+func (h *handler) diffImages(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token     string `json:"token,omitempty"`
+		ImageID   string `json:"imageID,omitempty"`
+		OtherID   string `json:"otherID,omitempty"`
+		Tolerance int    `json:"tolerance,omitempty"`
+		WithImage bool   `json:"withImage,omitempty"`
+	}{}
+	req.Token = getToken(r)
+	req.ImageID = mux.Vars(r)["imageID"]
+	req.OtherID = mux.Vars(r)["otherID"]
+
+	q := r.URL.Query()
+	var err error
+	if req.Tolerance, err = readIntQuery(q, "tolerance"); err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	if withImage := q.Get("image"); withImage != "" {
+		if req.WithImage, err = strconv.ParseBool(withImage); err != nil {
+			h.handleError(w, r, req, errors.NewClient("image must be true or false"))
+			return
+		}
+	}
+
+	diff, err := h.model.DiffImages(req.Token, req.ImageID, req.OtherID,
+		req.Tolerance, req.WithImage)
+
+	h.respondOn(w, r, req, diff, http.StatusOK, err)
+}
+
+/**
+ * @api {get} /meta List Image Metadata
+ * @apiName ImageMetas
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Query) {String=uploadDate,captureDate} [sortBy=uploadDate]
+ *	Order of the images, newest first.
+ * @apiParam (URL Query) {Number} [offset=0] Number of images to skip.
+ * @apiParam (URL Query) {Number} [count=10] Maximum number of images to return.
+ * @apiParam (URL Query) {String} [color] Hex colour e.g. ff8800. If provided
+ *	only images whose dominant colour is near it are listed, nearest first,
+ *	and sortBy is ignored.
+ * @apiParam (URL Query) {Number{0-441.7}} [colorDistance=64] Maximum euclidean
+ *	RGB distance between color and an image's dominant colour.
+ *
+ * @apiSuccess (200) {Object[]} metas Metadata of the owner's images.
+ * @apiSuccess (200) {String} metas.ID The image ID.
+ * @apiSuccess (200) {String} metas.userID The owner's user ID.
+ * @apiSuccess (200) {String} metas.folder The folder containing the image.
+ * @apiSuccess (200) {Number} metas.width Width in pixels.
+ * @apiSuccess (200) {Number} metas.height Height in pixels.
+ * @apiSuccess (200) {String} metas.type The image format e.g. jpeg.
+ * @apiSuccess (200) {String} metas.mimeType The image MIME type.
+ * @apiSuccess (200) {String} metas.URL The URL to the image.
+ * @apiSuccess (200) {String} [metas.pHash] Hex encoded 64 bit perceptual hash
+ *	of the image.
+ * @apiSuccess (200) {String} [metas.digest] Hex encoded SHA-256 digest of the
+ *	image content.
+ * @apiSuccess (200) {String} [metas.blurHash] BlurHash of the image.
+ * @apiSuccess (200) {String} [metas.LQIP] Data URI of a 16px wide low quality
+ *	version of the image.
+ * @apiSuccess (200) {Object[]} [metas.palette] Up to 5 of the image's most
+ *	prominent colours, heaviest first.
+ * @apiSuccess (200) {String} metas.palette.color Hex colour e.g. #ff8800.
+ * @apiSuccess (200) {Number} metas.palette.weight Fraction of the image's
+ *	pixels closest to color.
+ * @apiSuccess (200) {Number} [metas.size] Size in bytes of the stored image.
+ * @apiSuccess (200) {Number} [metas.originalSize] Size in bytes of the image as
+ *	uploaded. Larger than size if the upload was optimised.
+ * @apiSuccess (200) {String} [metas.colorSpace] Colour space of the ICC
+ *	profile embedded in the image e.g. "sRGB", "Adobe RGB (1998)" or
+ *	"Display P3". Not set if the image has no profile.
+ * @apiSuccess (200) {Object} [metas.animation] Set for GIF images.
+ * @apiSuccess (200) {Number} metas.animation.frames Number of frames.
+ * @apiSuccess (200) {Number} metas.animation.loopCount Times the animation
+ *	repeats, 0 means forever and -1 means it is shown once.
+ * @apiSuccess (200) {Number} metas.animation.duration Total display time of
+ *	all frames in milliseconds.
+ * @apiSuccess (200) {Object} [metas.exif] EXIF data (make, model, captureDate,
+ *	exposureTime, fNumber, ISO, focalLength, latitude, longitude) if the
+ *	image had any.
+ * @apiSuccess (200) {String} metas.createDate ISO8601 upload date.
+ * @apiSuccess (200) {String} metas.updateDate ISO8601 last update date.
+ *
+ */
+func (h *handler) imageMetas(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token         string  `json:"token,omitempty"`
+		SortBy        string  `json:"sortBy,omitempty"`
+		Color         string  `json:"color,omitempty"`
+		ColorDistance float64 `json:"colorDistance,omitempty"`
+		Offset        int64   `json:"offset,omitempty"`
+		Count         int64   `json:"count,omitempty"`
+	}{ColorDistance: 64}
+	req.Token = getToken(r)
+
+	q := r.URL.Query()
+	req.SortBy = q.Get("sortBy")
+	req.Color = q.Get("color")
+	if distStr := q.Get("colorDistance"); distStr != "" {
+		dist, err := strconv.ParseFloat(distStr, 64)
+		if err != nil {
+			h.handleError(w, r, req, errors.NewClient("colorDistance must be a number"))
+			return
+		}
+		req.ColorDistance = dist
+	}
+	offset, err := readIntQuery(q, "offset")
+	if err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	count, err := readIntQuery(q, "count")
+	if err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	if count == 0 {
+		count = 10
+	}
+	req.Offset, req.Count = int64(offset), int64(count)
+
+	var metas []model.ImageMeta
+	if req.Color != "" {
+		metas, err = h.model.ImageMetasByColor(req.Token, req.Color,
+			req.ColorDistance, req.Offset, req.Count)
+	} else {
+		metas, err = h.model.ImageMetas(req.Token, req.SortBy, req.Offset, req.Count)
+	}
+
+	h.respondOn(w, r, req, metas, http.StatusOK, err)
+}
+
+/**
+ * @api {get} /contactsheet Contact Sheet
+ * @apiName ContactSheet
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ * @apiDescription Lays out thumbnails of a folder's images in a single grid
+ *	image. Images that cannot be decoded are left out.
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Query) {String} [folder=general] The folder.
+ * @apiParam (URL Query) {String} [imageIDs] Comma separated IDs of the images
+ *	in folder to include, in order. All the folder's images, oldest first,
+ *	are included if not provided.
+ * @apiParam (URL Query) {Number} [tileSize=128] Width and height in pixels of
+ *	each tile, up to 512.
+ * @apiParam (URL Query) {Number} [columns=8] Number of tiles per row.
+ *	columns x tileSize cannot exceed 8192.
+ * @apiParam (URL Query) {String=jpeg,png} [format=jpeg] Format of the grid.
+ * @apiParam (URL Query) {Number} [offset=0] Number of the folder's images to
+ *	skip.
+ * @apiParam (URL Query) {Number} [count=400] Maximum number of images, up to
+ *	400.
+ *
+ * @apiSuccess (200) {String} image Data URI of the grid image.
+ * @apiSuccess (200) {Number} width Width of the grid image in pixels.
+ * @apiSuccess (200) {Number} height Height of the grid image in pixels.
+ * @apiSuccess (200) {Object[]} tiles The tiles in the grid, row by row.
+ * @apiSuccess (200) {String} tiles.imageID ID of the image in the tile.
+ * @apiSuccess (200) {Number} tiles.row Zero based row of the tile.
+ * @apiSuccess (200) {Number} tiles.column Zero based column of the tile.
+ * @apiSuccess (200) {Number} tiles.x Pixel offset of the tile's left edge.
+ * @apiSuccess (200) {Number} tiles.y Pixel offset of the tile's top edge.
+ *	Images are fit within their tile and centred.
+ *
+ */
+func (h *handler) contactSheet(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token    string   `json:"token,omitempty"`
+		Folder   string   `json:"folder,omitempty"`
+		ImageIDs []string `json:"imageIDs,omitempty"`
+		model.ContactSheetOptions
+	}{}
+	req.Token = getToken(r)
+
+	q := r.URL.Query()
+	req.Folder = q.Get("folder")
+	if IDs := q.Get("imageIDs"); IDs != "" {
+		req.ImageIDs = strings.Split(IDs, ",")
+	}
+	req.Format = strings.ToLower(q.Get("format"))
+	if req.Format == "jpg" {
+		req.Format = imaging.FormatJPEG
+	}
+	var err error
+	if req.TileSize, err = readIntQuery(q, "tileSize"); err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	if req.Columns, err = readIntQuery(q, "columns"); err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	offset, err := readIntQuery(q, "offset")
+	if err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	count, err := readIntQuery(q, "count")
+	if err != nil {
+		h.handleError(w, r, req, err)
+		return
+	}
+	req.Offset, req.Count = int64(offset), int64(count)
+
+	sheet, err := h.model.ContactSheet(req.Token, req.Folder, req.ImageIDs,
+		req.ContactSheetOptions)
+
+	h.respondOn(w, r, req, sheet, http.StatusOK, err)
+}
+
+/**
+ * @api {get} /meta/duplicates Find Near-Duplicate Images
+ * @apiName DuplicateClusters
+ * @apiVersion 0.1.0
+ * @apiPermission owner
+ * @apiGroup Service
+ *
+ * @apiHeader x-api-key the api key
+ * @apiHeader Authorization contains Bearer with JWT e.g. "Bearer jwt.val.here"
+ *
+ * @apiParam (URL Query) {Number{0-64}} [maxDistance=10] Maximum number of bits
+ *	by which the perceptual hashes of two near-duplicate images differ.
+ *
+ * @apiSuccess (200) {Object[][]} clusters Groups of the owner's images that
+ *	are near-duplicates of each other. Each image's metadata is as described
+ *	in List Image Metadata.
+ *
+ */
+func (h *handler) duplicateClusters(w http.ResponseWriter, r *http.Request) {
+
+	req := struct {
+		Token       string `json:"token,omitempty"`
+		MaxDistance int    `json:"maxDistance,omitempty"`
+	}{MaxDistance: 10}
+	req.Token = getToken(r)
+
+	q := r.URL.Query()
+	if q.Get("maxDistance") != "" {
+		maxDistance, err := readIntQuery(q, "maxDistance")
+		if err != nil {
+			h.handleError(w, r, req, err)
+			return
+		}
+		req.MaxDistance = maxDistance
+	}
+
+	clusters, err := h.model.DuplicateClusters(req.Token, req.MaxDistance)
+
+	h.respondOn(w, r, req, clusters, http.StatusOK, err)
+}
+
 func (h handler) notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Nothing to see here", http.StatusNotFound)
 }
@@ -325,6 +1022,37 @@ func (h *handler) respondOn(w http.ResponseWriter, r *http.Request, reqData inte
 	return i
 }
 
+// readMultipartImage reads the multipart form of r up to its "image" file
+// part which is returned unread so that the image is streamed rather than
+// buffered. The "folder" field, if it precedes the image, is read into
+// folder.
+func readMultipartImage(r *http.Request, folder *string) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.NewClientf("unable to read multipart form: %v", err)
+	}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.NewClient("image form-file was not provided")
+		}
+		if err != nil {
+			return nil, errors.NewClientf("unable to read multipart form: %v", err)
+		}
+		switch p.FormName() {
+		case "image":
+			return p, nil
+		case "folder":
+			val, err := ioutil.ReadAll(io.LimitReader(p, uploadOverheadBytes))
+			if err != nil {
+				return nil, errors.NewClientf("unable to read folder form-field: %v", err)
+			}
+			*folder = string(val)
+		}
+		p.Close()
+	}
+}
+
 func readJSONBody(r *http.Request, into interface{}) error {
 	bodyB, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -336,6 +1064,46 @@ func readJSONBody(r *http.Request, into interface{}) error {
 	return nil
 }
 
+func readTransformation(q url.Values) (model.Transformation, error) {
+	t := model.Transformation{
+		Fit:    imaging.Fit(q.Get("fit")),
+		Format: strings.ToLower(q.Get("format")),
+	}
+	if t.Format == "jpg" {
+		t.Format = imaging.FormatJPEG
+	}
+	var err error
+	if t.Width, err = readIntQuery(q, "w"); err != nil {
+		return t, err
+	}
+	if t.Height, err = readIntQuery(q, "h"); err != nil {
+		return t, err
+	}
+	if posterStr := q.Get("poster"); posterStr != "" {
+		if t.Poster, err = strconv.ParseBool(posterStr); err != nil {
+			return t, errors.NewClient("poster must be true or false")
+		}
+	}
+	if ops := q.Get("ops"); ops != "" {
+		if t.Filters, err = imaging.ParseFilters(ops); err != nil {
+			return t, errors.NewClientf("ops: %v", err)
+		}
+	}
+	return t, nil
+}
+
+func readIntQuery(q url.Values, key string) (int, error) {
+	valStr := q.Get(key)
+	if valStr == "" {
+		return 0, nil
+	}
+	val, err := strconv.Atoi(valStr)
+	if err != nil {
+		return 0, errors.NewClientf("%s must be a whole number", key)
+	}
+	return val, nil
+}
+
 func getToken(r *http.Request) string {
 	auths, exists := r.Header[headerAuthorization]
 	if !exists {
@@ -356,8 +1124,8 @@ func validateConfig(c Config) error {
 	if c == nil {
 		return errors.New("Config was nil")
 	}
-	if _, err := os.Stat(c.ImagesDir()); err != nil {
-		return errors.Newf("Unable to access images dir: %v", err)
+	if c.UploadMemoryBytes() <= 0 {
+		return errors.New("upload memory limit must be positive")
 	}
 	return nil
 }
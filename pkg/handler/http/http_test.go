@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+func TestReadJSONBody(t *testing.T) {
+	var clErrCheck errors.ClErrCheck
+	tt := []struct {
+		name     string
+		body     string
+		expOp    string
+		expClErr bool
+	}{
+		{name: "valid", body: `{"op":"rotate"}`, expOp: "rotate"},
+		{name: "invalid JSON", body: `{"op":`, expClErr: true},
+		{name: "body too large", body: `{"op":"` + strings.Repeat("a", editBodyBytes) + `"}`, expClErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/meta/1/edit", strings.NewReader(tc.body))
+			r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, editBodyBytes)
+			var into struct {
+				Op string `json:"op"`
+			}
+			err := readJSONBody(r, &into)
+			if tc.expClErr {
+				if !clErrCheck.IsClientError(err) {
+					t.Fatalf("Expected a client error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readJSONBody(): %v", err)
+			}
+			if into.Op != tc.expOp {
+				t.Errorf("Expected op '%s', got '%s'", tc.expOp, into.Op)
+			}
+		})
+	}
+}
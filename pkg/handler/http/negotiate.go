@@ -0,0 +1,88 @@
+package http
+
+import (
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiateFormat returns the image format that the image at imgPath should
+// be converted to in order to satisfy the accept header value, or an empty
+// string if the image's own format is acceptable or no acceptable format
+// can be produced.
+func negotiateFormat(accept, imgPath string) string {
+
+	if accept == "" {
+		return ""
+	}
+	format := strings.TrimPrefix(path.Ext(imgPath), ".")
+	mimeType := imaging.MimeType(format)
+	if mimeType == "" || format == imaging.FormatSVG {
+		// SVGs cannot be rasterised.
+		return ""
+	}
+
+	ranges := parseAccept(accept)
+	if acceptQ(ranges, mimeType) > 0 {
+		return ""
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, r := range ranges {
+		if r.q <= 0 {
+			break
+		}
+		f := imaging.FormatOf(r.mediaType)
+		if f != "" && imaging.EncodableFormat(f) == f {
+			return f
+		}
+	}
+	return ""
+}
+
+// acceptQ returns the quality value that ranges assign to mimeType using
+// the most specific matching range.
+func acceptQ(ranges []acceptRange, mimeType string) float64 {
+	mainType := strings.SplitN(mimeType, "/", 2)[0] + "/*"
+	q, specificity := 0.0, 0
+	for _, r := range ranges {
+		switch {
+		case r.mediaType == mimeType:
+			return r.q
+		case r.mediaType == mainType && specificity < 2:
+			q, specificity = r.q, 2
+		case r.mediaType == "*/*" && specificity < 1:
+			q, specificity = r.q, 1
+		}
+	}
+	return q
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		r := acceptRange{mediaType: mediaType, q: 1}
+		if qStr, ok := params["q"]; ok {
+			if r.q, err = strconv.ParseFloat(qStr, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
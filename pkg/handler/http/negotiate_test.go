@@ -0,0 +1,32 @@
+package http
+
+import "testing"
+
+func TestNegotiateFormat(t *testing.T) {
+	tt := []struct {
+		name      string
+		accept    string
+		imgPath   string
+		expFormat string
+	}{
+		{name: "no accept header", accept: "", imgPath: "/1/general/2.png", expFormat: ""},
+		{name: "browser default", accept: "image/webp,image/apng,image/*,*/*;q=0.8", imgPath: "/1/general/2.png", expFormat: ""},
+		{name: "exact match", accept: "image/png", imgPath: "/1/general/2.png", expFormat: ""},
+		{name: "convert to jpeg", accept: "image/jpeg", imgPath: "/1/general/2.png", expFormat: "jpeg"},
+		{name: "highest q wins", accept: "image/gif;q=0.5, image/jpeg;q=0.9", imgPath: "/1/general/2.png", expFormat: "jpeg"},
+		{name: "explicitly refused", accept: "image/png;q=0, image/*", imgPath: "/1/general/2.png", expFormat: ""},
+		{name: "refused with alternative", accept: "image/png;q=0, image/gif", imgPath: "/1/general/2.png", expFormat: "gif"},
+		{name: "unencodable preference skipped", accept: "image/webp, image/gif;q=0.1", imgPath: "/1/general/2.png", expFormat: "gif"},
+		{name: "not an image", accept: "image/jpeg", imgPath: "/1/general/", expFormat: ""},
+		{name: "nothing producible", accept: "text/html", imgPath: "/1/general/2.png", expFormat: ""},
+		{name: "svg never rasterised", accept: "image/png", imgPath: "/blobs/ab/abcd.svg", expFormat: ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			f := negotiateFormat(tc.accept, tc.imgPath)
+			if f != tc.expFormat {
+				t.Errorf("Format mismatch: expect '%s', got '%s'", tc.expFormat, f)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+// serveFile serves the stored file at imgPath, honouring Range and
+// conditional requests, or lists the files in the folder at imgPath.
+func (h *handler) serveFile(w http.ResponseWriter, r *http.Request, imgPath string) {
+	imgPath = path.Clean("/" + imgPath)[1:]
+	info, err := h.store.Stat(imgPath)
+	if err != nil {
+		if !h.store.IsNotFoundError(err) {
+			h.handleError(w, r, r.URL.Query(), errors.Newf("stat image: %v", err))
+			return
+		}
+		h.serveFolder(w, r, imgPath)
+		return
+	}
+	f := &storedFile{store: h.store, path: imgPath, size: info.Size}
+	defer f.Close()
+	http.ServeContent(w, r, path.Base(imgPath), info.ModTime, f)
+}
+
+// serveFolder lists the files and folders immediately within the folder
+// at folderPath as links.
+func (h *handler) serveFolder(w http.ResponseWriter, r *http.Request, folderPath string) {
+	files, folders, err := h.store.ListFolder(folderPath)
+	if err != nil {
+		h.handleError(w, r, r.URL.Query(), errors.Newf("list images: %v", err))
+		return
+	}
+	if len(files) == 0 && len(folders) == 0 {
+		h.handleError(w, r, r.URL.Query(), errors.NewNotFound("image not found"))
+		return
+	}
+	if r.URL.Path != "" && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, path.Base(f.Path))
+	}
+	for _, f := range folders {
+		names = append(names, path.Base(f)+"/")
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<pre>")
+	for _, name := range names {
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+// storedFile reads a stored file as an io.ReadSeeker for
+// http.ServeContent. Content is fetched from the current offset when first
+// read after a seek so serving a range does not read the whole file.
+type storedFile struct {
+	store  Storage
+	path   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *storedFile) Read(p []byte) (int, error) {
+	if f.body == nil {
+		if f.offset >= f.size {
+			return 0, io.EOF
+		}
+		body, err := f.store.Get(f.path, f.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *storedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Newf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != f.offset {
+		f.Close()
+		f.offset = offset
+	}
+	return offset, nil
+}
+
+func (f *storedFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/storage"
+)
+
+func TestServeFile(t *testing.T) {
+	s := storage.NewMemory()
+	for p, content := range map[string]string{
+		"blobs/ab/abcd.png":     "0123456789",
+		"1/general/2_thumb.png": "thumb",
+		"1/general/sub/3.png":   "sub",
+	} {
+		if err := s.Put(p, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Put(%s): %v", p, err)
+		}
+	}
+	h := &handler{store: s}
+	tt := []struct {
+		name     string
+		path     string
+		rangeH   string
+		expCode  int
+		expBody  string
+		expTypeP string
+	}{
+		{name: "whole", path: "/blobs/ab/abcd.png", expCode: http.StatusOK,
+			expBody: "0123456789", expTypeP: "image/png"},
+		{name: "range", path: "/blobs/ab/abcd.png", rangeH: "bytes=2-4",
+			expCode: http.StatusPartialContent, expBody: "234"},
+		{name: "suffix range", path: "/blobs/ab/abcd.png", rangeH: "bytes=-3",
+			expCode: http.StatusPartialContent, expBody: "789"},
+		{name: "folder", path: "/1/general/", expCode: http.StatusOK,
+			expBody:  "<pre>\n<a href=\"2_thumb.png\">2_thumb.png</a>\n<a href=\"sub/\">sub/</a>\n</pre>\n",
+			expTypeP: "text/html"},
+		{name: "folder redirect", path: "/1/general", expCode: http.StatusMovedPermanently},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.rangeH != "" {
+				r.Header.Set("Range", tc.rangeH)
+			}
+			w := httptest.NewRecorder()
+			h.serveFile(w, r, r.URL.Path)
+			if w.Code != tc.expCode {
+				t.Fatalf("Expected status %d, got %d", tc.expCode, w.Code)
+			}
+			if tc.expBody != "" && w.Body.String() != tc.expBody {
+				t.Errorf("Expected body '%s', got '%s'", tc.expBody, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.expTypeP) {
+				t.Errorf("Expected Content-Type '%s...', got '%s'", tc.expTypeP, ct)
+			}
+		})
+	}
+}
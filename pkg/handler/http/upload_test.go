@@ -0,0 +1,80 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadMultipartImage(t *testing.T) {
+	type field struct{ name, value string }
+	tt := []struct {
+		name      string
+		fields    []field
+		maxBytes  int64
+		expFolder string
+		expImage  string
+		expErr    bool
+	}{
+		{name: "folder then image", fields: []field{{"folder", "pets"}, {"image", "content"}},
+			expFolder: "pets", expImage: "content"},
+		{name: "image only", fields: []field{{"image", "content"}}, expImage: "content"},
+		{name: "unknown fields skipped", fields: []field{{"other", "x"}, {"image", "content"}},
+			expImage: "content"},
+		{name: "no image", fields: []field{{"folder", "pets"}}, expErr: true},
+		{name: "body too large", fields: []field{{"folder", strings.Repeat("a", 1024)}, {"image", "content"}},
+			maxBytes: 512, expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			for _, f := range tc.fields {
+				var fw io.Writer
+				var err error
+				if f.name == "image" {
+					fw, err = mw.CreateFormFile(f.name, "image.png")
+				} else {
+					fw, err = mw.CreateFormField(f.name)
+				}
+				if err != nil {
+					t.Fatalf("create form part: %v", err)
+				}
+				fw.Write([]byte(f.value))
+			}
+			mw.Close()
+			r := httptest.NewRequest(http.MethodPut, "/upload", body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			if tc.maxBytes > 0 {
+				r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, tc.maxBytes)
+			}
+
+			var folder string
+			img, err := readMultipartImage(r, &folder)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMultipartImage(): %v", err)
+			}
+			if folder != tc.expFolder {
+				t.Errorf("Expected folder '%s', got '%s'", tc.expFolder, folder)
+			}
+			content, err := ioutil.ReadAll(img)
+			if err != nil {
+				t.Fatalf("read image part: %v", err)
+			}
+			if string(content) != tc.expImage {
+				t.Errorf("Expected image '%s', got '%s'", tc.expImage, content)
+			}
+		})
+	}
+}
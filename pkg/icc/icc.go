@@ -0,0 +1,287 @@
+// Package icc identifies the colour spaces described by ICC profiles and
+// converts images in common wide gamut RGB colour spaces to sRGB.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// Colour spaces identified by Parse.
+const (
+	SRGB      = "sRGB"
+	AdobeRGB  = "Adobe RGB (1998)"
+	DisplayP3 = "Display P3"
+)
+
+// primariesTolerance is the largest difference between the XYZ values of
+// a profile's primaries and a known colour space's for them to match.
+const primariesTolerance = 0.01
+
+var errMalformed = errors.New("malformed ICC profile")
+
+// Profile describes an ICC profile.
+type Profile struct {
+	// ColorSpace is one of SRGB, AdobeRGB and DisplayP3 if the profile
+	// describes that colour space, otherwise it is empty.
+	ColorSpace string
+	// DataColorSpace is the profile's data colour space signature e.g.
+	// "RGB", "CMYK" or "GRAY".
+	DataColorSpace string
+	Description    string
+}
+
+// Name returns the name of the colour space the profile describes:
+// ColorSpace if known, otherwise Description or, if that is empty,
+// DataColorSpace.
+func (p Profile) Name() string {
+	switch {
+	case p.ColorSpace != "":
+		return p.ColorSpace
+	case p.Description != "":
+		return p.Description
+	}
+	return p.DataColorSpace
+}
+
+// knownPrimaries holds the D50 adapted XYZ values of the red, green and
+// blue primaries of known colour spaces as found in their profiles'
+// rXYZ, gXYZ and bXYZ tags.
+var knownPrimaries = map[string][3][3]float64{
+	SRGB:      {{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}},
+	AdobeRGB:  {{0.6097, 0.3111, 0.0195}, {0.2053, 0.6257, 0.0609}, {0.1492, 0.0632, 0.7446}},
+	DisplayP3: {{0.5151, 0.2412, -0.0011}, {0.2920, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841}},
+}
+
+// descriptionHints identify known colour spaces by the lower case profile
+// description when the profile has no primaries e.g. LUT based profiles.
+var descriptionHints = []struct{ hint, colorSpace string }{
+	{"srgb", SRGB},
+	{"adobe rgb", AdobeRGB},
+	{"adobergb", AdobeRGB},
+	{"display p3", DisplayP3},
+}
+
+// Parse reads the profile header and the tags needed to identify the
+// colour space the profile describes.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errMalformed
+	}
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	if count < 0 || 132+12*count > len(data) {
+		return nil, errMalformed
+	}
+	for i := 0; i < count; i++ {
+		entry := data[132+12*i:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 8 || offset+size > len(data) || offset+size < offset {
+			return nil, errMalformed
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	p := &Profile{
+		DataColorSpace: strings.TrimSpace(string(data[16:20])),
+		Description:    description(tags["desc"]),
+	}
+	if p.DataColorSpace != "RGB" {
+		return p, nil
+	}
+	var primaries [3][3]float64
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := readXYZ(tags[sig])
+		if !ok {
+			p.ColorSpace = colorSpaceByDescription(p.Description)
+			return p, nil
+		}
+		primaries[i] = xyz
+	}
+	for colorSpace, known := range knownPrimaries {
+		if primariesMatch(primaries, known) {
+			p.ColorSpace = colorSpace
+			break
+		}
+	}
+	return p, nil
+}
+
+func primariesMatch(a, b [3][3]float64) bool {
+	for i := range a {
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > primariesTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func colorSpaceByDescription(desc string) string {
+	desc = strings.ToLower(desc)
+	for _, h := range descriptionHints {
+		if strings.Contains(desc, h.hint) {
+			return h.colorSpace
+		}
+	}
+	return ""
+}
+
+// readXYZ reads the first value of an XYZType tag.
+func readXYZ(tag []byte) ([3]float64, bool) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range xyz {
+		xyz[i] = float64(int32(binary.BigEndian.Uint32(tag[8+4*i:]))) / 65536
+	}
+	return xyz, true
+}
+
+// description reads a textDescriptionType (version 2 profiles) or the
+// first record of a multiLocalizedUnicodeType (version 4 profiles) tag.
+func description(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	switch string(tag[:4]) {
+	case "desc":
+		l := int(binary.BigEndian.Uint32(tag[8:]))
+		if l < 0 || 12+l > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+l]), "\x00")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		l := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if l < 0 || offset < 0 || offset+l > len(tag) || offset+l < offset {
+			return ""
+		}
+		units := make([]uint16, l/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+// conversion holds what is needed to convert from an RGB colour space
+// to sRGB.
+type conversion struct {
+	// toLinear maps 8 bit encoded channel values to linear light.
+	toLinear [256]float64
+	// toSRGB maps linear RGB in the source colour space to linear sRGB.
+	toSRGB [3][3]float64
+}
+
+// xyzToLinearSRGB maps D65 XYZ to linear sRGB.
+var xyzToLinearSRGB = [3][3]float64{
+	{3.2404542, -1.5371385, -0.4985314},
+	{-0.9692660, 1.8760108, 0.0415560},
+	{0.0556434, -0.2040259, 1.0572252},
+}
+
+var conversions = map[string]*conversion{
+	AdobeRGB: newConversion(func(v float64) float64 { return math.Pow(v, 563.0/256) },
+		[3][3]float64{
+			{0.5767309, 0.1855540, 0.1881852},
+			{0.2973769, 0.6273491, 0.0752741},
+			{0.0270343, 0.0706872, 0.9911085},
+		}),
+	DisplayP3: newConversion(srgbToLinear,
+		[3][3]float64{
+			{0.4865709, 0.2656677, 0.1982173},
+			{0.2289746, 0.6917385, 0.0792869},
+			{0.0000000, 0.0451134, 1.0439444},
+		}),
+}
+
+// newConversion returns the conversion of an RGB colour space with the
+// transfer function toLinear and linear RGB to D65 XYZ matrix toXYZ.
+func newConversion(toLinear func(float64) float64, toXYZ [3][3]float64) *conversion {
+	c := &conversion{}
+	for i := range c.toLinear {
+		c.toLinear[i] = toLinear(float64(i) / 255)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				c.toSRGB[i][j] += xyzToLinearSRGB[i][k] * toXYZ[k][j]
+			}
+		}
+	}
+	return c
+}
+
+// linearToSRGBLen is the number of entries in linearToSRGB.
+const linearToSRGBLen = 4096
+
+// linearToSRGB maps linear light, quantised to linearToSRGBLen steps,
+// to 8 bit sRGB encoded channel values.
+var linearToSRGB = func() [linearToSRGBLen]uint8 {
+	var lut [linearToSRGBLen]uint8
+	for i := range lut {
+		v := float64(i) / (linearToSRGBLen - 1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		lut[i] = uint8(v*255 + 0.5)
+	}
+	return lut
+}()
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// CanConvert returns true if ToSRGB can convert from colorSpace.
+func CanConvert(colorSpace string) bool {
+	return conversions[colorSpace] != nil
+}
+
+// ToSRGB returns a copy of img, whose pixel values are in colorSpace,
+// with the pixel values converted to sRGB. Colours outside the sRGB gamut
+// are clipped. img is returned as is if CanConvert(colorSpace) is false.
+func ToSRGB(img image.Image, colorSpace string) image.Image {
+	c := conversions[colorSpace]
+	if c == nil {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		r := c.toLinear[dst.Pix[i]]
+		g := c.toLinear[dst.Pix[i+1]]
+		bl := c.toLinear[dst.Pix[i+2]]
+		for ch := 0; ch < 3; ch++ {
+			m := c.toSRGB[ch]
+			v := m[0]*r + m[1]*g + m[2]*bl
+			switch {
+			case v < 0:
+				v = 0
+			case v > 1:
+				v = 1
+			}
+			dst.Pix[i+ch] = linearToSRGB[int(v*(linearToSRGBLen-1)+0.5)]
+		}
+	}
+	return dst
+}
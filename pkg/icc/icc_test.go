@@ -0,0 +1,141 @@
+package icc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/tomogoma/imagems/pkg/icc"
+)
+
+func TestParse(t *testing.T) {
+	adobe := [3][3]float64{{0.6097, 0.3111, 0.0195}, {0.2053, 0.6257, 0.0609}, {0.1492, 0.0632, 0.7446}}
+	p3 := [3][3]float64{{0.5151, 0.2412, -0.0011}, {0.2920, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841}}
+	srgb := [3][3]float64{{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}}
+	custom := [3][3]float64{{0.7, 0.3, 0}, {0.1, 0.7, 0.1}, {0.1, 0, 0.8}}
+	tt := []struct {
+		name    string
+		in      []byte
+		expName string
+		expCS   string
+		expErr  bool
+	}{
+		{name: "adobe rgb", in: profile("RGB ", descV2("Compatible with Adobe RGB (1998)"), &adobe),
+			expName: icc.AdobeRGB, expCS: icc.AdobeRGB},
+		{name: "display p3 v4", in: profile("RGB ", descV4("Display P3"), &p3),
+			expName: icc.DisplayP3, expCS: icc.DisplayP3},
+		{name: "srgb", in: profile("RGB ", descV2("sRGB IEC61966-2.1"), &srgb),
+			expName: icc.SRGB, expCS: icc.SRGB},
+		{name: "by description", in: profile("RGB ", descV2("AdobeRGB1998"), nil),
+			expName: icc.AdobeRGB, expCS: icc.AdobeRGB},
+		{name: "unknown rgb", in: profile("RGB ", descV2("Wide Gamut"), &custom),
+			expName: "Wide Gamut"},
+		{name: "cmyk", in: profile("CMYK", nil, nil), expName: "CMYK"},
+		{name: "truncated", in: profile("RGB ", descV2("sRGB"), &srgb)[:140], expErr: true},
+		{name: "not a profile", in: make([]byte, 200), expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := icc.Parse(tc.in)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if p.Name() != tc.expName || p.ColorSpace != tc.expCS {
+				t.Errorf("Expected %s (%s), got %s (%s)",
+					tc.expName, tc.expCS, p.Name(), p.ColorSpace)
+			}
+		})
+	}
+}
+
+func TestToSRGB(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	src.Set(1, 0, color.NRGBA{R: 100, G: 150, B: 50, A: 128})
+
+	for _, cs := range []string{icc.AdobeRGB, icc.DisplayP3} {
+		t.Run(cs, func(t *testing.T) {
+			if !icc.CanConvert(cs) {
+				t.Fatalf("Expected %s to be convertible", cs)
+			}
+			img := icc.ToSRGB(src, cs)
+			grey := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+			if grey.R != grey.G || grey.G != grey.B {
+				t.Errorf("Expected grey to stay neutral, got %v", grey)
+			}
+			c := color.NRGBAModel.Convert(img.At(1, 0)).(color.NRGBA)
+			if int(c.G)-int(c.B) <= 100 || c.A != 128 {
+				t.Errorf("Expected a more saturated colour with alpha kept, got %v", c)
+			}
+		})
+	}
+	if icc.CanConvert(icc.SRGB) || icc.ToSRGB(src, icc.SRGB) != image.Image(src) {
+		t.Errorf("Expected sRGB images to be left as they are")
+	}
+}
+
+// profile returns a minimal ICC profile with the desc tag and the
+// rXYZ, gXYZ and bXYZ tags holding primaries if they are not nil.
+func profile(dataColorSpace string, desc []byte, primaries *[3][3]float64) []byte {
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	var tags []tag
+	if desc != nil {
+		tags = append(tags, tag{"desc", desc})
+	}
+	if primaries != nil {
+		for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+			b := &bytes.Buffer{}
+			b.WriteString("XYZ \x00\x00\x00\x00")
+			for _, v := range primaries[i] {
+				binary.Write(b, binary.BigEndian, int32(v*65536))
+			}
+			tags = append(tags, tag{sig, b.Bytes()})
+		}
+	}
+	header := make([]byte, 128)
+	copy(header[16:], dataColorSpace)
+	copy(header[36:], "acsp")
+	out := bytes.NewBuffer(header)
+	binary.Write(out, binary.BigEndian, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	for _, tg := range tags {
+		out.WriteString(tg.sig)
+		binary.Write(out, binary.BigEndian, []uint32{uint32(offset), uint32(len(tg.data))})
+		offset += len(tg.data)
+	}
+	for _, tg := range tags {
+		out.Write(tg.data)
+	}
+	return out.Bytes()
+}
+
+func descV2(s string) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString("desc\x00\x00\x00\x00")
+	binary.Write(b, binary.BigEndian, uint32(len(s)+1))
+	b.WriteString(s + "\x00")
+	return b.Bytes()
+}
+
+func descV4(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := &bytes.Buffer{}
+	b.WriteString("mluc\x00\x00\x00\x00")
+	binary.Write(b, binary.BigEndian, []uint32{1, 12})
+	b.WriteString("enUS")
+	binary.Write(b, binary.BigEndian, []uint32{uint32(2 * len(units)), 28})
+	binary.Write(b, binary.BigEndian, units)
+	return b.Bytes()
+}
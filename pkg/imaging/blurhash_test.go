@@ -0,0 +1,57 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestBlurHash(t *testing.T) {
+	red := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	draw.Draw(red, red.Bounds(), &image.Uniform{C: color.RGBA{R: 0xff, A: 0xff}},
+		image.Point{}, draw.Src)
+	tt := []struct {
+		name    string
+		img     image.Image
+		xC, yC  int
+		expErr  bool
+		expLen  int
+		expSize string
+		expDC   string
+	}{
+		// size flag 3+2*9=21 ("L"), DC 0xFF0000 ("TI:j").
+		{name: "solid", img: red, xC: 4, yC: 3, expLen: 28,
+			expSize: "L", expDC: "TI:j"},
+		{name: "gradient", img: horizontalGradient(64, 48, false), xC: 4, yC: 3,
+			expLen: 28},
+		{name: "dc only", img: red, xC: 1, yC: 1, expLen: 6, expSize: "0", expDC: "TI:j"},
+		{name: "too many components", img: red, xC: 10, yC: 3, expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, err := imaging.BlurHash(tc.img, tc.xC, tc.yC)
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if len(hash) != tc.expLen {
+				t.Errorf("Length mismatch: expect %d, got %d (%s)",
+					tc.expLen, len(hash), hash)
+			}
+			if tc.expSize != "" && hash[:1] != tc.expSize {
+				t.Errorf("Size flag mismatch: expect %s, got %s", tc.expSize, hash[:1])
+			}
+			if tc.expDC != "" && hash[2:6] != tc.expDC {
+				t.Errorf("DC mismatch: expect %s, got %s", tc.expDC, hash[2:6])
+			}
+		})
+	}
+}
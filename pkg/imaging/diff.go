@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// DiffStats summarises the differences between two images of equal size.
+// Channel differences are measured on the 0-255 scale of non-alpha-
+// premultiplied RGBA.
+type DiffStats struct {
+	// MAE is the mean absolute difference per channel.
+	MAE float64
+	// MSE is the mean squared difference per channel.
+	MSE float64
+	// Differing is the number of pixels that have a channel differing
+	// by more than the tolerance passed to Diff.
+	Differing int
+	Pixels    int
+}
+
+// PSNR returns the peak signal to noise ratio in decibels. It is
+// positive infinity for identical images.
+func (s DiffStats) PSNR() float64 {
+	if s.MSE == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/s.MSE)
+}
+
+// DifferingPercent returns the percentage of pixels that differ.
+func (s DiffStats) DifferingPercent() float64 {
+	if s.Pixels == 0 {
+		return 0
+	}
+	return 100 * float64(s.Differing) / float64(s.Pixels)
+}
+
+// diffHighlight is the colour of differing pixels in a diff image.
+var diffHighlight = color.NRGBA{R: 0xff, A: 0xff}
+
+// Diff compares a and b which must be of equal size. A pixel differs if
+// any of its channels differ by more than tolerance. If visualise is true
+// a diff image is also returned showing a faded grayscale a with
+// differing pixels highlighted in red.
+func Diff(a, b image.Image, tolerance uint8, visualise bool) (DiffStats, image.Image, error) {
+
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return DiffStats{}, nil, fmt.Errorf("image sizes differ: %dx%d and %dx%d",
+			ab.Dx(), ab.Dy(), bb.Dx(), bb.Dy())
+	}
+	an, bn := toNRGBA(a), toNRGBA(b)
+
+	var vis *image.NRGBA
+	if visualise {
+		vis = image.NewNRGBA(an.Bounds())
+	}
+	stats := DiffStats{Pixels: ab.Dx() * ab.Dy()}
+	var absSum, sqSum float64
+	for i := 0; i < len(an.Pix); i += 4 {
+		differs := false
+		for c := 0; c < 4; c++ {
+			d := int(an.Pix[i+c]) - int(bn.Pix[i+c])
+			if d < 0 {
+				d = -d
+			}
+			absSum += float64(d)
+			sqSum += float64(d * d)
+			if d > int(tolerance) {
+				differs = true
+			}
+		}
+		if differs {
+			stats.Differing++
+		}
+		if vis == nil {
+			continue
+		}
+		px := diffHighlight
+		if !differs {
+			y := 0.299*float64(an.Pix[i]) + 0.587*float64(an.Pix[i+1]) + 0.114*float64(an.Pix[i+2])
+			// fade towards white so that highlights stand out.
+			g := uint8(255 - (255-y)/4)
+			px = color.NRGBA{R: g, G: g, B: g, A: 0xff}
+		}
+		vis.Pix[i], vis.Pix[i+1], vis.Pix[i+2], vis.Pix[i+3] = px.R, px.G, px.B, px.A
+	}
+	if samples := float64(len(an.Pix)); samples > 0 {
+		stats.MAE, stats.MSE = absSum/samples, sqSum/samples
+	}
+	if vis == nil {
+		return stats, nil, nil
+	}
+	return stats, vis, nil
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
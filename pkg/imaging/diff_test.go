@@ -0,0 +1,69 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestDiff(t *testing.T) {
+	// 2x2 grey image and a copy with one pixel brightened.
+	a := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := range a.Pix {
+		a.Pix[i] = 100
+	}
+	b := image.NewNRGBA(a.Bounds())
+	copy(b.Pix, a.Pix)
+	b.Set(1, 1, color.NRGBA{R: 120, G: 100, B: 100, A: 100})
+
+	tt := []struct {
+		name         string
+		a, b         image.Image
+		tolerance    uint8
+		expDiffering int
+		expMAE       float64
+		expIdentical bool
+		expErr       bool
+	}{
+		{name: "identical", a: a, b: a, expIdentical: true},
+		{name: "one pixel", a: a, b: b, expDiffering: 1, expMAE: 20.0 / 16},
+		{name: "within tolerance", a: a, b: b, tolerance: 20, expMAE: 20.0 / 16},
+		{name: "size mismatch", a: a, b: image.NewNRGBA(image.Rect(0, 0, 2, 1)),
+			expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			stats, vis, err := imaging.Diff(tc.a, tc.b, tc.tolerance, true)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if stats.Differing != tc.expDiffering {
+				t.Errorf("Differing pixels mismatch: expect %d, got %d",
+					tc.expDiffering, stats.Differing)
+			}
+			if math.Abs(stats.MAE-tc.expMAE) > 1e-9 {
+				t.Errorf("MAE mismatch: expect %f, got %f", tc.expMAE, stats.MAE)
+			}
+			if identical := math.IsInf(stats.PSNR(), 1); identical != tc.expIdentical {
+				t.Errorf("Expected infinite PSNR %t, got %f", tc.expIdentical, stats.PSNR())
+			}
+			if vis == nil || vis.Bounds().Dx() != 2 || vis.Bounds().Dy() != 2 {
+				t.Fatalf("Expected a 2x2 diff image, got %v", vis)
+			}
+			highlighted := vis.At(1, 1) == color.Color(color.NRGBA{R: 0xff, A: 0xff})
+			if highlighted != (tc.expDiffering > 0) {
+				t.Errorf("Expected differing pixel highlighted %t, got %v",
+					tc.expDiffering > 0, vis.At(1, 1))
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Image formats as named by the image package's registered decoders.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatBMP  = "bmp"
+	FormatTIFF = "tiff"
+	FormatWebP = "webp"
+)
+
+// FormatSVG is the format of SVG images. Unlike the other formats it has
+// no registered decoder so SVGs are only ever served as uploaded.
+const FormatSVG = "svg"
+
+// DefaultJPEGQuality is the quality used when encoding JPEGs if none
+// is provided.
+const DefaultJPEGQuality = 90
+
+// EncodeOptions are the encoding parameters used by Encode.
+type EncodeOptions struct {
+	// JPEGQuality ranges from 1 to 100 inclusive, higher is better.
+	// Zero means DefaultJPEGQuality.
+	JPEGQuality int
+	// Background is the colour that transparent regions are flattened onto
+	// when encoding to a format without alpha support. Nil means white.
+	Background color.Color
+	// PNGCompression is the zlib compression level of PNGs.
+	PNGCompression png.CompressionLevel
+	// JPEGProgressive writes JPEGs as progressive rather than baseline so
+	// that a coarse image shows while the rest loads.
+	JPEGProgressive bool
+}
+
+var mimeTypes = map[string]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatGIF:  "image/gif",
+	FormatBMP:  "image/bmp",
+	FormatTIFF: "image/tiff",
+	FormatWebP: "image/webp",
+	FormatSVG:  "image/svg+xml",
+}
+
+// mimeTypeAliases maps non-standard but commonly declared MIME types
+// to their format.
+var mimeTypeAliases = map[string]string{
+	"image/jpg":      FormatJPEG,
+	"image/pjpeg":    FormatJPEG,
+	"image/x-png":    FormatPNG,
+	"image/x-bmp":    FormatBMP,
+	"image/x-ms-bmp": FormatBMP,
+	"image/x-tiff":   FormatTIFF,
+}
+
+// Encode writes img to w in format. A nil o means default options.
+func Encode(w io.Writer, img image.Image, format string, o *EncodeOptions) error {
+	if o == nil {
+		o = &EncodeOptions{}
+	}
+	switch format {
+	case FormatJPEG:
+		q := o.JPEGQuality
+		if q == 0 {
+			q = DefaultJPEGQuality
+		}
+		bg := o.Background
+		if bg == nil {
+			bg = color.White
+		}
+		if o.JPEGProgressive {
+			return encodeProgressiveJPEG(w, Flatten(img, bg), q)
+		}
+		return jpeg.Encode(w, Flatten(img, bg), &jpeg.Options{Quality: q})
+	case FormatPNG:
+		enc := &png.Encoder{CompressionLevel: o.PNGCompression}
+		return enc.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	case FormatBMP:
+		return bmp.Encode(w, img)
+	case FormatTIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	}
+	return fmt.Errorf("encoding to %s is not supported", format)
+}
+
+// Flatten composites img over a solid bg returning an opaque image.
+func Flatten(img image.Image, bg color.Color) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(bg), b.Min, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+// ParseHexColor parses colours of the form #rgb or #rrggbb, the leading
+// '#' being optional.
+func ParseHexColor(s string) (color.Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return nil, fmt.Errorf("colour must be in the form #rrggbb or #rgb")
+	}
+	c, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("colour must be in the form #rrggbb or #rgb")
+	}
+	return color.RGBA{R: uint8(c >> 16), G: uint8(c >> 8), B: uint8(c), A: 0xff}, nil
+}
+
+// EncodableFormat returns format if Encode supports it, otherwise the
+// format that derived images of format should be encoded to.
+func EncodableFormat(format string) string {
+	switch format {
+	case FormatJPEG, FormatPNG, FormatGIF, FormatBMP, FormatTIFF:
+		return format
+	}
+	return FormatPNG
+}
+
+// MimeType returns the MIME type of format or an empty string
+// if format is not known.
+func MimeType(format string) string {
+	return mimeTypes[format]
+}
+
+// FormatOf returns the format described by mimeType or an empty string
+// if mimeType does not describe a known image format. Parameters in
+// mimeType are ignored.
+func FormatOf(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+	mediaType = strings.ToLower(mediaType)
+	if format, ok := mimeTypeAliases[mediaType]; ok {
+		return format
+	}
+	for format, mt := range mimeTypes {
+		if mt == mediaType {
+			return format
+		}
+	}
+	return ""
+}
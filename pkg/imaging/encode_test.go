@@ -0,0 +1,132 @@
+package imaging_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestFormatOf(t *testing.T) {
+	tt := []struct {
+		name      string
+		mimeType  string
+		expFormat string
+	}{
+		{name: "jpeg", mimeType: "image/jpeg", expFormat: imaging.FormatJPEG},
+		{name: "jpeg alias", mimeType: "image/jpg", expFormat: imaging.FormatJPEG},
+		{name: "bmp alias", mimeType: "image/x-ms-bmp", expFormat: imaging.FormatBMP},
+		{name: "tiff", mimeType: "image/tiff", expFormat: imaging.FormatTIFF},
+		{name: "webp", mimeType: "image/webp", expFormat: imaging.FormatWebP},
+		{name: "with params", mimeType: "image/PNG; charset=binary", expFormat: imaging.FormatPNG},
+		{name: "unknown", mimeType: "text/plain", expFormat: ""},
+		{name: "empty", mimeType: "", expFormat: ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if f := imaging.FormatOf(tc.mimeType); f != tc.expFormat {
+				t.Errorf("Format mismatch: expect '%s', got '%s'", tc.expFormat, f)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	tt := []struct {
+		format    string
+		expFormat string
+	}{
+		{format: imaging.FormatJPEG, expFormat: imaging.FormatJPEG},
+		{format: imaging.FormatPNG, expFormat: imaging.FormatPNG},
+		{format: imaging.FormatGIF, expFormat: imaging.FormatGIF},
+		{format: imaging.FormatBMP, expFormat: imaging.FormatBMP},
+		{format: imaging.FormatTIFF, expFormat: imaging.FormatTIFF},
+		{format: imaging.FormatWebP, expFormat: imaging.FormatPNG},
+	}
+	for _, tc := range tt {
+		t.Run(tc.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			format := imaging.EncodableFormat(tc.format)
+			if err := imaging.Encode(buf, src, format, nil); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			conf, actFormat, err := image.DecodeConfig(buf)
+			if err != nil {
+				t.Fatalf("Decode encoded image: %v", err)
+			}
+			if actFormat != tc.expFormat {
+				t.Errorf("Format mismatch: expect '%s', got '%s'",
+					tc.expFormat, actFormat)
+			}
+			if conf.Width != 12 || conf.Height != 8 {
+				t.Errorf("Size mismatch: expect 12x8, got %dx%d",
+					conf.Width, conf.Height)
+			}
+		})
+	}
+}
+
+func TestEncode_progressiveJPEG(t *testing.T) {
+	tt := []struct {
+		name string
+		w, h int
+	}{
+		{name: "whole MCUs", w: 32, h: 16},
+		{name: "partial MCUs", w: 37, h: 21},
+		{name: "single pixel", w: 1, h: 1},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tc.w, tc.h))
+			for y := 0; y < tc.h; y++ {
+				for x := 0; x < tc.w; x++ {
+					src.Set(x, y, color.RGBA{R: uint8(255 * x / tc.w), G: uint8(255 * y / tc.h),
+						B: 0x80, A: 0xff})
+				}
+			}
+			buf := &bytes.Buffer{}
+			o := &imaging.EncodeOptions{JPEGQuality: 95, JPEGProgressive: true}
+			if err := imaging.Encode(buf, src, imaging.FormatJPEG, o); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if !bytes.Contains(buf.Bytes(), []byte{0xff, 0xc2}) {
+				t.Errorf("Expected a progressive (SOF2) frame")
+			}
+			if bytes.Contains(buf.Bytes(), []byte{0xff, 0xc0}) {
+				t.Errorf("Expected no baseline (SOF0) frame")
+			}
+			decoded, err := jpeg.Decode(buf)
+			if err != nil {
+				t.Fatalf("Decode encoded image: %v", err)
+			}
+			stats, _, err := imaging.Diff(src, decoded, 0, false)
+			if err != nil {
+				t.Fatalf("Diff: %v", err)
+			}
+
+			// progressive scans hold the same coefficients as a baseline
+			// encoding so lose no more detail.
+			buf.Reset()
+			o.JPEGProgressive = false
+			if err := imaging.Encode(buf, src, imaging.FormatJPEG, o); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			baseline, err := jpeg.Decode(buf)
+			if err != nil {
+				t.Fatalf("Decode encoded image: %v", err)
+			}
+			expStats, _, err := imaging.Diff(src, baseline, 0, false)
+			if err != nil {
+				t.Fatalf("Diff: %v", err)
+			}
+			if stats.MAE > expStats.MAE+1 {
+				t.Errorf("Expected a mean difference near baseline's %f, got %f",
+					expStats.MAE, stats.MAE)
+			}
+		})
+	}
+}
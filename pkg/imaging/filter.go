@@ -0,0 +1,239 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Filter names.
+const (
+	FilterGrayscale  = "grayscale"
+	FilterBlur       = "blur"
+	FilterSharpen    = "sharpen"
+	FilterBrightness = "brightness"
+	FilterContrast   = "contrast"
+)
+
+// MaxFilters is the largest number of filters ParseFilters accepts.
+const MaxFilters = 10
+
+// Filter is a pixel operation applied by ApplyFilters.
+type Filter struct {
+	// Name is one of the Filter... values.
+	Name string
+	// Arg is the blur radius (sigma) in pixels, the sharpen amount or the
+	// brightness or contrast adjustment as a percentage from -100 to 100.
+	// It is ignored by FilterGrayscale.
+	Arg float64
+}
+
+// filterArgRanges holds the inclusive range of each filter's Arg.
+var filterArgRanges = map[string][2]float64{
+	FilterGrayscale:  {0, 0},
+	FilterBlur:       {0, 20},
+	FilterSharpen:    {0, 10},
+	FilterBrightness: {-100, 100},
+	FilterContrast:   {-100, 100},
+}
+
+// Validate returns an error if f is unknown or its Arg is out of range.
+func (f Filter) Validate() error {
+	r, ok := filterArgRanges[f.Name]
+	if !ok {
+		return fmt.Errorf("unknown filter '%s'", f.Name)
+	}
+	if f.Arg < r[0] || f.Arg > r[1] {
+		return fmt.Errorf("%s must be between %g and %g", f.Name, r[0], r[1])
+	}
+	return nil
+}
+
+// ParseFilters parses a comma separated list of filters of the form
+// name[:arg] e.g. "grayscale,blur:2,contrast:-5".
+func ParseFilters(s string) ([]Filter, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) > MaxFilters {
+		return nil, fmt.Errorf("at most %d filters may be applied", MaxFilters)
+	}
+	fs := make([]Filter, len(parts))
+	for i, part := range parts {
+		nameArg := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		f := Filter{Name: strings.ToLower(nameArg[0])}
+		if len(nameArg) == 2 {
+			var err error
+			if f.Arg, err = strconv.ParseFloat(nameArg[1], 64); err != nil {
+				return nil, fmt.Errorf("%s takes a number", f.Name)
+			}
+		}
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+		fs[i] = f
+	}
+	return fs, nil
+}
+
+// ApplyFilters applies fs to img in order.
+func ApplyFilters(img image.Image, fs []Filter) image.Image {
+	for _, f := range fs {
+		switch f.Name {
+		case FilterGrayscale:
+			img = Grayscale(img)
+		case FilterBlur:
+			img = Blur(img, f.Arg)
+		case FilterSharpen:
+			img = Sharpen(img, f.Arg)
+		case FilterBrightness:
+			img = Brightness(img, f.Arg)
+		case FilterContrast:
+			img = Contrast(img, f.Arg)
+		}
+	}
+	return img
+}
+
+// Grayscale converts img to shades of grey preserving its alpha.
+func Grayscale(img image.Image) image.Image {
+	return mapChannels(img, func(r, g, b float64) (float64, float64, float64) {
+		y := 0.299*r + 0.587*g + 0.114*b
+		return y, y, y
+	})
+}
+
+// Brightness shifts every colour channel of img by pct percent of the
+// full range; pct ranges from -100 (black) to 100 (white).
+func Brightness(img image.Image, pct float64) image.Image {
+	shift := 255 * pct / 100
+	return mapChannels(img, func(r, g, b float64) (float64, float64, float64) {
+		return r + shift, g + shift, b + shift
+	})
+}
+
+// Contrast scales the distance of every colour channel of img from mid
+// grey by 1+pct/100; pct ranges from -100 (flat grey) to 100 (double).
+func Contrast(img image.Image, pct float64) image.Image {
+	f := 1 + pct/100
+	return mapChannels(img, func(r, g, b float64) (float64, float64, float64) {
+		return (r-128)*f + 128, (g-128)*f + 128, (b-128)*f + 128
+	})
+}
+
+// Blur applies a gaussian blur of standard deviation sigma pixels to img.
+func Blur(img image.Image, sigma float64) image.Image {
+	src := toRGBA(img)
+	if sigma <= 0 {
+		return src
+	}
+	k := gaussianKernel(sigma)
+	return convolve(convolve(src, k, true), k, false)
+}
+
+// Sharpen sharpens img by adding amount times the difference between img
+// and a slightly blurred copy of it (an unsharp mask).
+func Sharpen(img image.Image, amount float64) image.Image {
+	src := toRGBA(img)
+	blurred := convolve(convolve(src, gaussianKernel(1), true), gaussianKernel(1), false)
+	dst := image.NewRGBA(src.Bounds())
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := src.Pix[i+3]
+		for c := 0; c < 3; c++ {
+			v := float64(src.Pix[i+c])
+			v += amount * (v - float64(blurred.Pix[i+c]))
+			// colours are alpha premultiplied so cannot exceed alpha.
+			dst.Pix[i+c] = clampChannel(math.Min(v, float64(a)))
+		}
+		dst.Pix[i+3] = a
+	}
+	return dst
+}
+
+// mapChannels returns a copy of img with fn applied to the colour of each
+// pixel. fn receives and returns non-alpha-premultiplied channel values
+// in the range 0-255; returned values are clamped to that range.
+func mapChannels(img image.Image, fn func(r, g, b float64) (float64, float64, float64)) image.Image {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		r, g, bl := fn(float64(dst.Pix[i]), float64(dst.Pix[i+1]), float64(dst.Pix[i+2]))
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = clampChannel(r), clampChannel(g), clampChannel(bl)
+	}
+	return dst
+}
+
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		x := float64(i - radius)
+		k[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// convolve applies the one dimensional kernel k to src horizontally or
+// vertically, extending src's edge pixels as necessary.
+func convolve(src *image.RGBA, k []float64, horizontal bool) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(b)
+	radius := len(k) / 2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum [4]float64
+			for i, weight := range k {
+				sx, sy := x, y
+				if horizontal {
+					sx = clampInt(x+i-radius, 0, w-1)
+				} else {
+					sy = clampInt(y+i-radius, 0, h-1)
+				}
+				off := sy*src.Stride + sx*4
+				for c := range sum {
+					sum[c] += weight * float64(src.Pix[off+c])
+				}
+			}
+			off := y*dst.Stride + x*4
+			for c := range sum {
+				dst.Pix[off+c] = clampChannel(sum[c])
+			}
+		}
+	}
+	return dst
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+func clampChannel(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestParseFilters(t *testing.T) {
+	tt := []struct {
+		name   string
+		in     string
+		exp    []imaging.Filter
+		expErr bool
+	}{
+		{
+			name: "chain",
+			in:   "grayscale,blur:2,sharpen:1,brightness:10,contrast:-5",
+			exp: []imaging.Filter{
+				{Name: imaging.FilterGrayscale},
+				{Name: imaging.FilterBlur, Arg: 2},
+				{Name: imaging.FilterSharpen, Arg: 1},
+				{Name: imaging.FilterBrightness, Arg: 10},
+				{Name: imaging.FilterContrast, Arg: -5},
+			},
+		},
+		{name: "unknown", in: "sepia", expErr: true},
+		{name: "bad arg", in: "blur:much", expErr: true},
+		{name: "out of range", in: "brightness:101", expErr: true},
+		{name: "too many", in: "grayscale,grayscale,grayscale,grayscale," +
+			"grayscale,grayscale,grayscale,grayscale,grayscale,grayscale,grayscale",
+			expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, err := imaging.ParseFilters(tc.in)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if !reflect.DeepEqual(fs, tc.exp) {
+				t.Errorf("Filters mismatch:\nexpect %+v\ngot    %+v", tc.exp, fs)
+			}
+		})
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	// 2x1 image with a red pixel next to a blue one.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 200, A: 0xff})
+	src.Set(1, 0, color.RGBA{B: 200, A: 0xff})
+	tt := []struct {
+		name   string
+		filter imaging.Filter
+		check  func(l, r color.NRGBA) bool
+	}{
+		{name: "grayscale", filter: imaging.Filter{Name: imaging.FilterGrayscale},
+			check: func(l, r color.NRGBA) bool { return l.R == l.G && l.G == l.B && r.R == r.B }},
+		{name: "brightness", filter: imaging.Filter{Name: imaging.FilterBrightness, Arg: 100},
+			check: func(l, r color.NRGBA) bool { return l == color.NRGBA{255, 255, 255, 255} }},
+		{name: "contrast", filter: imaging.Filter{Name: imaging.FilterContrast, Arg: -100},
+			check: func(l, r color.NRGBA) bool { return l == r }},
+		{name: "blur", filter: imaging.Filter{Name: imaging.FilterBlur, Arg: 1},
+			check: func(l, r color.NRGBA) bool { return l.B > 0 && r.R > 0 }},
+		{name: "sharpen", filter: imaging.Filter{Name: imaging.FilterSharpen, Arg: 1},
+			check: func(l, r color.NRGBA) bool { return l.R > 200 && r.B > 200 }},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			img := imaging.ApplyFilters(src, []imaging.Filter{tc.filter})
+			if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+				t.Fatalf("Size mismatch: expect 2x1, got %dx%d", b.Dx(), b.Dy())
+			}
+			l := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA)
+			r := color.NRGBAModel.Convert(img.At(1, 0)).(color.NRGBA)
+			if !tc.check(l, r) {
+				t.Errorf("Unexpected pixels %v and %v", l, r)
+			}
+		})
+	}
+}
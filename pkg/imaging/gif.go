@@ -0,0 +1,196 @@
+package imaging
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// Animation describes the timing of an animated image.
+type Animation struct {
+	// Frames is the number of frames.
+	Frames int
+	// LoopCount is the number of times the animation repeats; 0 means
+	// forever and -1 means the animation is shown once.
+	LoopCount int
+	// Duration is the total display time of all frames in milliseconds.
+	Duration int
+}
+
+// GIFAnimation returns the Animation of g.
+func GIFAnimation(g *gif.GIF) Animation {
+	a := Animation{Frames: len(g.Image), LoopCount: g.LoopCount}
+	for _, d := range g.Delay {
+		// GIF delays are in hundredths of a second.
+		a.Duration += d * 10
+	}
+	return a
+}
+
+// EachGIFFrame calls fn with each frame of g, in order, composited over
+// the frames before it according to their disposal methods i.e. as each
+// frame is displayed. All frames are composited onto the same canvas so
+// the image passed to fn is only valid until fn returns and must not be
+// modified.
+func EachGIFFrame(g *gif.GIF, fn func(i int, frame image.Image)) {
+
+	canvasR := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if canvasR.Empty() {
+		for _, f := range g.Image {
+			canvasR = canvasR.Union(f.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(canvasR)
+	// previous holds the canvas to restore after a frame disposed of
+	// with DisposalPrevious. It is only allocated if such a frame exists.
+	var previous *image.RGBA
+
+	for i, f := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(canvasR)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, f.Bounds(), f, f.Bounds().Min, draw.Over)
+		fn(i, canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, f.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+}
+
+// MapGIF returns a copy of g with fn applied to every frame as it is
+// displayed. fn must return frames of equal size and must not keep the
+// frame it is passed, see EachGIFFrame. Each resulting frame covers the
+// whole image and is quantised to its source frame's palette.
+func MapGIF(g *gif.GIF, fn func(image.Image) image.Image) *gif.GIF {
+
+	out := &gif.GIF{
+		Delay:     g.Delay,
+		LoopCount: g.LoopCount,
+		Disposal:  make([]byte, len(g.Image)),
+	}
+	EachGIFFrame(g, func(i int, frame image.Image) {
+		frame = fn(frame)
+		b := frame.Bounds()
+		pal := withTransparent(g.Image[i].Palette)
+		dst := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), pal)
+		draw.Draw(dst, dst.Bounds(), frame, b.Min, draw.Src)
+		out.Image = append(out.Image, dst)
+		// frames are complete so clear each before drawing the next.
+		out.Disposal[i] = gif.DisposalBackground
+		if i == 0 {
+			out.Config = image.Config{ColorModel: pal, Width: b.Dx(), Height: b.Dy()}
+		}
+	})
+	return out
+}
+
+// GIFFrameCount counts the frames of the GIF read from r without decoding
+// them so that GIFs with too many frames to hold in memory can be refused
+// before gif.DecodeAll.
+func GIFFrameCount(r io.Reader) (int, error) {
+
+	br := bufio.NewReader(r)
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, fmt.Errorf("read header: %v", err)
+	}
+	if string(header[:6]) != "GIF87a" && string(header[:6]) != "GIF89a" {
+		return 0, errors.New("not a GIF")
+	}
+	if err := skipColorTable(br, header[10]); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("read block: %v", err)
+		}
+		switch block {
+		case 0x21: // extension
+			if _, err := br.ReadByte(); err != nil {
+				return 0, fmt.Errorf("read extension: %v", err)
+			}
+		case 0x2c: // image descriptor
+			frames++
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(br, desc); err != nil {
+				return 0, fmt.Errorf("read image descriptor: %v", err)
+			}
+			if err := skipColorTable(br, desc[8]); err != nil {
+				return 0, err
+			}
+			// LZW minimum code size.
+			if _, err := br.ReadByte(); err != nil {
+				return 0, fmt.Errorf("read image data: %v", err)
+			}
+		case 0x3b: // trailer
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("unknown block type 0x%02x", block)
+		}
+		if err := skipSubBlocks(br); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// skipColorTable skips the colour table described by flags, the packed
+// fields of a logical screen or image descriptor, if there is one.
+func skipColorTable(br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	if _, err := br.Discard(3 << ((flags & 0x07) + 1)); err != nil {
+		return fmt.Errorf("read colour table: %v", err)
+	}
+	return nil
+}
+
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		n, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read sub-block: %v", err)
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := br.Discard(int(n)); err != nil {
+			return fmt.Errorf("read sub-block: %v", err)
+		}
+	}
+}
+
+// withTransparent returns p with a fully transparent colour added if it
+// has none and there is room for it.
+func withTransparent(p color.Palette) color.Palette {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return p
+		}
+	}
+	if len(p) >= 256 {
+		return p
+	}
+	return append(append(color.Palette{}, p...), color.RGBA{})
+}
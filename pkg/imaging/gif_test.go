@@ -0,0 +1,127 @@
+package imaging_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestGIFAnimation(t *testing.T) {
+	g := animatedGIF()
+	a := imaging.GIFAnimation(g)
+	exp := imaging.Animation{Frames: 2, LoopCount: 3, Duration: 150}
+	if a != exp {
+		t.Errorf("Animation mismatch: expect %+v, got %+v", exp, a)
+	}
+}
+
+func TestEachGIFFrame(t *testing.T) {
+	g := animatedGIF()
+	g.Disposal = []byte{gif.DisposalNone, gif.DisposalPrevious}
+	g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), g.Image[0].Palette))
+	var seen []color.Color
+	imaging.EachGIFFrame(g, func(i int, frame image.Image) {
+		if i != len(seen) {
+			t.Errorf("Expected frame %d, got %d", len(seen), i)
+		}
+		if frame.Bounds() != image.Rect(0, 0, 4, 4) {
+			t.Errorf("Frame %d bounds mismatch: got %v", i, frame.Bounds())
+		}
+		seen = append(seen, frame.At(2, 3))
+		if i != 1 {
+			return
+		}
+		// the second frame only covers the bottom half, the red top
+		// half of the first frame must show through.
+		if r, _, _, _ := frame.At(2, 0).RGBA(); r != 0xffff {
+			t.Errorf("Expected first frame to show through second frame")
+		}
+	})
+	if len(seen) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(seen))
+	}
+	if _, _, b, _ := seen[1].RGBA(); b != 0xffff {
+		t.Errorf("Expected second frame to be drawn")
+	}
+	// the second frame is disposed of by restoring the first.
+	if r, _, _, _ := seen[2].RGBA(); r != 0xffff {
+		t.Errorf("Expected the canvas before the second frame to be restored")
+	}
+}
+
+func TestGIFFrameCount(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, animatedGIF()); err != nil {
+		t.Fatalf("encode test GIF: %v", err)
+	}
+	data := buf.Bytes()
+	tt := []struct {
+		name      string
+		data      []byte
+		expFrames int
+		expErr    bool
+	}{
+		{name: "animated", data: data, expFrames: 2},
+		{name: "truncated", data: data[:len(data)-8], expErr: true},
+		{name: "not a GIF", data: []byte("\x89PNG\r\n\x1a\n-not-a-gif"), expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := imaging.GIFFrameCount(bytes.NewReader(tc.data))
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %d frames", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("imaging.GIFFrameCount(): %v", err)
+			}
+			if n != tc.expFrames {
+				t.Errorf("Expected %d frames, got %d", tc.expFrames, n)
+			}
+		})
+	}
+}
+
+func TestMapGIF(t *testing.T) {
+	g := animatedGIF()
+	out := imaging.MapGIF(g, func(img image.Image) image.Image {
+		return imaging.Resize(img, 2, 2, imaging.FitFill)
+	})
+	if len(out.Image) != 2 {
+		t.Fatalf("Expected 2 frames, got %d", len(out.Image))
+	}
+	for i, f := range out.Image {
+		if f.Bounds() != image.Rect(0, 0, 2, 2) {
+			t.Errorf("Frame %d bounds mismatch: got %v", i, f.Bounds())
+		}
+	}
+	if out.LoopCount != g.LoopCount {
+		t.Errorf("LoopCount mismatch: expect %d, got %d", g.LoopCount, out.LoopCount)
+	}
+	if out.Config.Width != 2 || out.Config.Height != 2 {
+		t.Errorf("Config size mismatch: got %dx%d", out.Config.Width, out.Config.Height)
+	}
+}
+
+// animatedGIF returns a 4x4 GIF whose first frame is red and whose second
+// frame paints only the bottom half blue.
+func animatedGIF() *gif.GIF {
+	pal := color.Palette{color.RGBA{R: 0xff, A: 0xff}, color.RGBA{B: 0xff, A: 0xff}}
+	red := image.NewPaletted(image.Rect(0, 0, 4, 4), pal)
+	blue := image.NewPaletted(image.Rect(0, 2, 4, 4), pal)
+	for i := range blue.Pix {
+		blue.Pix[i] = 1
+	}
+	return &gif.GIF{
+		Image:     []*image.Paletted{red, blue},
+		Delay:     []int{10, 5},
+		LoopCount: 3,
+		Config:    image.Config{ColorModel: pal, Width: 4, Height: 4},
+	}
+}
@@ -0,0 +1,38 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// DHash computes the 64 bit difference hash of img. Each bit records
+// whether a pixel of a 9x8 grayscale thumbnail of img is brighter than
+// its right neighbour so visually similar images have hashes that differ
+// in few bits regardless of size, format or compression.
+func DHash(img image.Image) uint64 {
+	thumb := Resize(img, 9, 8, FitFill)
+	b := thumb.Bounds()
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := luminance(thumb.At(b.Min.X+x, b.Min.Y+y))
+			right := luminance(thumb.At(b.Min.X+x+1, b.Min.Y+y))
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of bits that differ between
+// hashes a and b.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func luminance(c color.Color) uint32 {
+	return uint32(color.GrayModel.Convert(c).(color.Gray).Y)
+}
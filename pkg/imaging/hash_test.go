@@ -0,0 +1,55 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestDHash(t *testing.T) {
+	gradient := horizontalGradient(360, 240, false)
+	tt := []struct {
+		name        string
+		img         image.Image
+		maxDistance int
+		minDistance int
+	}{
+		{name: "same image", img: gradient, maxDistance: 0},
+		{name: "resized", img: imaging.Resize(gradient, 90, 60, imaging.FitFill), maxDistance: 4},
+		{name: "different image", img: horizontalGradient(360, 240, true), minDistance: 32},
+	}
+	expHash := imaging.DHash(gradient)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			d := imaging.HammingDistance(expHash, imaging.DHash(tc.img))
+			if d > tc.maxDistance && tc.minDistance == 0 {
+				t.Errorf("Expected distance <= %d, got %d", tc.maxDistance, d)
+			}
+			if d < tc.minDistance {
+				t.Errorf("Expected distance >= %d, got %d", tc.minDistance, d)
+			}
+		})
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	if d := imaging.HammingDistance(0xF0, 0x0F); d != 8 {
+		t.Errorf("Expected distance 8, got %d", d)
+	}
+}
+
+func horizontalGradient(w, h int, reverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if reverse {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
@@ -0,0 +1,106 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Rotate90 rotates img 90 degrees clockwise.
+func Rotate90(img image.Image) image.Image {
+	return remap(img, true, func(x, y, w, h int) (int, int) {
+		return h - 1 - y, x
+	})
+}
+
+// Rotate180 rotates img 180 degrees.
+func Rotate180(img image.Image) image.Image {
+	return remap(img, false, func(x, y, w, h int) (int, int) {
+		return w - 1 - x, h - 1 - y
+	})
+}
+
+// Rotate270 rotates img 270 degrees clockwise (90 anti-clockwise).
+func Rotate270(img image.Image) image.Image {
+	return remap(img, true, func(x, y, w, h int) (int, int) {
+		return y, w - 1 - x
+	})
+}
+
+// FlipH mirrors img about its vertical axis.
+func FlipH(img image.Image) image.Image {
+	return remap(img, false, func(x, y, w, h int) (int, int) {
+		return w - 1 - x, y
+	})
+}
+
+// FlipV mirrors img about its horizontal axis.
+func FlipV(img image.Image) image.Image {
+	return remap(img, false, func(x, y, w, h int) (int, int) {
+		return x, h - 1 - y
+	})
+}
+
+// Transpose mirrors img about its top-left to bottom-right diagonal.
+func Transpose(img image.Image) image.Image {
+	return remap(img, true, func(x, y, w, h int) (int, int) {
+		return y, x
+	})
+}
+
+// Transverse mirrors img about its top-right to bottom-left diagonal.
+func Transverse(img image.Image) image.Image {
+	return remap(img, true, func(x, y, w, h int) (int, int) {
+		return h - 1 - y, w - 1 - x
+	})
+}
+
+// Orient transforms img so that it displays upright given its EXIF
+// orientation tag value o. Values outside 2-8 return img as is.
+func Orient(img image.Image, o int) image.Image {
+	switch o {
+	case 2:
+		return FlipH(img)
+	case 3:
+		return Rotate180(img)
+	case 4:
+		return FlipV(img)
+	case 5:
+		return Transpose(img)
+	case 6:
+		return Rotate90(img)
+	case 7:
+		return Transverse(img)
+	case 8:
+		return Rotate270(img)
+	}
+	return img
+}
+
+// OrientationSwapsAxes returns true if applying EXIF orientation o swaps
+// an image's width and height.
+func OrientationSwapsAxes(o int) bool {
+	return o >= 5 && o <= 8
+}
+
+// remap copies each pixel (x, y) of img to dst(x, y) in a new image whose
+// width and height are swapped if swap is true.
+func remap(img image.Image, swap bool, dst func(x, y, w, h int) (int, int)) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	outR := image.Rect(0, 0, w, h)
+	if swap {
+		outR = image.Rect(0, 0, h, w)
+	}
+	out := image.NewRGBA(outR)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := dst(x, y, w, h)
+			si := src.PixOffset(x, y)
+			di := out.PixOffset(dx, dy)
+			copy(out.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return out
+}
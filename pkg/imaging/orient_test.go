@@ -0,0 +1,47 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestOrient(t *testing.T) {
+	// 3x2 image with a red top-left pixel.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	red := color.RGBA{R: 0xff, A: 0xff}
+	src.Set(0, 0, red)
+	tt := []struct {
+		orientation int
+		expW, expH  int
+		expRed      image.Point
+	}{
+		{orientation: 1, expW: 3, expH: 2, expRed: image.Pt(0, 0)},
+		{orientation: 2, expW: 3, expH: 2, expRed: image.Pt(2, 0)},
+		{orientation: 3, expW: 3, expH: 2, expRed: image.Pt(2, 1)},
+		{orientation: 4, expW: 3, expH: 2, expRed: image.Pt(0, 1)},
+		{orientation: 5, expW: 2, expH: 3, expRed: image.Pt(0, 0)},
+		{orientation: 6, expW: 2, expH: 3, expRed: image.Pt(1, 0)},
+		{orientation: 7, expW: 2, expH: 3, expRed: image.Pt(1, 2)},
+		{orientation: 8, expW: 2, expH: 3, expRed: image.Pt(0, 2)},
+	}
+	for _, tc := range tt {
+		t.Run(string(rune('0'+tc.orientation)), func(t *testing.T) {
+			img := imaging.Orient(src, tc.orientation)
+			b := img.Bounds()
+			if b.Dx() != tc.expW || b.Dy() != tc.expH {
+				t.Fatalf("Size mismatch: expect %dx%d, got %dx%d",
+					tc.expW, tc.expH, b.Dx(), b.Dy())
+			}
+			if imaging.OrientationSwapsAxes(tc.orientation) != (tc.expW != 3) {
+				t.Errorf("OrientationSwapsAxes mismatch")
+			}
+			r, _, _, _ := img.At(tc.expRed.X, tc.expRed.Y).RGBA()
+			if r != 0xffff {
+				t.Errorf("Expected red pixel at %v", tc.expRed)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package imaging
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// The progressive JPEG encoder splits the coefficients of each block over
+// several scans by spectral selection: the DC coefficients of all
+// components first then bands of AC coefficients one component at a time
+// so that a coarse image can be shown early. It uses the example
+// quantisation and Huffman tables of sections K.1 and K.3 of the spec,
+// like image/jpeg, and subsamples chroma 4:2:0.
+
+// progressiveScans are the scans written after the DC scan, each a
+// component index and the first and last zig-zag index of its band.
+var progressiveScans = [][3]int{{0, 1, 5}, {1, 1, 63}, {2, 1, 63}, {0, 6, 63}}
+
+// zigzag maps zig-zag indices to natural (row major) indices.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegQuant are the unscaled luminance and chrominance quantisation
+// tables in zig-zag order.
+var jpegQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26, 26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffmanSpec is a Huffman table as written to a DHT segment: the number
+// of codes of each length from 1 to 16 bits and the values coded, shortest
+// code first.
+type huffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// jpegHuffman are the luminance DC and AC and chrominance DC and AC
+// Huffman tables.
+var jpegHuffman = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCode is a Huffman code of size bits.
+type huffmanCode struct {
+	code uint32
+	size uint
+}
+
+// codes returns the Huffman codes of s indexed by the values they code.
+func (s huffmanSpec) codes() [256]huffmanCode {
+	var codes [256]huffmanCode
+	code, k := uint32(0), 0
+	for i, n := range s.counts {
+		for j := byte(0); j < n; j++ {
+			codes[s.values[k]] = huffmanCode{code: code, size: uint(i + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// dctCos[x][u] is the cosine of DCT basis function u at sample x scaled
+// by the basis function's normalisation.
+var dctCos = func() [8][8]float64 {
+	var c [8][8]float64
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c[x][u] = math.Cos(float64(2*x+1)*float64(u)*math.Pi/16) / 2
+			if u == 0 {
+				c[x][u] /= math.Sqrt2
+			}
+		}
+	}
+	return c
+}()
+
+// jpegComponent holds the quantised coefficients, in zig-zag order, of a
+// component's blocks padded to whole MCUs.
+type jpegComponent struct {
+	// blocksX and blocksY are the number of blocks spanning the
+	// component, excluding padding.
+	blocksX, blocksY int
+	// stride is the number of blocks in each row, including padding.
+	stride int
+	blocks [][64]int
+	// sampling is the number of blocks each MCU holds horizontally and
+	// vertically.
+	sampling int
+	table    int
+}
+
+// encodeProgressiveJPEG writes the opaque img to w as a progressive JPEG
+// at quality, which ranges from 1 to 100.
+func encodeProgressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return errors.New("image dimensions not supported by JPEG")
+	}
+	quant := scaledQuant(quality)
+	comps := quantisedComponents(img, quant)
+
+	bw := bufio.NewWriter(w)
+	e := &jpegWriter{w: bw}
+	e.write([]byte{0xff, 0xd8})
+	e.writeQuant(quant)
+	e.writeFrameHeader(b.Dx(), b.Dy(), comps)
+	e.writeHuffman()
+	e.writeDCScan(comps)
+	for _, s := range progressiveScans {
+		e.writeACScan(comps, s[0], s[1], s[2])
+	}
+	e.write([]byte{0xff, 0xd9})
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// scaledQuant scales the quantisation tables to quality the way libjpeg
+// and image/jpeg do.
+func scaledQuant(quality int) [2][64]int {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]int
+	for i := range jpegQuant {
+		for j, q := range jpegQuant[i] {
+			q = (q*scale + 50) / 100
+			if q < 1 {
+				q = 1
+			} else if q > 255 {
+				q = 255
+			}
+			quant[i][j] = q
+		}
+	}
+	return quant
+}
+
+// quantisedComponents converts img to Y, Cb and Cr components, halving
+// the chroma components' resolution, and transforms and quantises their
+// blocks. Samples beyond img's edges repeat the edge.
+func quantisedComponents(img image.Image, quant [2][64]int) [3]*jpegComponent {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	mcusX, mcusY := (w+15)/16, (h+15)/16
+
+	ys := make([]float64, 4*mcusX*mcusY*64)
+	cbs := make([]float64, len(ys))
+	crs := make([]float64, len(ys))
+	stride := 16 * mcusX
+	for y := 0; y < 16*mcusY; y++ {
+		sy := b.Min.Y + minInt(y, h-1)
+		for x := 0; x < stride; x++ {
+			c := color.RGBAModel.Convert(img.At(b.Min.X+minInt(x, w-1), sy)).(color.RGBA)
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			i := y*stride + x
+			ys[i], cbs[i], crs[i] = float64(yy), float64(cb), float64(cr)
+		}
+	}
+
+	luma := &jpegComponent{blocksX: (w + 7) / 8, blocksY: (h + 7) / 8,
+		stride: 2 * mcusX, sampling: 2}
+	luma.blocks = make([][64]int, 4*mcusX*mcusY)
+	for by := 0; by < 2*mcusY; by++ {
+		for bx := 0; bx < 2*mcusX; bx++ {
+			var samples [64]float64
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					samples[8*y+x] = ys[(8*by+y)*stride+8*bx+x]
+				}
+			}
+			luma.blocks[by*luma.stride+bx] = quantisedBlock(samples, quant[0])
+		}
+	}
+
+	chroma := func(plane []float64) *jpegComponent {
+		c := &jpegComponent{blocksX: ((w+1)/2 + 7) / 8, blocksY: ((h+1)/2 + 7) / 8,
+			stride: mcusX, sampling: 1, table: 1}
+		c.blocks = make([][64]int, mcusX*mcusY)
+		for by := 0; by < mcusY; by++ {
+			for bx := 0; bx < mcusX; bx++ {
+				var samples [64]float64
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						i := (16*by+2*y)*stride + 16*bx + 2*x
+						samples[8*y+x] = (plane[i] + plane[i+1] + plane[i+stride] +
+							plane[i+stride+1]) / 4
+					}
+				}
+				c.blocks[by*c.stride+bx] = quantisedBlock(samples, quant[1])
+			}
+		}
+		return c
+	}
+	return [3]*jpegComponent{luma, chroma(cbs), chroma(crs)}
+}
+
+// quantisedBlock returns the DCT coefficients of the samples of a block,
+// in natural order, quantised by quant and in zig-zag order.
+func quantisedBlock(samples [64]float64, quant [64]int) [64]int {
+	var rows [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < 8; x++ {
+				sum += (samples[8*y+x] - 128) * dctCos[x][u]
+			}
+			rows[8*y+u] = sum
+		}
+	}
+	var coeffs [64]int
+	for k, n := range zigzag {
+		u, v := n%8, n/8
+		var sum float64
+		for y := 0; y < 8; y++ {
+			sum += rows[8*y+u] * dctCos[y][v]
+		}
+		coeffs[k] = int(math.Round(sum / float64(quant[k])))
+	}
+	return coeffs
+}
+
+// jpegWriter writes JPEG segments and entropy coded data keeping the
+// first error encountered.
+type jpegWriter struct {
+	w     *bufio.Writer
+	err   error
+	bits  uint32
+	nBits uint
+}
+
+func (e *jpegWriter) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+// writeMarker writes the segment with marker and payload p.
+func (e *jpegWriter) writeMarker(marker byte, p []byte) {
+	n := len(p) + 2
+	e.write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	e.write(p)
+}
+
+func (e *jpegWriter) writeQuant(quant [2][64]int) {
+	p := make([]byte, 0, 2*65)
+	for i, q := range quant {
+		p = append(p, byte(i))
+		for _, v := range q {
+			p = append(p, byte(v))
+		}
+	}
+	e.writeMarker(0xdb, p)
+}
+
+// writeFrameHeader writes the SOF2 segment of a progressive DCT frame.
+func (e *jpegWriter) writeFrameHeader(w, h int, comps [3]*jpegComponent) {
+	p := []byte{8, byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(len(comps))}
+	for i, c := range comps {
+		p = append(p, byte(i+1), byte(c.sampling<<4|c.sampling), byte(c.table))
+	}
+	e.writeMarker(0xc2, p)
+}
+
+func (e *jpegWriter) writeHuffman() {
+	var p []byte
+	for i, s := range jpegHuffman {
+		// tables alternate between DC (class 0) and AC (class 1).
+		p = append(p, byte((i%2)<<4|i/2))
+		p = append(p, s.counts[:]...)
+		p = append(p, s.values...)
+	}
+	e.writeMarker(0xc4, p)
+}
+
+// writeScanHeader writes the SOS segment of a scan of the coefficients
+// from zig-zag index ss to se of comps.
+func (e *jpegWriter) writeScanHeader(comps []int, tables [3]int, ss, se int) {
+	p := []byte{byte(len(comps))}
+	for _, c := range comps {
+		p = append(p, byte(c+1), byte(tables[c]<<4|tables[c]))
+	}
+	p = append(p, byte(ss), byte(se), 0)
+	e.writeMarker(0xda, p)
+}
+
+// writeDCScan writes the DC coefficients of all components interleaved
+// MCU by MCU.
+func (e *jpegWriter) writeDCScan(comps [3]*jpegComponent) {
+	e.writeScanHeader([]int{0, 1, 2}, [3]int{0, 1, 1}, 0, 0)
+	dc := [2][256]huffmanCode{jpegHuffman[0].codes(), jpegHuffman[2].codes()}
+	var preds [3]int
+	mcusX, mcusY := comps[1].stride, len(comps[1].blocks)/comps[1].stride
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			for i, c := range comps {
+				for y := 0; y < c.sampling; y++ {
+					for x := 0; x < c.sampling; x++ {
+						bl := c.blocks[(my*c.sampling+y)*c.stride+mx*c.sampling+x]
+						e.writeValue(&dc[c.table], 0, bl[0]-preds[i])
+						preds[i] = bl[0]
+					}
+				}
+			}
+		}
+	}
+	e.flushBits()
+}
+
+// writeACScan writes the AC coefficients from zig-zag index ss to se of
+// the component at index comp block by block. Blocks of padding are not
+// part of single component scans.
+func (e *jpegWriter) writeACScan(comps [3]*jpegComponent, comp, ss, se int) {
+	c := comps[comp]
+	e.writeScanHeader([]int{comp}, [3]int{0, 1, 1}, ss, se)
+	ac := jpegHuffman[2*c.table+1].codes()
+	for by := 0; by < c.blocksY; by++ {
+		for bx := 0; bx < c.blocksX; bx++ {
+			bl := c.blocks[by*c.stride+bx]
+			run := 0
+			for k := ss; k <= se; k++ {
+				if bl[k] == 0 {
+					run++
+					continue
+				}
+				for ; run > 15; run -= 16 {
+					e.writeCode(ac[0xf0])
+				}
+				e.writeValue(&ac, run, bl[k])
+				run = 0
+			}
+			if run > 0 {
+				// end of band.
+				e.writeCode(ac[0x00])
+			}
+		}
+	}
+	e.flushBits()
+}
+
+// writeValue writes the code of run and v's size category followed by
+// v's bits.
+func (e *jpegWriter) writeValue(codes *[256]huffmanCode, run, v int) {
+	bits := v
+	if v < 0 {
+		v, bits = -v, v-1
+	}
+	size := uint(0)
+	for ; v > 0; v >>= 1 {
+		size++
+	}
+	e.writeCode(codes[run<<4|int(size)])
+	if size > 0 {
+		e.writeBits(uint32(bits)&(1<<size-1), size)
+	}
+}
+
+func (e *jpegWriter) writeCode(c huffmanCode) {
+	e.writeBits(c.code, c.size)
+}
+
+// writeBits writes the n least significant bits of bits stuffing a zero
+// byte after each 0xff byte.
+func (e *jpegWriter) writeBits(bits uint32, n uint) {
+	e.bits = e.bits<<n | bits
+	e.nBits += n
+	for e.nBits >= 8 {
+		e.nBits -= 8
+		b := byte(e.bits >> e.nBits)
+		if b == 0xff {
+			e.write([]byte{b, 0})
+		} else {
+			e.write([]byte{b})
+		}
+	}
+}
+
+// flushBits pads the last byte of a scan with one bits.
+func (e *jpegWriter) flushBits() {
+	if e.nBits > 0 {
+		e.writeBits(1<<(8-e.nBits)-1, 8-e.nBits)
+	}
+	e.bits = 0
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,103 @@
+package imaging
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Fit determines how an image is made to fit a target width and height
+// when both are provided.
+type Fit string
+
+const (
+	// FitContain scales the image to fit within the target box preserving
+	// aspect ratio. One side may end up smaller than requested.
+	FitContain Fit = "contain"
+	// FitCover scales the image to cover the target box preserving aspect
+	// ratio then crops the overflow about the center.
+	FitCover Fit = "cover"
+	// FitFill stretches the image to exactly the target box.
+	FitFill Fit = "fill"
+)
+
+// Valid returns true if f is one of the known Fit values.
+func (f Fit) Valid() bool {
+	switch f {
+	case FitContain, FitCover, FitFill:
+		return true
+	}
+	return false
+}
+
+// Resize scales img to w by h according to fit.
+// If only one of w or h is greater than zero, the other is computed
+// from the image's aspect ratio and fit is ignored. If neither is greater
+// than zero img is returned as is. An empty fit defaults to FitContain.
+func Resize(img image.Image, w, h int, fit Fit) image.Image {
+
+	srcR := img.Bounds()
+	sw, sh := srcR.Dx(), srcR.Dy()
+	if sw == 0 || sh == 0 || (w <= 0 && h <= 0) {
+		return img
+	}
+
+	if w <= 0 {
+		w = scaleDim(sw, h, sh)
+		return scale(img, srcR, w, h)
+	}
+	if h <= 0 {
+		h = scaleDim(sh, w, sw)
+		return scale(img, srcR, w, h)
+	}
+
+	switch fit {
+	case FitFill:
+		return scale(img, srcR, w, h)
+	case FitCover:
+		// crop the part of src that has the target aspect ratio
+		// then scale that.
+		cw, ch := sw, sh
+		if sw*h > sh*w {
+			cw = scaleDim(w, sh, h)
+		} else {
+			ch = scaleDim(h, sw, w)
+		}
+		x0 := srcR.Min.X + (sw-cw)/2
+		y0 := srcR.Min.Y + (sh-ch)/2
+		return scale(img, image.Rect(x0, y0, x0+cw, y0+ch), w, h)
+	default:
+		if sw*h > sh*w {
+			h = scaleDim(sh, w, sw)
+		} else {
+			w = scaleDim(sw, h, sh)
+		}
+		return scale(img, srcR, w, h)
+	}
+}
+
+// Crop returns the portion of img within r. r is relative to img's origin
+// and is clipped to img's bounds.
+func Crop(img image.Image, r image.Rectangle) image.Image {
+	b := img.Bounds()
+	r = r.Add(b.Min).Intersect(b)
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+func scale(img image.Image, srcR image.Rectangle, w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, srcR, draw.Src, nil)
+	return dst
+}
+
+// scaleDim returns dim scaled by num/denom rounded to the nearest integer
+// but never less than 1.
+func scaleDim(dim, num, denom int) int {
+	r := (dim*num + denom/2) / denom
+	if r < 1 {
+		return 1
+	}
+	return r
+}
@@ -0,0 +1,61 @@
+package imaging_test
+
+import (
+	"image"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	tt := []struct {
+		name string
+		w, h int
+		fit  imaging.Fit
+		expW int
+		expH int
+	}{
+		{name: "width only", w: 100, expW: 100, expH: 50},
+		{name: "height only", h: 100, expW: 200, expH: 100},
+		{name: "none", expW: 400, expH: 200},
+		{name: "contain wide box", w: 300, h: 300, fit: imaging.FitContain, expW: 300, expH: 150},
+		{name: "contain tall box", w: 100, h: 300, fit: imaging.FitContain, expW: 100, expH: 50},
+		{name: "default is contain", w: 300, h: 300, expW: 300, expH: 150},
+		{name: "cover", w: 300, h: 300, fit: imaging.FitCover, expW: 300, expH: 300},
+		{name: "fill", w: 50, h: 300, fit: imaging.FitFill, expW: 50, expH: 300},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			img := imaging.Resize(src, tc.w, tc.h, tc.fit)
+			b := img.Bounds()
+			if b.Dx() != tc.expW || b.Dy() != tc.expH {
+				t.Errorf("Size mismatch: expect %dx%d, got %dx%d",
+					tc.expW, tc.expH, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestCrop(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 10, 110, 60))
+	tt := []struct {
+		name string
+		r    image.Rectangle
+		expW int
+		expH int
+	}{
+		{name: "inside", r: image.Rect(10, 10, 30, 40), expW: 20, expH: 30},
+		{name: "overflow clipped", r: image.Rect(90, 40, 200, 200), expW: 10, expH: 10},
+		{name: "outside", r: image.Rect(200, 200, 300, 300), expW: 0, expH: 0},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			b := imaging.Crop(src, tc.r).Bounds()
+			if b.Dx() != tc.expW || b.Dy() != tc.expH {
+				t.Errorf("Size mismatch: expect %dx%d, got %dx%d",
+					tc.expW, tc.expH, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// Position is where on an image a watermark is placed.
+type Position string
+
+const (
+	PositionTopLeft     Position = "top-left"
+	PositionTop         Position = "top"
+	PositionTopRight    Position = "top-right"
+	PositionLeft        Position = "left"
+	PositionCenter      Position = "center"
+	PositionRight       Position = "right"
+	PositionBottomLeft  Position = "bottom-left"
+	PositionBottom      Position = "bottom"
+	PositionBottomRight Position = "bottom-right"
+)
+
+// Valid returns true if p is one of the known Position values.
+func (p Position) Valid() bool {
+	switch p {
+	case PositionTopLeft, PositionTop, PositionTopRight, PositionLeft,
+		PositionCenter, PositionRight, PositionBottomLeft, PositionBottom,
+		PositionBottomRight:
+		return true
+	}
+	return false
+}
+
+// WatermarkOptions determine how a watermark is composited onto an image.
+type WatermarkOptions struct {
+	Position Position
+	// Opacity of the watermark from 0 (invisible) to 1 (opaque).
+	Opacity float64
+	// Margin is the distance in pixels between the watermark and the
+	// image edges it is placed against.
+	Margin int
+	// Scale is the watermark's width as a fraction of the image's width.
+	// The watermark's aspect ratio is preserved.
+	Scale float64
+}
+
+// Watermark returns a copy of img with mark composited onto it
+// according to o.
+func Watermark(img, mark image.Image, o WatermarkOptions) image.Image {
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	markW := int(o.Scale*float64(b.Dx()) + 0.5)
+	if markW < 1 {
+		markW = 1
+	}
+	mark = Resize(mark, markW, 0, FitContain)
+	mb := mark.Bounds()
+
+	var x, y int
+	switch o.Position {
+	case PositionTopLeft, PositionLeft, PositionBottomLeft:
+		x = o.Margin
+	case PositionTopRight, PositionRight, PositionBottomRight:
+		x = b.Dx() - mb.Dx() - o.Margin
+	default:
+		x = (b.Dx() - mb.Dx()) / 2
+	}
+	switch o.Position {
+	case PositionTopLeft, PositionTop, PositionTopRight:
+		y = o.Margin
+	case PositionBottomLeft, PositionBottom, PositionBottomRight:
+		y = b.Dy() - mb.Dy() - o.Margin
+	default:
+		y = (b.Dy() - mb.Dy()) / 2
+	}
+
+	alpha := image.NewUniform(color.Alpha{A: uint8(o.Opacity*0xff + 0.5)})
+	r := image.Rect(x, y, x+mb.Dx(), y+mb.Dy())
+	draw.DrawMask(dst, r, mark, mb.Min, alpha, image.Point{}, draw.Over)
+	return dst
+}
@@ -0,0 +1,62 @@
+package imaging_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+func TestWatermark(t *testing.T) {
+	white := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	draw.Draw(white, white.Bounds(), image.White, image.Point{}, draw.Src)
+	black := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(black, black.Bounds(), image.Black, image.Point{}, draw.Src)
+	tt := []struct {
+		name      string
+		opts      imaging.WatermarkOptions
+		expMarked image.Point
+		expClean  image.Point
+		expGray   uint8
+	}{
+		{
+			name:      "bottom right opaque",
+			opts:      imaging.WatermarkOptions{Position: imaging.PositionBottomRight, Opacity: 1, Margin: 5, Scale: 0.2},
+			expMarked: image.Pt(90, 40),
+			expClean:  image.Pt(96, 46),
+			expGray:   0,
+		},
+		{
+			name:      "top left half opacity",
+			opts:      imaging.WatermarkOptions{Position: imaging.PositionTopLeft, Opacity: 0.5, Scale: 0.1},
+			expMarked: image.Pt(5, 5),
+			expClean:  image.Pt(11, 11),
+			expGray:   0x7f,
+		},
+		{
+			name:      "center",
+			opts:      imaging.WatermarkOptions{Position: imaging.PositionCenter, Opacity: 1, Scale: 0.1},
+			expMarked: image.Pt(50, 25),
+			expClean:  image.Pt(10, 10),
+			expGray:   0,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			img := imaging.Watermark(white, black, tc.opts)
+			if img.Bounds() != white.Bounds() {
+				t.Fatalf("Bounds mismatch: expect %v, got %v", white.Bounds(), img.Bounds())
+			}
+			marked := color.GrayModel.Convert(img.At(tc.expMarked.X, tc.expMarked.Y)).(color.Gray)
+			if diff := int(marked.Y) - int(tc.expGray); diff < -1 || diff > 1 {
+				t.Errorf("Expected gray %d at %v, got %d", tc.expGray, tc.expMarked, marked.Y)
+			}
+			clean := color.GrayModel.Convert(img.At(tc.expClean.X, tc.expClean.Y)).(color.Gray)
+			if clean.Y != 0xff {
+				t.Errorf("Expected white at %v, got %d", tc.expClean, clean.Y)
+			}
+		})
+	}
+}
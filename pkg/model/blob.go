@@ -0,0 +1,110 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+// blobsDir is the directory, relative to the images directory, in which
+// image content is stored addressed by its digest. User IDs are numeric
+// so it cannot clash with a user's directory.
+const blobsDir = "blobs"
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobPath returns the path, relative to the images directory, of the
+// content with the given digest. Blobs are fanned out into directories
+// named after the first two digest characters.
+func blobPath(digest, ext string) string {
+	return path.Join(blobsDir, digest[:2], digest+"."+ext)
+}
+
+// DeleteImage deletes the image identified by imageID if it belongs to
+// the owner of token. The image content is only removed once no other
+// image references it.
+func (m *Model) DeleteImage(token, imageID string) error {
+
+	meta, err := m.ImageMeta(token, imageID)
+	if err != nil {
+		return err
+	}
+	metaID, _ := strconv.ParseInt(meta.ID, 10, 64)
+
+	if err := m.deleteMeta(metaID); err != nil {
+		if m.db.IsNotFoundError(err) {
+			return errors.NewNotFound("image not found")
+		}
+		return errors.Newf("delete image: %v", err)
+	}
+
+	return m.removeVariants(*meta)
+}
+
+// removeVariants removes all variant files of meta.
+func (m *Model) removeVariants(meta ImageMeta) error {
+	prefix := path.Join(meta.UserID, meta.Folder, meta.ID+"_")
+	files, err := m.store.List(prefix)
+	if err != nil {
+		return errors.Newf("list variants: %v", err)
+	}
+	for _, f := range files {
+		// skip files in sub folders whose names begin with the prefix.
+		if strings.Contains(f.Path[len(prefix):], "/") {
+			continue
+		}
+		if err := m.store.Delete(f.Path); err != nil {
+			return errors.Newf("remove variant: %v", err)
+		}
+	}
+	return nil
+}
+
+// deleteMeta deletes the meta identified by metaID removing its content
+// from storage once the deletion is committed if it is no longer
+// referenced.
+func (m *Model) deleteMeta(metaID int64) error {
+	return m.db.DeleteMeta(metaID, m.removeBlob)
+}
+
+// removeBlob is a BlobFunc removing the content of an unreferenced blob
+// and its conversions. The release of the blob's last reference is
+// already committed so failing to remove the content is logged rather
+// than failing the request; the content is merely orphaned.
+func (m *Model) removeBlob(digest, ext string) error {
+	err := m.store.Delete(blobPath(digest, ext))
+	if err == nil {
+		err = m.removeConversions(digest)
+	}
+	if err != nil && m.log != nil {
+		m.log.WithField("digest", digest).
+			Errorf("remove unreferenced content: %v", err)
+	}
+	return nil
+}
+
+// readFile reads the whole stored file at imgPath returning a not found
+// error if there is none.
+func (m *Model) readFile(imgPath string) ([]byte, error) {
+	r, err := m.store.Get(imgPath, 0, -1)
+	if err != nil {
+		if m.store.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("image not found")
+		}
+		return nil, errors.Newf("open image: %v", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Newf("read image: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+// cacheDir is the directory, relative to the images directory, in which
+// images converted to other formats are kept so that each conversion is
+// only done once. Like blobsDir it cannot clash with a user's directory.
+// It is not served directly.
+const cacheDir = "cache"
+
+// isConversion returns true if t only converts the image to another format.
+func (t Transformation) isConversion() bool {
+	return t.Format != "" && t.Width == 0 && t.Height == 0 && !t.Poster &&
+		len(t.Filters) == 0
+}
+
+// conversionPath returns the path, relative to the images directory, of
+// the image with content data converted to format with wm, if not nil,
+// applied. The key covers every setting the conversion depends on.
+// Conversions are grouped by the digest of the content they
+// were converted from so that they are removed along with the content.
+func (m *Model) conversionPath(data []byte, format string, wm *watermark) string {
+	d := digest(data)
+	opts := fmt.Sprintf("%+v srgb:%t", *m.encOpts, m.convertSRGB)
+	if wm != nil {
+		opts += fmt.Sprintf("%+v", wm.Watermark)
+	}
+	return path.Join(conversionsDir(d), digest([]byte(opts))+"."+format)
+}
+
+// conversionsDir returns the directory, relative to the images directory,
+// of the conversions of the content with the given digest.
+func conversionsDir(digest string) string {
+	return path.Join(cacheDir, digest[:2], digest)
+}
+
+// readConversion reads the conversion at convPath returning nil if it is
+// yet to be made.
+func (m *Model) readConversion(convPath string) ([]byte, error) {
+	r, err := m.store.Get(convPath, 0, -1)
+	if err != nil {
+		if m.store.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, errors.Newf("open converted image: %v", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Newf("read converted image: %v", err)
+	}
+	return data, nil
+}
+
+// writeConversion stores the conversion data at convPath.
+func (m *Model) writeConversion(convPath string, data []byte) error {
+	if err := m.store.Put(convPath, bytes.NewReader(data), int64(len(data))); err != nil {
+		return errors.Newf("save converted image: %v", err)
+	}
+	return nil
+}
+
+// removeConversions removes all conversions of the content with the
+// given digest.
+func (m *Model) removeConversions(digest string) error {
+	prefix := conversionsDir(digest) + "/"
+	files, err := m.store.List(prefix)
+	if err != nil {
+		return errors.Newf("list converted images: %v", err)
+	}
+	for _, f := range files {
+		if err := m.store.Delete(f.Path); err != nil {
+			return errors.Newf("remove converted image: %v", err)
+		}
+	}
+	return nil
+}
+
+// isCachePath returns true if imgPath, relative to the images directory,
+// is within cacheDir.
+func isCachePath(imgPath string) bool {
+	return imgPath == cacheDir || strings.HasPrefix(imgPath, cacheDir+"/")
+}
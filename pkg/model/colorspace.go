@@ -0,0 +1,34 @@
+package model
+
+import (
+	"image"
+
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/icc"
+)
+
+// colorSpace returns the name of the colour space of the ICC profile
+// embedded in the JPEG or PNG image data, see icc.Profile.Name, or an
+// empty string if there is none.
+func colorSpace(data []byte) string {
+	profile, err := exif.ICCProfile(data)
+	if err != nil {
+		return ""
+	}
+	p, err := icc.Parse(profile)
+	if err != nil {
+		return ""
+	}
+	return p.Name()
+}
+
+// toSRGB converts img, whose pixels are in colorSpace, to sRGB if
+// m.convertSRGB is set and icc.ToSRGB supports colorSpace. Images derived
+// from stored images are encoded without an ICC profile so browsers
+// display them as sRGB; unconverted wide gamut pixels look washed out.
+func (m *Model) toSRGB(img image.Image, colorSpace string) image.Image {
+	if !m.convertSRGB {
+		return img
+	}
+	return icc.ToSRGB(img, colorSpace)
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/draw"
+	"strconv"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// Contact sheet limits and defaults.
+const (
+	DefaultTileSize      = 128
+	MaxTileSize          = 512
+	DefaultSheetColumns  = 8
+	MaxContactSheetTiles = 400
+)
+
+// ContactSheetOptions describe the layout of a contact sheet.
+// Zero values mean the defaults.
+type ContactSheetOptions struct {
+	// TileSize is the width and height in pixels of each tile.
+	TileSize int
+	Columns  int
+	// Format is imaging.FormatJPEG (the default) or imaging.FormatPNG.
+	Format string
+	// Offset and Count page through a folder's images, oldest first.
+	// They are ignored when images are selected by ID.
+	Offset int64
+	Count  int64
+}
+
+// ContactSheet is a grid of image thumbnails.
+type ContactSheet struct {
+	// Image is a data URI of the encoded grid.
+	Image  string             `json:"image"`
+	Width  int                `json:"width"`
+	Height int                `json:"height"`
+	Tiles  []ContactSheetTile `json:"tiles"`
+}
+
+// ContactSheetTile locates an image within a ContactSheet. X and Y are the
+// pixel coordinates of the tile's top-left corner. The image is fit
+// within the tile and centred.
+type ContactSheetTile struct {
+	ImageID string `json:"imageID"`
+	Row     int    `json:"row"`
+	Column  int    `json:"column"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+}
+
+func (o *ContactSheetOptions) validate() error {
+	if o.TileSize == 0 {
+		o.TileSize = DefaultTileSize
+	}
+	if o.Columns == 0 {
+		o.Columns = DefaultSheetColumns
+	}
+	if o.Format == "" {
+		o.Format = imaging.FormatJPEG
+	}
+	if o.Count == 0 {
+		o.Count = MaxContactSheetTiles
+	}
+	if o.TileSize < 1 || o.TileSize > MaxTileSize {
+		return errors.NewClientf("tile size must be between 1 and %d", MaxTileSize)
+	}
+	if o.Columns < 1 || o.Columns*o.TileSize > maxTransformDim {
+		return errors.NewClientf("columns must be positive and columns x tile size cannot exceed %d",
+			maxTransformDim)
+	}
+	if o.Format != imaging.FormatJPEG && o.Format != imaging.FormatPNG {
+		return errors.NewClientf("unsupported contact sheet format '%s'", o.Format)
+	}
+	if o.Offset < 0 || o.Count < 1 || o.Count > MaxContactSheetTiles {
+		return errors.NewClientf("offset cannot be negative and count must be between 1 and %d",
+			MaxContactSheetTiles)
+	}
+	return nil
+}
+
+// ContactSheet lays out thumbnails of the images in folder belonging to
+// the owner of token in a grid. If imageIDs is not empty only those
+// images are included, in that order. Images that cannot be decoded are
+// left out.
+func (m *Model) ContactSheet(token, folder string, imageIDs []string, o ContactSheetOptions) (*ContactSheet, error) {
+
+	t, err := m.validateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if folder == "" {
+		folder = m.defFolder
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if len(imageIDs) > MaxContactSheetTiles {
+		return nil, errors.NewClientf("at most %d images can be selected",
+			MaxContactSheetTiles)
+	}
+
+	metas, err := m.contactSheetMetas(t.UsrID, folder, imageIDs, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var thumbs []image.Image
+	sheet := &ContactSheet{}
+	for _, meta := range metas {
+		thumb, err := m.thumbnail(meta, o.TileSize)
+		if err != nil {
+			continue
+		}
+		i := len(thumbs)
+		row, col := i/o.Columns, i%o.Columns
+		sheet.Tiles = append(sheet.Tiles, ContactSheetTile{ImageID: meta.ID,
+			Row: row, Column: col, X: col * o.TileSize, Y: row * o.TileSize})
+		thumbs = append(thumbs, thumb)
+	}
+	if len(thumbs) == 0 {
+		return nil, errors.NewNotFound("no viewable images found")
+	}
+
+	cols := o.Columns
+	if len(thumbs) < cols {
+		cols = len(thumbs)
+	}
+	rows := (len(thumbs) + o.Columns - 1) / o.Columns
+	sheet.Width, sheet.Height = cols*o.TileSize, rows*o.TileSize
+	grid := image.NewNRGBA(image.Rect(0, 0, sheet.Width, sheet.Height))
+	for i, thumb := range thumbs {
+		tile := sheet.Tiles[i]
+		b := thumb.Bounds()
+		at := image.Pt(tile.X+(o.TileSize-b.Dx())/2, tile.Y+(o.TileSize-b.Dy())/2)
+		draw.Draw(grid, image.Rectangle{Min: at, Max: at.Add(b.Size())}, thumb, b.Min, draw.Src)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := imaging.Encode(buf, grid, o.Format, m.encOpts); err != nil {
+		return nil, errors.Newf("encode contact sheet: %v", err)
+	}
+	sheet.Image = "data:" + imaging.MimeType(o.Format) + ";base64," +
+		base64.StdEncoding.EncodeToString(buf.Bytes())
+	return sheet, nil
+}
+
+func (m *Model) contactSheetMetas(userID, folder string, imageIDs []string, o ContactSheetOptions) ([]ImageMeta, error) {
+
+	if len(imageIDs) == 0 {
+		metas, err := m.db.ImageMetasByFolder(userID, folder, o.Offset, o.Count)
+		if err != nil {
+			if m.db.IsNotFoundError(err) {
+				return nil, errors.NewNotFound("no images found in folder")
+			}
+			return nil, errors.Newf("get image metas: %v", err)
+		}
+		return metas, nil
+	}
+
+	metas := make([]ImageMeta, 0, len(imageIDs))
+	for _, imageID := range imageIDs {
+		ID, err := strconv.ParseInt(imageID, 10, 64)
+		if err != nil {
+			return nil, errors.NewNotFoundf("image %s not found", imageID)
+		}
+		meta, err := m.db.ImageMeta(ID)
+		if err != nil {
+			if m.db.IsNotFoundError(err) {
+				return nil, errors.NewNotFoundf("image %s not found", imageID)
+			}
+			return nil, errors.Newf("get image meta: %v", err)
+		}
+		if meta.UserID != userID || meta.Folder != folder {
+			return nil, errors.NewNotFoundf("image %s not found in folder", imageID)
+		}
+		metas = append(metas, *meta)
+	}
+	return metas, nil
+}
+
+// thumbnail decodes meta's image fitting it, upright, within a size x size
+// square. Animated images are represented by their first frame.
+func (m *Model) thumbnail(meta ImageMeta, size int) (image.Image, error) {
+	img, err := m.decodeUpright(meta)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Resize(img, size, size, imaging.FitContain), nil
+}
+
+// decodeUpright decodes meta's stored image applying any EXIF orientation
+// and converting it to sRGB if so configured. Animated images are
+// represented by their first frame.
+func (m *Model) decodeUpright(meta ImageMeta) (image.Image, error) {
+	data, err := m.readFile(metaPath(meta))
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if e, err := exif.Read(data); err == nil {
+		img = imaging.Orient(img, e.Orientation())
+	}
+	return m.toSRGB(img, meta.ColorSpace), nil
+}
@@ -0,0 +1,121 @@
+package model_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+func TestModel_ContactSheet(t *testing.T) {
+	m, _, _ := newModel(t, validConf())
+	colors := map[string]color.RGBA{
+		"1": {R: 255, A: 255},
+		"2": {G: 255, A: 255},
+		"3": {B: 255, A: 255},
+	}
+	for _, ID := range []string{"1", "2", "3"} {
+		if u := upload(t, m, "123", "shoot", encodePNG(t, 10, 10, colors[ID])); u.ID != ID {
+			t.Fatalf("Expected image ID %s, got %s", ID, u.ID)
+		}
+	}
+	upload(t, m, "123", "other", encodePNG(t, 10, 10, color.White))
+
+	type tile struct {
+		ID   string
+		x, y int
+	}
+	tt := []struct {
+		name           string
+		folder         string
+		imageIDs       []string
+		opts           model.ContactSheetOptions
+		expW, expH     int
+		expTiles       []tile
+		expClErr       bool
+		expNotFoundErr bool
+	}{
+		{name: "defaults", folder: "shoot", expW: 3 * model.DefaultTileSize, expH: model.DefaultTileSize,
+			expTiles: []tile{{"1", 0, 0}, {"2", model.DefaultTileSize, 0}, {"3", 2 * model.DefaultTileSize, 0}}},
+		{name: "wrapped", folder: "shoot", opts: model.ContactSheetOptions{TileSize: 20, Columns: 2},
+			expW: 40, expH: 40, expTiles: []tile{{"1", 0, 0}, {"2", 20, 0}, {"3", 0, 20}}},
+		{name: "paged", folder: "shoot", opts: model.ContactSheetOptions{TileSize: 20, Offset: 1, Count: 1},
+			expW: 20, expH: 20, expTiles: []tile{{"2", 0, 0}}},
+		{name: "selected", folder: "shoot", imageIDs: []string{"3", "1"},
+			opts: model.ContactSheetOptions{TileSize: 20}, expW: 40, expH: 20,
+			expTiles: []tile{{"3", 0, 0}, {"1", 20, 0}}},
+		{name: "selected from other folder", folder: "shoot", imageIDs: []string{"4"},
+			expNotFoundErr: true},
+		{name: "selected unknown", folder: "shoot", imageIDs: []string{"99"}, expNotFoundErr: true},
+		{name: "empty folder", folder: "empty", expNotFoundErr: true},
+		{name: "tile size too large", folder: "shoot",
+			opts: model.ContactSheetOptions{TileSize: model.MaxTileSize + 1}, expClErr: true},
+		{name: "too many columns", folder: "shoot",
+			opts: model.ContactSheetOptions{TileSize: model.MaxTileSize, Columns: 100}, expClErr: true},
+		{name: "unsupported format", folder: "shoot",
+			opts: model.ContactSheetOptions{Format: imaging.FormatGIF}, expClErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.opts.Format == "" {
+				tc.opts.Format = imaging.FormatPNG
+			}
+			sheet, err := m.ContactSheet("123", tc.folder, tc.imageIDs, tc.opts)
+			if tc.expClErr || tc.expNotFoundErr {
+				if errCheck.IsClientError(err) != tc.expClErr {
+					t.Errorf("Expected client error %t, got %v", tc.expClErr, err)
+				}
+				if errCheck.IsNotFoundError(err) != tc.expNotFoundErr {
+					t.Errorf("Expected not found error %t, got %v", tc.expNotFoundErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("model.ContactSheet(): %v", err)
+			}
+			if sheet.Width != tc.expW || sheet.Height != tc.expH {
+				t.Errorf("Expected %dx%d, got %dx%d", tc.expW, tc.expH, sheet.Width, sheet.Height)
+			}
+			if len(sheet.Tiles) != len(tc.expTiles) {
+				t.Fatalf("Expected %d tiles, got %+v", len(tc.expTiles), sheet.Tiles)
+			}
+
+			const prefix = "data:image/png;base64,"
+			if !strings.HasPrefix(sheet.Image, prefix) {
+				t.Fatalf("Expected a PNG data URI, got '%.30s...'", sheet.Image)
+			}
+			data, err := base64.StdEncoding.DecodeString(sheet.Image[len(prefix):])
+			if err != nil {
+				t.Fatalf("decode data URI: %v", err)
+			}
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode contact sheet: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tc.expW || b.Dy() != tc.expH {
+				t.Errorf("Expected a %dx%d image, got %v", tc.expW, tc.expH, b)
+			}
+
+			tileSize := tc.opts.TileSize
+			if tileSize == 0 {
+				tileSize = model.DefaultTileSize
+			}
+			for i, exp := range tc.expTiles {
+				got := sheet.Tiles[i]
+				if got.ImageID != exp.ID || got.X != exp.x || got.Y != exp.y {
+					t.Errorf("Expected tile %d to be %+v, got %+v", i, exp, got)
+				}
+				c := color.RGBAModel.Convert(img.At(exp.x+tileSize/2, exp.y+tileSize/2)).(color.RGBA)
+				if c != colors[exp.ID] {
+					t.Errorf("Expected tile %d to show image %s (%v), got %v",
+						i, exp.ID, colors[exp.ID], c)
+				}
+			}
+		})
+	}
+}
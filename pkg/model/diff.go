@@ -0,0 +1,89 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// ImageDiff holds similarity metrics between two images of equal size.
+type ImageDiff struct {
+	ImageID string `json:"imageID"`
+	OtherID string `json:"otherID"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	// MAE is the mean absolute difference per RGBA channel on a 0-255 scale.
+	MAE float64 `json:"mae"`
+	// PSNR is the peak signal to noise ratio in decibels. It is nil
+	// when the images are identical.
+	PSNR *float64 `json:"psnr"`
+	// DifferingPercent is the percentage of pixels with a channel
+	// differing by more than the requested tolerance.
+	DifferingPercent float64 `json:"differingPercent"`
+	DifferingPixels  int     `json:"differingPixels"`
+	// Image is a PNG data URI showing differing pixels in red over a
+	// faded grayscale copy of the first image. It is only set if requested.
+	Image string `json:"image,omitempty"`
+}
+
+// DiffImages compares the images identified by imageID and otherID as they
+// are displayed (i.e. with any EXIF orientation applied). Both images must
+// belong to the owner of token and be of equal dimensions. Animated images
+// are compared by their first frames. A pixel differs if any of its
+// channels differ by more than tolerance. If withImage is true a
+// visualisation of the differences is included in the result.
+func (m *Model) DiffImages(token, imageID, otherID string, tolerance int, withImage bool) (*ImageDiff, error) {
+
+	if tolerance < 0 || tolerance > math.MaxUint8 {
+		return nil, errors.NewClientf("tolerance must be between 0 and %d", math.MaxUint8)
+	}
+	meta, err := m.ImageMeta(token, imageID)
+	if err != nil {
+		return nil, err
+	}
+	other, err := m.ImageMeta(token, otherID)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := m.decodeUpright(*meta)
+	if err != nil {
+		return nil, errors.NewClientf("image %s cannot be compared", imageID)
+	}
+	otherImg, err := m.decodeUpright(*other)
+	if err != nil {
+		return nil, errors.NewClientf("image %s cannot be compared", otherID)
+	}
+	stats, vis, err := imaging.Diff(img, otherImg, uint8(tolerance), withImage)
+	if err != nil {
+		return nil, errors.NewClient(err)
+	}
+
+	b := img.Bounds()
+	diff := &ImageDiff{
+		ImageID:          imageID,
+		OtherID:          otherID,
+		Width:            b.Dx(),
+		Height:           b.Dy(),
+		MAE:              stats.MAE,
+		DifferingPercent: stats.DifferingPercent(),
+		DifferingPixels:  stats.Differing,
+	}
+	if psnr := stats.PSNR(); !math.IsInf(psnr, 1) {
+		diff.PSNR = &psnr
+	}
+	if vis == nil {
+		return diff, nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := imaging.Encode(buf, vis, imaging.FormatPNG, m.encOpts); err != nil {
+		return nil, errors.Newf("encode diff image: %v", err)
+	}
+	diff.Image = "data:" + imaging.MimeType(imaging.FormatPNG) + ";base64," +
+		base64.StdEncoding.EncodeToString(buf.Bytes())
+	return diff, nil
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// MaxHashDistance is the largest meaningful Hamming distance between two
+// perceptual hashes.
+const MaxHashDistance = 64
+
+func formatPHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// DuplicateClusters groups the images belonging to the owner of token
+// whose perceptual hashes are within maxDistance bits of each other.
+// Two images are in the same cluster if they are linked by a chain of
+// near-duplicates. Only clusters with more than one image are returned.
+func (m *Model) DuplicateClusters(token string, maxDistance int) ([][]ImageMeta, error) {
+
+	t, err := m.validateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxDistance < 0 || maxDistance > MaxHashDistance {
+		return nil, errors.NewClientf("distance must be between 0 and %d",
+			MaxHashDistance)
+	}
+
+	metas, err := m.db.HashedImageMetasByUserID(t.UsrID)
+	if err != nil {
+		if m.db.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("no images found")
+		}
+		return nil, errors.Newf("get image metas: %v", err)
+	}
+
+	hashes := make([]uint64, len(metas))
+	for i, meta := range metas {
+		if hashes[i], err = strconv.ParseUint(meta.PHash, 16, 64); err != nil {
+			return nil, errors.Newf("invalid hash for image %s: %v", meta.ID, err)
+		}
+		metas[i].URL = m.metaURL(meta)
+	}
+
+	parents := make([]int, len(metas))
+	for i := range parents {
+		parents[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parents[i] != i {
+			parents[i] = root(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if imaging.HammingDistance(hashes[i], hashes[j]) <= maxDistance {
+				parents[root(j)] = root(i)
+			}
+		}
+	}
+
+	// group by root preserving the order metas were returned in.
+	var clusters [][]ImageMeta
+	clusterIdx := make(map[int]int)
+	for i, meta := range metas {
+		r := root(i)
+		idx, ok := clusterIdx[r]
+		if !ok {
+			idx = len(clusters)
+			clusterIdx[r] = idx
+			clusters = append(clusters, nil)
+		}
+		clusters[idx] = append(clusters[idx], meta)
+	}
+	dups := make([][]ImageMeta, 0)
+	for _, c := range clusters {
+		if len(c) > 1 {
+			dups = append(dups, c)
+		}
+	}
+	return dups, nil
+}
@@ -0,0 +1,218 @@
+package model
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// Edit operations, see EditOp.
+const (
+	EditRotate = "rotate"
+	EditFlip   = "flip"
+	EditCrop   = "crop"
+)
+
+// Flip directions.
+const (
+	FlipHorizontal = "horizontal"
+	FlipVertical   = "vertical"
+)
+
+// EditOp is an alteration persisted onto a stored image.
+type EditOp struct {
+	// Op is one of the Edit... values.
+	Op string `json:"op"`
+	// Angle is the clockwise rotation in degrees of an EditRotate:
+	// 90, 180 or 270.
+	Angle int `json:"angle,omitempty"`
+	// Direction is the FlipHorizontal or FlipVertical direction of
+	// an EditFlip.
+	Direction string `json:"direction,omitempty"`
+	// X, Y, Width and Height describe the rectangle kept by an EditCrop
+	// in pixels of the image as it is after the preceding operations.
+	X      int `json:"x,omitempty"`
+	Y      int `json:"y,omitempty"`
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// validate checks that op can be applied to an image of size w x h
+// and returns the size of the resulting image.
+func (op EditOp) validate(w, h int) (int, int, error) {
+	switch op.Op {
+	case EditRotate:
+		switch op.Angle {
+		case 90, 270:
+			return h, w, nil
+		case 180:
+			return w, h, nil
+		}
+		return 0, 0, errors.NewClientf("cannot rotate by %d degrees, use 90, 180 or 270",
+			op.Angle)
+	case EditFlip:
+		if op.Direction != FlipHorizontal && op.Direction != FlipVertical {
+			return 0, 0, errors.NewClientf("unknown flip direction '%s'", op.Direction)
+		}
+		return w, h, nil
+	case EditCrop:
+		if op.Width < 1 || op.Height < 1 || op.X < 0 || op.Y < 0 ||
+			op.X+op.Width > w || op.Y+op.Height > h {
+			return 0, 0, errors.NewClientf("crop %dx%d+%d+%d does not fit within %dx%d",
+				op.Width, op.Height, op.X, op.Y, w, h)
+		}
+		return op.Width, op.Height, nil
+	}
+	return 0, 0, errors.NewClientf("unknown edit operation '%s'", op.Op)
+}
+
+func (op EditOp) apply(img image.Image) image.Image {
+	switch op.Op {
+	case EditRotate:
+		switch op.Angle {
+		case 90:
+			return imaging.Rotate90(img)
+		case 180:
+			return imaging.Rotate180(img)
+		case 270:
+			return imaging.Rotate270(img)
+		}
+	case EditFlip:
+		if op.Direction == FlipHorizontal {
+			return imaging.FlipH(img)
+		}
+		return imaging.FlipV(img)
+	case EditCrop:
+		return imaging.Crop(img, image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height))
+	}
+	return img
+}
+
+// EditImage applies ops in order to the image identified by imageID if it
+// belongs to the owner of token. The stored image is replaced by the
+// result and its variants regenerated. Any EXIF orientation is applied
+// first so that ops act on the image as it is displayed. The image keeps
+// its ID and URLs; its URL resolves to the edited content, which replaces
+// the previous content's reference in the same transaction.
+func (m *Model) EditImage(token, imageID string, ops []EditOp) (*ImageMeta, error) {
+
+	meta, err := m.ImageMeta(token, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return nil, errors.NewClient("no edit operations provided")
+	}
+
+	prevPath := metaPath(*meta)
+	data, err := m.readFile(prevPath)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.NewClient("edits are not supported for this file")
+	}
+
+	orientation := 1
+	if e, err := exif.Read(data); err == nil {
+		orientation = e.Orientation()
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if imaging.OrientationSwapsAxes(orientation) {
+		w, h = h, w
+	}
+	for _, op := range ops {
+		if w, h, err = op.validate(w, h); err != nil {
+			return nil, err
+		}
+	}
+	edit := func(img image.Image) image.Image {
+		img = imaging.Orient(img, orientation)
+		for _, op := range ops {
+			img = op.apply(img)
+		}
+		return img
+	}
+
+	prev := *meta
+	meta.Type = imaging.EncodableFormat(meta.Type)
+	meta.MimeType = imaging.MimeType(meta.Type)
+	var anim *gif.GIF
+	buf := &bytes.Buffer{}
+	if meta.Type == imaging.FormatGIF && meta.Animation != nil && meta.Animation.Frames > 1 {
+		if anim, err = decodeGIF(bytes.NewReader(data), nil); err != nil {
+			return nil, err
+		}
+		anim = imaging.MapGIF(anim, edit)
+		err = gif.EncodeAll(buf, anim)
+	} else {
+		img = edit(img)
+		err = imaging.Encode(buf, img, meta.Type, m.encOpts)
+	}
+	if err != nil {
+		return nil, errors.Newf("encode edited image: %v", err)
+	}
+	if anim != nil {
+		// mapped frames are complete, each covering the whole image.
+		img = anim.Image[0]
+	}
+	edited, err := exif.CopyICCProfile(data, buf.Bytes())
+	if err != nil {
+		return nil, errors.Newf("copy ICC profile: %v", err)
+	}
+	meta.ColorSpace = colorSpace(edited)
+	img = m.toSRGB(img, meta.ColorSpace)
+
+	meta.Width, meta.Height = w, h
+	meta.Digest = digest(edited)
+	meta.Size = int64(len(edited))
+	meta.OriginalSize = meta.Size
+	meta.PHash = formatPHash(imaging.DHash(img))
+	meta.Palette = extractPalette(img)
+	if meta.Placeholder, err = m.placeholder(img); err != nil {
+		return nil, errors.Newf("generate placeholder: %v", err)
+	}
+
+	imgPath := metaPath(*meta)
+	err = m.db.UpdateMeta(*meta, func(string, string) error {
+		err := m.store.Put(imgPath, bytes.NewReader(edited), int64(len(edited)))
+		if err != nil {
+			return errors.Newf("error saving image to file: %v", err)
+		}
+		return nil
+	}, m.removeBlob)
+	if err != nil {
+		if m.db.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("image not found")
+		}
+		return nil, errors.Newf("update image meta: %v", err)
+	}
+	// content stored before deduplication belonged to the image alone.
+	if prev.Digest == "" && prevPath != imgPath {
+		if err := m.store.Delete(prevPath); err != nil {
+			return nil, errors.Newf("remove unreferenced content: %v", err)
+		}
+	}
+
+	if err := m.removeVariants(prev); err != nil {
+		return nil, err
+	}
+	_, err = m.writeVariants(*meta, img, anim, func(imgPath string, data []byte) error {
+		err := m.store.Put(imgPath, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return errors.Newf("error saving variant to file: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ImageMeta(token, imageID)
+}
@@ -0,0 +1,158 @@
+package model_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+func TestModel_EditImage(t *testing.T) {
+	tt := []struct {
+		name           string
+		userID         string
+		imageID        string
+		ops            []model.EditOp
+		shared         bool
+		expW, expH     int
+		expErr         bool
+		expClErr       bool
+		expForbidden   bool
+		expNotFoundErr bool
+	}{
+		{name: "rotate", ops: []model.EditOp{{Op: model.EditRotate, Angle: 90}},
+			expW: 8, expH: 16},
+		{name: "crop", ops: []model.EditOp{{Op: model.EditCrop, Width: 4, Height: 2}},
+			expW: 4, expH: 2},
+		{name: "rotate then crop", ops: []model.EditOp{
+			{Op: model.EditRotate, Angle: 270},
+			{Op: model.EditCrop, X: 2, Y: 10, Width: 6, Height: 6},
+		}, expW: 6, expH: 6},
+		{name: "shared content", ops: []model.EditOp{{Op: model.EditRotate, Angle: 90}},
+			shared: true, expW: 8, expH: 16},
+		{name: "no ops", expErr: true, expClErr: true},
+		{name: "invalid angle", ops: []model.EditOp{{Op: model.EditRotate, Angle: 45}},
+			expErr: true, expClErr: true},
+		{name: "crop out of bounds", ops: []model.EditOp{{Op: model.EditCrop, X: 10, Width: 8, Height: 8}},
+			expErr: true, expClErr: true},
+		{name: "other user's image", userID: "456", ops: []model.EditOp{{Op: model.EditRotate, Angle: 90}},
+			expErr: true, expForbidden: true},
+		{name: "unknown image", imageID: "99", ops: []model.EditOp{{Op: model.EditRotate, Angle: 90}},
+			expErr: true, expNotFoundErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := validConf()
+			conf.ExpVariants = map[string]string{"thumb": "4w"}
+			m, _, s := newModel(t, conf)
+			img := encodePNG(t, 16, 8, color.White)
+			u := upload(t, m, "123", "general", img)
+			if tc.shared {
+				upload(t, m, "789", "general", img)
+			}
+			prevPath, err := m.ResolveImage(strings.TrimPrefix(u.URLs[model.VariantOriginal], imgsURLRoot))
+			if err != nil {
+				t.Fatalf("model.ResolveImage(): %v", err)
+			}
+			userID, imageID := "123", u.ID
+			if tc.userID != "" {
+				userID = tc.userID
+			}
+			if tc.imageID != "" {
+				imageID = tc.imageID
+			}
+
+			meta, err := m.EditImage(userID, imageID, tc.ops)
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("Expected an error but got nil")
+				}
+				if errCheck.IsClientError(err) != tc.expClErr {
+					t.Errorf("Expected client error %t, got %v", tc.expClErr, err)
+				}
+				if errCheck.IsForbiddenError(err) != tc.expForbidden {
+					t.Errorf("Expected forbidden error %t, got %v", tc.expForbidden, err)
+				}
+				if errCheck.IsNotFoundError(err) != tc.expNotFoundErr {
+					t.Errorf("Expected not found error %t, got %v", tc.expNotFoundErr, err)
+				}
+				if _, err := s.Stat(prevPath); err != nil {
+					t.Errorf("Expected the unedited content to be kept: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("model.EditImage(): %v", err)
+			}
+
+			if meta.Width != tc.expW || meta.Height != tc.expH {
+				t.Errorf("Expected %dx%d, got %dx%d", tc.expW, tc.expH, meta.Width, meta.Height)
+			}
+			if meta.URL != u.URLs[model.VariantOriginal] {
+				t.Errorf("Expected the URL '%s' to be kept, got '%s'",
+					u.URLs[model.VariantOriginal], meta.URL)
+			}
+			edited, err := m.TransformImage(strings.TrimPrefix(meta.URL, imgsURLRoot), model.Transformation{})
+			if err != nil {
+				t.Fatalf("model.TransformImage(): %v", err)
+			}
+			served, _, err := image.DecodeConfig(bytes.NewReader(edited.Data))
+			if err != nil {
+				t.Fatalf("decode served image: %v", err)
+			}
+			if served.Width != tc.expW || served.Height != tc.expH {
+				t.Errorf("Expected the URL to serve the %dx%d edited image, got %dx%d",
+					tc.expW, tc.expH, served.Width, served.Height)
+			}
+			_, err = s.Stat(prevPath)
+			if tc.shared && err != nil {
+				t.Errorf("Expected content still referenced to be kept: %v", err)
+			}
+			if !tc.shared && !s.IsNotFoundError(err) {
+				t.Errorf("Expected the unedited content to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestModel_ResolveImage(t *testing.T) {
+	m, _, _ := newModel(t, validConf())
+	u := upload(t, m, "123", "pets/cats", encodePNG(t, 4, 4, color.White))
+	stored, err := m.ResolveImage(strings.TrimPrefix(u.URLs[model.VariantOriginal], imgsURLRoot))
+	if err != nil {
+		t.Fatalf("model.ResolveImage(): %v", err)
+	}
+	if !strings.HasPrefix(stored, "blobs/") {
+		t.Fatalf("Expected the image to resolve to its content, got '%s'", stored)
+	}
+	tt := []struct {
+		name    string
+		imgPath string
+		exp     string
+	}{
+		{name: "image", imgPath: "123/pets/cats/" + u.ID + ".png", exp: stored},
+		{name: "image with other extension", imgPath: "123/pets/cats/" + u.ID + ".jpeg", exp: stored},
+		{name: "uncleaned image", imgPath: "/123/pets/../pets/cats/" + u.ID + ".png", exp: stored},
+		{name: "other user", imgPath: "456/pets/cats/" + u.ID + ".png", exp: "456/pets/cats/" + u.ID + ".png"},
+		{name: "other folder", imgPath: "123/pets/" + u.ID + ".png", exp: "123/pets/" + u.ID + ".png"},
+		{name: "unknown image", imgPath: "123/pets/cats/99.png", exp: "123/pets/cats/99.png"},
+		{name: "variant", imgPath: "123/pets/cats/" + u.ID + "_thumb.png",
+			exp: "123/pets/cats/" + u.ID + "_thumb.png"},
+		{name: "content", imgPath: stored, exp: stored},
+		{name: "folder", imgPath: "123/pets/", exp: "123/pets"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := m.ResolveImage(tc.imgPath)
+			if err != nil {
+				t.Fatalf("model.ResolveImage(): %v", err)
+			}
+			if got != tc.exp {
+				t.Errorf("Expected '%s', got '%s'", tc.exp, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// DefaultManifestWidths are the widths in pixels listed by ImageManifest
+// if none are requested. Only those narrower than the image are used, the
+// image's own width is always included.
+var DefaultManifestWidths = []int{320, 640, 960, 1280, 1920, 2560}
+
+// MaxManifestWidths is the largest number of widths ImageManifest lists.
+const MaxManifestWidths = 12
+
+// ManifestOptions select the renditions listed by ImageManifest.
+// Zero values mean the defaults.
+type ManifestOptions struct {
+	// Widths in pixels, DefaultManifestWidths by default. Widths greater
+	// than the image's are left out as images are never enlarged.
+	Widths []int
+	// Formats are image formats e.g. imaging.FormatPNG. The image's own
+	// format, or the format it is converted to if it cannot be encoded,
+	// by default.
+	Formats []string
+}
+
+// ImageManifest describes renditions of an image for responsive markup.
+type ImageManifest struct {
+	ImageID string `json:"imageID"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	// Widths are the widths of the renditions, narrowest first.
+	Widths  []int            `json:"widths"`
+	Formats []ManifestFormat `json:"formats"`
+	// Src is the URL of the widest rendition of the first format, Srcset
+	// lists every rendition of the first format and Sizes suits an image
+	// laid out at full viewport width up to its own width. All three are
+	// ready to use as attribute values of an HTML img element.
+	Src    string `json:"src"`
+	Srcset string `json:"srcset"`
+	Sizes  string `json:"sizes"`
+}
+
+// ManifestFormat lists the renditions of an image in one format.
+type ManifestFormat struct {
+	Format   string `json:"format"`
+	MimeType string `json:"mimeType"`
+	// Srcset is ready to use as the srcset attribute value of an HTML
+	// img or source element.
+	Srcset     string              `json:"srcset"`
+	Renditions []ManifestRendition `json:"renditions"`
+}
+
+// ManifestRendition is the URL of an image at a width in a format.
+type ManifestRendition struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"URL"`
+}
+
+// ImageManifest lists URLs of the image identified by imageID, if it
+// belongs to the owner of token, at the widths and in the formats in o.
+// URLs other than the image's own are transformation URLs which are
+// signed, without expiry, if Model was created WithURLSigner.
+// Images that cannot be transformed e.g. SVGs only list their own URL.
+func (m *Model) ImageManifest(token, imageID string, o ManifestOptions) (*ImageManifest, error) {
+
+	meta, err := m.ImageMeta(token, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Width < 1 || meta.Height < 1 {
+		return nil, errors.NewClient("the image's dimensions are unknown")
+	}
+
+	widths, formats, err := m.manifestRenditions(*meta, o)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &ImageManifest{
+		ImageID: meta.ID,
+		Width:   meta.Width,
+		Height:  meta.Height,
+		Widths:  widths,
+	}
+	imgPath := imagePath(*meta)
+	for _, format := range formats {
+		mf := ManifestFormat{Format: format, MimeType: imaging.MimeType(format)}
+		srcset := make([]string, len(widths))
+		for i, w := range widths {
+			h := (w*meta.Height + meta.Width/2) / meta.Width
+			if h < 1 {
+				h = 1
+			}
+			URL := m.renditionURL(imgPath, meta.Type, w, meta.Width, format)
+			mf.Renditions = append(mf.Renditions, ManifestRendition{Width: w, Height: h, URL: URL})
+			srcset[i] = URL + " " + strconv.Itoa(w) + "w"
+		}
+		mf.Srcset = strings.Join(srcset, ", ")
+		manifest.Formats = append(manifest.Formats, mf)
+	}
+
+	first := manifest.Formats[0]
+	widest := widths[len(widths)-1]
+	manifest.Src = first.Renditions[len(first.Renditions)-1].URL
+	manifest.Srcset = first.Srcset
+	manifest.Sizes = "(max-width: " + strconv.Itoa(widest) + "px) 100vw, " +
+		strconv.Itoa(widest) + "px"
+	return manifest, nil
+}
+
+// manifestRenditions validates o against meta returning the sorted widths
+// and the formats to list.
+func (m *Model) manifestRenditions(meta ImageMeta, o ManifestOptions) ([]int, []string, error) {
+
+	if meta.Type == imaging.FormatSVG {
+		return []int{meta.Width}, []string{meta.Type}, nil
+	}
+
+	if len(o.Widths) > MaxManifestWidths {
+		return nil, nil, errors.NewClientf("at most %d widths may be listed", MaxManifestWidths)
+	}
+	requested := o.Widths
+	if len(requested) == 0 {
+		requested = DefaultManifestWidths
+	}
+	seen := map[int]bool{meta.Width: true}
+	widths := []int{meta.Width}
+	for _, w := range requested {
+		if w < 1 || w > maxTransformDim {
+			return nil, nil, errors.NewClientf("widths must be between 1 and %d", maxTransformDim)
+		}
+		if w < meta.Width && !seen[w] {
+			seen[w] = true
+			widths = append(widths, w)
+		}
+	}
+	sort.Ints(widths)
+
+	formats := o.Formats
+	if len(formats) == 0 {
+		formats = []string{imaging.EncodableFormat(meta.Type)}
+	}
+	for _, f := range formats {
+		if imaging.EncodableFormat(f) != f {
+			return nil, nil, errors.NewClientf("unsupported format '%s'", f)
+		}
+	}
+	return widths, formats, nil
+}
+
+// renditionURL returns the URL of the image at imgPath, of format typ and
+// fullWidth pixels wide, at width in format.
+func (m *Model) renditionURL(imgPath, typ string, width, fullWidth int, format string) string {
+	q := url.Values{}
+	if width != fullWidth {
+		q.Set("w", strconv.Itoa(width))
+	}
+	if format != typ {
+		q.Set("format", format)
+	}
+	URL := m.imageURL(imgPath)
+	if len(q) == 0 {
+		return URL
+	}
+	if m.signer != nil {
+		q = m.signer.Sign("/"+imgPath, q, time.Time{})
+	}
+	return URL + "?" + q.Encode()
+}
@@ -0,0 +1,127 @@
+package model_test
+
+import (
+	"image/color"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/model"
+	"github.com/tomogoma/imagems/pkg/urlsign"
+)
+
+func TestModel_ImageManifest(t *testing.T) {
+	signer, err := urlsign.New([]byte("manifest-test-key"))
+	if err != nil {
+		t.Fatalf("urlsign.New(): %v", err)
+	}
+	m, _, _ := newModel(t, validConf(), model.WithURLSigner(signer))
+	u := upload(t, m, "123", "general", encodePNG(t, 1000, 500, color.White))
+	imgURL := u.URLs[model.VariantOriginal]
+
+	tt := []struct {
+		name         string
+		userID       string
+		opts         model.ManifestOptions
+		expWidths    []int
+		expFormats   []string
+		expClErr     bool
+		expForbidden bool
+	}{
+		{name: "defaults", expWidths: []int{320, 640, 960, 1000},
+			expFormats: []string{imaging.FormatPNG}},
+		{name: "widths", opts: model.ManifestOptions{Widths: []int{500, 2000, 500, 100}},
+			expWidths: []int{100, 500, 1000}, expFormats: []string{imaging.FormatPNG}},
+		{name: "formats", opts: model.ManifestOptions{Widths: []int{500},
+			Formats: []string{imaging.FormatJPEG, imaging.FormatPNG}},
+			expWidths: []int{500, 1000}, expFormats: []string{imaging.FormatJPEG, imaging.FormatPNG}},
+		{name: "zero width", opts: model.ManifestOptions{Widths: []int{0}}, expClErr: true},
+		{name: "too many widths", opts: model.ManifestOptions{
+			Widths: make([]int, model.MaxManifestWidths+1)}, expClErr: true},
+		{name: "unsupported format", opts: model.ManifestOptions{Formats: []string{imaging.FormatSVG}},
+			expClErr: true},
+		{name: "other user's image", userID: "456", expForbidden: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			userID := "123"
+			if tc.userID != "" {
+				userID = tc.userID
+			}
+			manifest, err := m.ImageManifest(userID, u.ID, tc.opts)
+			if tc.expClErr || tc.expForbidden {
+				if errCheck.IsClientError(err) != tc.expClErr {
+					t.Errorf("Expected client error %t, got %v", tc.expClErr, err)
+				}
+				if errCheck.IsForbiddenError(err) != tc.expForbidden {
+					t.Errorf("Expected forbidden error %t, got %v", tc.expForbidden, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("model.ImageManifest(): %v", err)
+			}
+			if !reflect.DeepEqual(manifest.Widths, tc.expWidths) {
+				t.Errorf("Expected widths %v, got %v", tc.expWidths, manifest.Widths)
+			}
+			if len(manifest.Formats) != len(tc.expFormats) {
+				t.Fatalf("Expected formats %v, got %+v", tc.expFormats, manifest.Formats)
+			}
+			for i, mf := range manifest.Formats {
+				if mf.Format != tc.expFormats[i] || len(mf.Renditions) != len(tc.expWidths) {
+					t.Errorf("Expected %s renditions at %v, got %+v",
+						tc.expFormats[i], tc.expWidths, mf)
+					continue
+				}
+				for j, r := range mf.Renditions {
+					checkRendition(t, signer, imgURL, mf.Format, tc.expWidths[j], r)
+				}
+			}
+			first := manifest.Formats[0].Renditions
+			if manifest.Src != first[len(first)-1].URL {
+				t.Errorf("Expected src to be the widest rendition, got '%s'", manifest.Src)
+			}
+		})
+	}
+}
+
+// checkRendition checks that r is the URL of the image at imgURL scaled
+// to width in format, signed by signer if it is a transformation.
+func checkRendition(t *testing.T, signer *urlsign.Signer, imgURL, format string, width int, r model.ManifestRendition) {
+	if r.Width != width || r.Height != width/2 {
+		t.Errorf("Expected %dx%d rendition, got %dx%d", width, width/2, r.Width, r.Height)
+	}
+	URL, err := url.Parse(r.URL)
+	if err != nil {
+		t.Fatalf("parse rendition URL: %v", err)
+	}
+	q := URL.Query()
+	URL.RawQuery = ""
+	if URL.String() != imgURL {
+		t.Errorf("Expected a URL of '%s', got '%s'", imgURL, r.URL)
+	}
+	isOriginal := width == 1000 && format == imaging.FormatPNG
+	if isOriginal {
+		if len(q) > 0 {
+			t.Errorf("Expected the image's own URL, got '%s'", r.URL)
+		}
+		return
+	}
+	imgPath := "/" + strings.TrimPrefix(URL.String(), imgsURLRoot)
+	if err := signer.Verify(imgPath, q, time.Now()); err != nil {
+		t.Errorf("Expected a signed URL, got '%s': %v", r.URL, err)
+	}
+	if q.Get(urlsign.ParamExpires) != "" {
+		t.Errorf("Expected a URL without expiry, got '%s'", r.URL)
+	}
+	if width != 1000 && q.Get("w") != strconv.Itoa(width) {
+		t.Errorf("Expected width %d in '%s'", width, r.URL)
+	}
+	if format != imaging.FormatPNG && q.Get("format") != format {
+		t.Errorf("Expected format %s in '%s'", format, r.URL)
+	}
+}
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// Orders in which a user's images can be listed. Both list newest first.
+const (
+	SortUploadDate  = "uploadDate"
+	SortCaptureDate = "captureDate"
+)
+
+// ImageExif is the subset of an image's EXIF metadata that is persisted.
+// Nil fields were absent from the image's EXIF.
+type ImageExif struct {
+	Make         string     `json:"make,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	CaptureDate  *time.Time `json:"captureDate,omitempty"`
+	ExposureTime *float64   `json:"exposureTime,omitempty"`
+	FNumber      *float64   `json:"fNumber,omitempty"`
+	ISO          *int       `json:"ISO,omitempty"`
+	FocalLength  *float64   `json:"focalLength,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+}
+
+// ImageAnimation describes the frames of an animated image.
+type ImageAnimation struct {
+	Frames int `json:"frames"`
+	// LoopCount is the number of times the animation repeats; 0 means
+	// forever and -1 means the animation is shown once.
+	LoopCount int `json:"loopCount"`
+	// Duration is the total display time of all frames in milliseconds.
+	Duration int `json:"duration"`
+}
+
+func newImageAnimation(a imaging.Animation) *ImageAnimation {
+	return &ImageAnimation{Frames: a.Frames, LoopCount: a.LoopCount,
+		Duration: a.Duration}
+}
+
+func newImageExif(e *exif.Exif) *ImageExif {
+	ie := &ImageExif{}
+	ie.Make, _ = e.String(e.IFD0, exif.TagMake)
+	ie.Model, _ = e.String(e.IFD0, exif.TagModel)
+	if t, ok := e.CaptureTime(); ok {
+		ie.CaptureDate = &t
+	}
+	if v, ok := e.Rational(e.ExifIFD, exif.TagExposureTime); ok {
+		ie.ExposureTime = &v
+	}
+	if v, ok := e.Rational(e.ExifIFD, exif.TagFNumber); ok {
+		ie.FNumber = &v
+	}
+	if v, ok := e.Uint(e.ExifIFD, exif.TagISOSpeed); ok {
+		iso := int(v)
+		ie.ISO = &iso
+	}
+	if v, ok := e.Rational(e.ExifIFD, exif.TagFocalLength); ok {
+		ie.FocalLength = &v
+	}
+	if lat, long, ok := e.LatLong(); ok {
+		ie.Latitude, ie.Longitude = &lat, &long
+	}
+	return ie
+}
+
+// ImageMeta returns the metadata of the image identified by imageID
+// if it belongs to the owner of token.
+func (m *Model) ImageMeta(token, imageID string) (*ImageMeta, error) {
+
+	t, err := m.validateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	ID, err := strconv.ParseInt(imageID, 10, 64)
+	if err != nil {
+		return nil, errors.NewNotFound("image not found")
+	}
+	meta, err := m.db.ImageMeta(ID)
+	if err != nil {
+		if m.db.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("image not found")
+		}
+		return nil, errors.Newf("get image meta: %v", err)
+	}
+	if meta.UserID != t.UsrID {
+		return nil, errors.NewForbidden("image belongs to another user")
+	}
+
+	meta.URL = m.metaURL(*meta)
+	return meta, nil
+}
+
+// ImageMetas lists metadata of images belonging to the owner of token
+// ordered by sortBy which is one of the Sort... values.
+// An empty sortBy means SortUploadDate.
+func (m *Model) ImageMetas(token, sortBy string, offset, count int64) ([]ImageMeta, error) {
+
+	t, err := m.validateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if sortBy == "" {
+		sortBy = SortUploadDate
+	}
+	if sortBy != SortUploadDate && sortBy != SortCaptureDate {
+		return nil, errors.NewClientf("cannot sort by '%s'", sortBy)
+	}
+	if offset < 0 || count < 1 {
+		return nil, errors.NewClient("offset cannot be negative and count must be positive")
+	}
+
+	metas, err := m.db.ImageMetasByUserID(t.UsrID, sortBy, offset, count)
+	if err != nil {
+		if m.db.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("no images found")
+		}
+		return nil, errors.Newf("get image metas: %v", err)
+	}
+
+	for i := range metas {
+		metas[i].URL = m.metaURL(metas[i])
+	}
+	return metas, nil
+}
+
+func (m *Model) metaURL(meta ImageMeta) string {
+	return m.imageURL(imagePath(meta))
+}
+
+// imagePath returns the path under which meta's image is served relative
+// to the images directory. Unlike metaPath it does not change when the
+// image's content is edited; ResolveImage maps it to the content.
+func imagePath(meta ImageMeta) string {
+	return path.Join(meta.UserID, meta.Folder, meta.ID+"."+meta.Type)
+}
+
+// ResolveImage returns the path of the stored file served at imgPath,
+// relative to the images directory. Paths of images, as returned by
+// imagePath, resolve to the image's current content whatever their
+// extension; cached conversions are not served and all other paths
+// resolve to themselves.
+func (m *Model) ResolveImage(imgPath string) (string, error) {
+	imgPath = path.Clean("/" + imgPath)[1:]
+	if isCachePath(imgPath) {
+		return "", errors.NewNotFound("image not found")
+	}
+	dir, file := path.Split(imgPath)
+	ID, err := strconv.ParseInt(strings.TrimSuffix(file, path.Ext(file)), 10, 64)
+	if err != nil || !strings.Contains(dir, "/") {
+		return imgPath, nil
+	}
+	meta, err := m.db.ImageMeta(ID)
+	if err != nil {
+		if m.db.IsNotFoundError(err) {
+			return imgPath, nil
+		}
+		return "", errors.Newf("get image meta: %v", err)
+	}
+	if path.Join(meta.UserID, meta.Folder)+"/" != dir {
+		return imgPath, nil
+	}
+	return metaPath(*meta), nil
+}
+
+// metaPath returns the path of meta's content relative to the images
+// directory.
+func metaPath(meta ImageMeta) string {
+	if meta.Digest != "" {
+		return blobPath(meta.Digest, meta.Type)
+	}
+	return path.Join(meta.UserID, meta.Folder, meta.ID+"."+meta.Type)
+}
@@ -5,12 +5,12 @@ import (
 	"encoding/base64"
 	"fmt"
 	"image"
-	_ "image/gif"
+	"image/color"
+	"image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"net/http"
+	"mime"
 	"net/url"
-	"os"
 	"path"
 	"regexp"
 	"strconv"
@@ -20,32 +20,128 @@ import (
 	"io"
 	"io/ioutil"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/logging"
+	"github.com/tomogoma/imagems/pkg/svg"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 type ImageMeta struct {
-	ID          string
-	UserID      string
-	Width       int
-	Height      int
-	Type        string
-	MimeType    string
-	dateCreated time.Time
-	dateUpdated time.Time
+	ID         string     `json:"ID"`
+	UserID     string     `json:"userID"`
+	Folder     string     `json:"folder"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Type       string     `json:"type"`
+	MimeType   string     `json:"mimeType"`
+	URL        string     `json:"URL,omitempty"`
+	PHash      string     `json:"pHash,omitempty"`
+	Digest     string     `json:"digest,omitempty"`
+	Exif       *ImageExif `json:"exif,omitempty"`
+	CreateDate time.Time  `json:"createDate"`
+	UpdateDate time.Time  `json:"updateDate"`
+	Placeholder
+	// Palette holds the image's most prominent colours heaviest first.
+	Palette []PaletteColor `json:"palette,omitempty"`
+	// Animation is only set for GIFs.
+	Animation *ImageAnimation `json:"animation,omitempty"`
+	// Size is the size in bytes of the stored image and OriginalSize
+	// that of the image as uploaded, before any optimisation.
+	Size         int64 `json:"size,omitempty"`
+	OriginalSize int64 `json:"originalSize,omitempty"`
+	// ColorSpace names the colour space of the stored image's embedded ICC
+	// profile e.g. "Display P3". It is empty if the image has none, in
+	// which case it is taken to be sRGB.
+	ColorSpace string `json:"colorSpace,omitempty"`
+}
+
+// Upload describes a newly saved image.
+type Upload struct {
+	ID string `json:"ID"`
+	// URLs maps variant names to URLs of the variants. The image itself
+	// is keyed as VariantOriginal.
+	URLs map[string]string `json:"URLs"`
+	Placeholder
 }
 
 type Config interface {
-	ImagesDir() string
 	ImgURLRoot() string
 	DefaultFolderName() string
+	ImageVariants() map[string]string
+	ImageJPEGQuality() int
+	ImageJPEGBackground() string
+	AutoOrientUploads() bool
+	StripUploadMetadata() bool
+	OptimiseUploads() bool
+	OptimisedJPEGQuality() int
+	OptimisedJPEGProgressive() bool
+	// ConvertToSRGB is whether images derived from Adobe RGB and Display
+	// P3 images are converted to sRGB.
+	ConvertToSRGB() bool
+	ImageWatermarks() []Watermark
+	UploadPolicy() UploadPolicy
 }
 
+// BlobFunc stores or removes the content of the blob identified by
+// digest, the content of an image of type ext. DB calls a store BlobFunc
+// while holding the lock on the blob's reference count so that stored
+// content always matches the references to it, and undoes its own
+// changes if it fails. A remove BlobFunc is only called once the release
+// of the last reference is committed and cannot undo it.
+type BlobFunc func(digest, ext string) error
+
 type DB interface {
-	SaveMeta(ImageMeta) (int64, error)
-	DeleteMeta(int64) error
+	errors.IsNotFoundErrChecker
+	// SaveMeta saves meta calling storeBlob if meta is the first reference
+	// to its blob.
+	SaveMeta(meta ImageMeta, storeBlob BlobFunc) (int64, error)
+	// UpdateMeta updates meta calling storeBlob if meta is the first
+	// reference to its new blob and, once committed, removeBlob if meta
+	// held the last reference to its previous blob.
+	UpdateMeta(meta ImageMeta, storeBlob, removeBlob BlobFunc) error
+	// DeleteMeta deletes the meta identified by id calling removeBlob,
+	// once committed, if it held the last reference to its blob.
+	DeleteMeta(id int64, removeBlob BlobFunc) error
+	ImageMeta(id int64) (*ImageMeta, error)
+	ImageMetasByUserID(userID string, sortBy string, offset, count int64) ([]ImageMeta, error)
+	ImageMetasByFolder(userID, folder string, offset, count int64) ([]ImageMeta, error)
+	HashedImageMetasByUserID(userID string) ([]ImageMeta, error)
+	ImageMetasByDigest(digest string) ([]ImageMeta, error)
+	ImageMetasByDominantColor(userID string, c color.RGBA, maxDistance float64, offset, count int64) ([]ImageMeta, error)
 }
 
-type FileWriter interface {
-	WriteFile(fileName string, data []byte, perm os.FileMode) error
+// ObjectInfo describes a stored file.
+type ObjectInfo struct {
+	// Path is relative to the storage root e.g. "blobs/9f/9f86d08.png".
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores image files at slash separated paths relative to its
+// root, see the storage package for implementations.
+type Storage interface {
+	errors.IsNotFoundErrChecker
+	// Put stores the size bytes read from r at path replacing any existing
+	// file.
+	Put(path string, r io.Reader, size int64) error
+	// Get returns a reader of length bytes of the file at path starting
+	// at offset. A negative length reads to the end of the file.
+	Get(path string, offset, length int64) (io.ReadCloser, error)
+	Stat(path string) (*ObjectInfo, error)
+	// Delete removes the file at path. Deleting a file that does not
+	// exist is not an error.
+	Delete(path string) error
+	// List describes the files whose paths begin with prefix sorted by
+	// path.
+	List(prefix string) ([]ObjectInfo, error)
+	// ListFolder describes the files directly within folder, empty for
+	// the root, and returns the paths of its subfolders, each ending with
+	// "/", both sorted by path.
+	ListFolder(folder string) ([]ObjectInfo, []string, error)
 }
 
 type JWTClaim struct {
@@ -58,112 +154,340 @@ type TokenValidator interface {
 	errors.IsAuthErrChecker
 }
 
+// URLSigner signs transformation URLs, see urlsign.Signer.
+type URLSigner interface {
+	Sign(imgPath string, q url.Values, expiry time.Time) url.Values
+}
+
+// Option allows extra configuration for instantiating Model. Use the
+// With... functions to set options.
+type Option func(*Model)
+
+// WithURLSigner sets the signer of the transformation URLs Model issues.
+// Required if the service only serves transformations at signed URLs.
+func WithURLSigner(s URLSigner) Option {
+	return func(m *Model) {
+		m.signer = s
+	}
+}
+
+// WithLogger sets the logger of errors Model recovers from such as
+// failing to remove content that is no longer referenced.
+func WithLogger(lg logging.Logger) Option {
+	return func(m *Model) {
+		m.log = lg
+	}
+}
+
 type Model struct {
-	imgsDir      string
 	imgURL       *url.URL
 	defFolder    string
+	variants     map[string]Transformation
+	encOpts      *imaging.EncodeOptions
+	autoOrient   bool
+	stripMeta    bool
+	optimise     bool
+	optQuality   int
+	optProgress  bool
+	convertSRGB  bool
+	watermarks   []watermark
+	policy       UploadPolicy
 	db           DB
-	fw           FileWriter
+	store        Storage
 	tknValidator TokenValidator
+	signer       URLSigner
+	log          logging.Logger
 	errors.ErrToHTTP
 }
 
 var noneFolderChars = regexp.MustCompile("\\W")
 
-func New(c Config, tv TokenValidator, db DB, fw FileWriter) (*Model, error) {
+func New(c Config, tv TokenValidator, db DB, s Storage, opts ...Option) (*Model, error) {
 	if err := validateConfig(c); err != nil {
 		return nil, err
 	}
 	if db == nil {
 		return nil, errors.New("DB was nil")
 	}
-	if fw == nil {
-		return nil, errors.New("FileWriter was nil")
+	if s == nil {
+		return nil, errors.New("Storage was nil")
 	}
 	imgURLRoot, err := url.Parse(c.ImgURLRoot())
 	if err != nil {
 		return nil, errors.Newf("error parsing image url: %v", err)
 	}
-	return &Model{
-		imgsDir:      c.ImagesDir(),
+	variants, err := parseVariants(c.ImageVariants())
+	if err != nil {
+		return nil, errors.Newf("image variants: %v", err)
+	}
+	watermarks, err := loadWatermarks(c.ImageWatermarks())
+	if err != nil {
+		return nil, err
+	}
+	encOpts := &imaging.EncodeOptions{JPEGQuality: c.ImageJPEGQuality()}
+	if bg := c.ImageJPEGBackground(); bg != "" {
+		if encOpts.Background, err = imaging.ParseHexColor(bg); err != nil {
+			return nil, errors.Newf("JPEG background: %v", err)
+		}
+	}
+	m := &Model{
 		defFolder:    c.DefaultFolderName(),
 		imgURL:       imgURLRoot,
+		variants:     variants,
+		encOpts:      encOpts,
+		autoOrient:   c.AutoOrientUploads(),
+		stripMeta:    c.StripUploadMetadata(),
+		optimise:     c.OptimiseUploads(),
+		optQuality:   c.OptimisedJPEGQuality(),
+		optProgress:  c.OptimisedJPEGProgressive(),
+		convertSRGB:  c.ConvertToSRGB(),
+		watermarks:   watermarks,
+		policy:       c.UploadPolicy(),
 		db:           db,
-		fw:           fw,
+		store:        s,
 		tknValidator: tv,
-	}, nil
+	}
+	for _, f := range opts {
+		f(m)
+	}
+	return m, nil
 }
 
-func (m *Model) NewBase64Image(token, folder, imgStr string) (time.Time, string, error) {
+func (m *Model) NewBase64Image(token, folder, imgStr string) (time.Time, *Upload, error) {
 	if imgStr == "" {
-		return time.Now(), "", errors.NewClient("empty image provided")
+		return time.Now(), nil, errors.NewClient("empty image provided")
 	}
 	reader := base64.NewDecoder(base64.StdEncoding, strings.NewReader(imgStr))
-	return m.NewImage(token, folder, ioutil.NopCloser(reader))
+	return m.NewImage(token, folder, "", ioutil.NopCloser(reader))
 }
 
-func (m *Model) NewImage(token, folder string, r io.ReadCloser) (time.Time, string, error) {
+// NewImage saves the image read from r into folder for the owner of token.
+// declaredType is the MIME type the client claims the image to be; the upload
+// is rejected if it does not match the decoded image type. An empty or
+// application/octet-stream declaredType is not checked.
+func (m *Model) NewImage(token, folder, declaredType string, r io.ReadCloser) (time.Time, *Upload, error) {
 
-	t, err := m.tknValidator.Validate(token)
+	t, err := m.validateToken(token)
 	if err != nil {
-		if m.tknValidator.IsAuthError(err) {
-			return time.Now(), "", errors.NewUnauthorized(err)
-		}
-		return time.Now(), "", errors.Newf("validate token: %v", err)
+		return time.Now(), nil, err
 	}
 
 	if hasSpecialChars(folder) {
-		return time.Now(), "", errors.NewClient("Special characters are not allowed in folders")
+		return time.Now(), nil, errors.NewClient("Special characters are not allowed in folders")
+	}
+	if folder == "" {
+		folder = m.defFolder
 	}
 
-	img, err := ioutil.ReadAll(r)
+	defer r.Close()
+	upload, err := m.policy.spool(r)
 	if err != nil {
-		return time.Now(), "", errors.NewClient("unable to decode image content")
+		return time.Now(), nil, err
 	}
-	defer r.Close()
+	defer upload.Close()
+	originalSize := upload.size
 
-	conf, ext, err := image.DecodeConfig(bytes.NewReader(img))
+	rd, err := upload.reader()
+	if err != nil {
+		return time.Now(), nil, err
+	}
+	conf, ext, err := image.DecodeConfig(rd)
 	if err != nil {
-		ext = "bmp"
-		if !isBitmap(img) {
-			return time.Now(), "", errors.NewClient("unsuported image type")
+		if !svg.Is(upload.header) {
+			return time.Now(), nil, errors.NewClient("unsuported image type")
+		}
+		if conf, err = sanitiseSVG(upload); err != nil {
+			return time.Now(), nil, err
+		}
+		ext = imaging.FormatSVG
+	}
+	if err := checkDeclaredType(declaredType, ext); err != nil {
+		return time.Now(), nil, err
+	}
+	if err := m.policy.check(ext, conf.Width, conf.Height); err != nil {
+		return time.Now(), nil, err
+	}
+	var imgExif *ImageExif
+	if e, err := exif.Read(upload.header); err == nil {
+		imgExif = newImageExif(e)
+	}
+	if ext != imaging.FormatSVG && (m.autoOrient || m.stripMeta) {
+		// normalising works on the whole image in memory, just like
+		// decoding does.
+		img, err := upload.readAll()
+		if err != nil {
+			return time.Now(), nil, err
+		}
+		img, ext, conf, err = m.normalise(img, ext, conf)
+		if err != nil {
+			return time.Now(), nil, err
+		}
+		if err := upload.replace(img); err != nil {
+			return time.Now(), nil, err
+		}
+	}
+	// the hash and variants are only computed for images we can decode.
+	if rd, err = upload.reader(); err != nil {
+		return time.Now(), nil, err
+	}
+	decoded, _, decodeErr := image.Decode(rd)
+	var anim *gif.GIF
+	if ext == imaging.FormatGIF && decodeErr == nil {
+		anim, err = decodeGIF(upload.file, func(frames int) error {
+			return m.policy.checkFrames(frames, conf.Width, conf.Height)
+		})
+		if err != nil {
+			return time.Now(), nil, err
 		}
 	}
-	mime := http.DetectContentType(img)
+	if m.optimise && decodeErr == nil && anim == nil {
+		if err := m.optimiseUpload(upload, decoded, ext); err != nil {
+			return time.Now(), nil, err
+		}
+	}
+	// the colour space is only read now as re-encoding may have dropped
+	// the profile.
+	cs := colorSpace(upload.header)
+	if decodeErr == nil {
+		decoded = m.toSRGB(decoded, cs)
+	}
 	meta := ImageMeta{
 		UserID:   t.UsrID,
+		Folder:   folder,
 		Type:     ext,
-		MimeType: mime,
+		MimeType: imaging.MimeType(ext),
 		Width:    conf.Width,
 		Height:   conf.Height,
+		Exif:     imgExif,
+		Digest:   upload.digest,
+		Size:     upload.size,
+	}
+	meta.OriginalSize = originalSize
+	meta.ColorSpace = cs
+	if decodeErr == nil {
+		meta.PHash = formatPHash(imaging.DHash(decoded))
+		meta.Palette = extractPalette(decoded)
+		if meta.Placeholder, err = m.placeholder(decoded); err != nil {
+			return time.Now(), nil, errors.Newf("generate placeholder: %v", err)
+		}
+	}
+	if anim != nil {
+		meta.Animation = newImageAnimation(imaging.GIFAnimation(anim))
 	}
 
-	metaID, err := m.db.SaveMeta(meta)
+	// identical content is stored once and shared by all metas referencing it.
+	blobPathSuffix := blobPath(meta.Digest, ext)
+	metaID, err := m.db.SaveMeta(meta, func(string, string) error {
+		if err := upload.put(m.store, blobPathSuffix); err != nil {
+			return errors.Newf("error saving image to file: %v", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return time.Now(), "", errors.Newf("error saving image meta: %v", err)
+		return time.Now(), nil, errors.Newf("error saving image meta: %v", err)
 	}
 	meta.ID = strconv.FormatInt(metaID, 10)
+	saved := &Upload{
+		ID:          meta.ID,
+		URLs:        map[string]string{VariantOriginal: m.metaURL(meta)},
+		Placeholder: meta.Placeholder,
+	}
 
-	fName := meta.ID + "." + ext
-	if folder == "" {
-		folder = m.defFolder
+	if len(m.variants) == 0 || decodeErr != nil {
+		return time.Now(), saved, nil
+	}
+	vURLs, err := m.writeVariants(meta, decoded, anim, func(imgPath string, data []byte) error {
+		return m.writeFile(metaID, imgPath, data)
+	})
+	if err != nil {
+		return time.Now(), nil, err
+	}
+	for name, URL := range vURLs {
+		saved.URLs[name] = URL
 	}
-	pathSuffix := path.Join(t.UsrID, folder, fName)
-	fPath := path.Join(m.imgsDir, pathSuffix)
+	return time.Now(), saved, nil
+}
 
-	if err := os.MkdirAll(path.Dir(fPath), 0755); err != nil {
-		return time.Now(), "", errors.Newf("error creating image dest dir: %v", err)
+// writeVariants encodes the configured variants of meta's image img,
+// whose frames are anim's if it is animated, and saves each using write.
+// It returns the URLs of the variants keyed by variant name.
+func (m *Model) writeVariants(meta ImageMeta, img image.Image, anim *gif.GIF,
+	write func(imgPath string, data []byte) error) (map[string]string, error) {
+
+	vExt := imaging.EncodableFormat(meta.Type)
+	wm := m.watermarkFor(WatermarkOnUpload, meta.UserID, meta.Folder)
+	URLs := make(map[string]string, len(m.variants))
+	for name, vt := range m.variants {
+		transform := func(img image.Image) image.Image {
+			img = imaging.Resize(img, vt.Width, vt.Height, vt.Fit)
+			if wm != nil {
+				img = wm.apply(img)
+			}
+			return img
+		}
+		vImg := &bytes.Buffer{}
+		var err error
+		if anim != nil && len(anim.Image) > 1 {
+			err = gif.EncodeAll(vImg, imaging.MapGIF(anim, transform))
+		} else {
+			err = imaging.Encode(vImg, transform(img), vExt, m.encOpts)
+		}
+		if err != nil {
+			return nil, errors.Newf("encode %s variant: %v", name, err)
+		}
+		vPathSuffix := path.Join(meta.UserID, meta.Folder, variantFileName(meta.ID, name, vExt))
+		if err := write(vPathSuffix, vImg.Bytes()); err != nil {
+			return nil, err
+		}
+		URLs[name] = m.imageURL(vPathSuffix)
 	}
-	if err := m.fw.WriteFile(fPath, img, 0644); err != nil {
-		rollBackErr := m.db.DeleteMeta(metaID)
+	return URLs, nil
+}
+
+// writeFile stores data at imgPath undoing the saving of the meta
+// identified by metaID if the write fails.
+func (m *Model) writeFile(metaID int64, imgPath string, data []byte) error {
+	if err := m.store.Put(imgPath, bytes.NewReader(data), int64(len(data))); err != nil {
+		rollBackErr := m.deleteMeta(metaID)
 		if rollBackErr != nil {
 			err = fmt.Errorf("%v ...further while undoing db changes: %v", err, rollBackErr)
 		}
-		return time.Now(), "", errors.Newf("error saving image to file: %v", err)
+		return errors.Newf("error saving image to file: %v", err)
 	}
+	return nil
+}
+
+func (m *Model) imageURL(pathSuffix string) string {
 	URL := *m.imgURL
 	URL.Path = path.Join(URL.Path, pathSuffix)
-	return time.Now(), URL.String(), nil
+	return URL.String()
+}
+
+func variantFileName(metaID, variant, ext string) string {
+	return metaID + "_" + variant + "." + ext
+}
+
+func checkDeclaredType(declaredType, format string) error {
+	mediaType, _, _ := mime.ParseMediaType(declaredType)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		return nil
+	}
+	if imaging.FormatOf(mediaType) != format {
+		return errors.NewClientf("image declared as %s but content is %s",
+			mediaType, imaging.MimeType(format))
+	}
+	return nil
+}
+
+func (m *Model) validateToken(token string) (*JWTClaim, error) {
+	t, err := m.tknValidator.Validate(token)
+	if err != nil {
+		if m.tknValidator.IsAuthError(err) {
+			return nil, errors.NewUnauthorized(err)
+		}
+		return nil, errors.Newf("validate token: %v", err)
+	}
+	return t, nil
 }
 
 func hasSpecialChars(folder string) bool {
@@ -180,9 +504,6 @@ func validateConfig(c Config) error {
 	if c == nil {
 		return errors.New("config was nil")
 	}
-	if err := os.MkdirAll(c.ImagesDir(), 0755); err != nil {
-		return errors.Newf("bad image directory provided: %v", err)
-	}
 	urlRoot, err := url.Parse(c.ImgURLRoot())
 	if err != nil {
 		return errors.Newf("image URL root was invalid: %v", err)
@@ -194,11 +515,14 @@ func validateConfig(c Config) error {
 	if defFolder == "" {
 		return errors.New("default folder name was empty")
 	}
+	if q := c.ImageJPEGQuality(); q < 0 || q > 100 {
+		return errors.New("JPEG quality must be between 1 and 100")
+	}
+	if q := c.OptimisedJPEGQuality(); q < 0 || q > 100 {
+		return errors.New("optimised JPEG quality must be between 1 and 100")
+	}
+	if err := c.UploadPolicy().validate(); err != nil {
+		return err
+	}
 	return nil
 }
-
-// isBitmap returns true if the first 2 bytes of an image denote that it is a bitmap image.
-// More at http://openmymind.net/Getting-An-Images-Type-And-Size/
-func isBitmap(first2B []byte) bool {
-	return len(first2B) > 1 && first2B[0] == 0x42 && first2B[1] == 0x4D
-}
@@ -1,449 +1,576 @@
 package model_test
 
 import (
+	"bytes"
 	"encoding/base64"
-	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
 	"io/ioutil"
-	"os"
-	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/tomogoma/go-typed-errors"
 	"github.com/tomogoma/imagems/pkg/model"
+	"github.com/tomogoma/imagems/pkg/storage"
 )
 
 type ConfigMock struct {
-	ExpImgsDir    string
-	ExpImgURLRoot string
-	ExpDefFolder  string
+	ExpImgURLRoot     string
+	ExpDefFolder      string
+	ExpVariants       map[string]string
+	ExpWatermarks     []model.Watermark
+	ExpPolicy         model.UploadPolicy
+	ExpSRGB           bool
+	ExpOptimise       bool
+	ExpOptQuality     int
+	ExpOptProgressive bool
 }
 
-func (c *ConfigMock) ImagesDir() string {
-	return c.ExpImgsDir
-}
+func (c *ConfigMock) ImgURLRoot() string                 { return c.ExpImgURLRoot }
+func (c *ConfigMock) DefaultFolderName() string          { return c.ExpDefFolder }
+func (c *ConfigMock) ImageVariants() map[string]string   { return c.ExpVariants }
+func (c *ConfigMock) ImageJPEGQuality() int              { return 0 }
+func (c *ConfigMock) ImageJPEGBackground() string        { return "" }
+func (c *ConfigMock) AutoOrientUploads() bool            { return false }
+func (c *ConfigMock) StripUploadMetadata() bool          { return false }
+func (c *ConfigMock) OptimiseUploads() bool              { return c.ExpOptimise }
+func (c *ConfigMock) OptimisedJPEGQuality() int          { return c.ExpOptQuality }
+func (c *ConfigMock) OptimisedJPEGProgressive() bool     { return c.ExpOptProgressive }
+func (c *ConfigMock) ConvertToSRGB() bool                { return c.ExpSRGB }
+func (c *ConfigMock) ImageWatermarks() []model.Watermark { return c.ExpWatermarks }
+func (c *ConfigMock) UploadPolicy() model.UploadPolicy   { return c.ExpPolicy }
 
-func (c *ConfigMock) ImgURLRoot() string {
-	return c.ExpImgURLRoot
+// TokenValidatorMock treats tokens as the user IDs they are issued to.
+type TokenValidatorMock struct {
+	errors.AuthErrCheck
+	ExpErr error
 }
 
-func (c *ConfigMock) DefaultFolderName() string {
-	return c.ExpDefFolder
+func (tv *TokenValidatorMock) Validate(token string) (*model.JWTClaim, error) {
+	if tv.ExpErr != nil {
+		return nil, tv.ExpErr
+	}
+	return &model.JWTClaim{UsrID: token}, nil
 }
 
+// DBMock keeps image metas and blob reference counts in memory.
 type DBMock struct {
-	ExpSaveErr      error
-	ExpDelErr       error
-	ExpMetaID       string
-	RecordMetaSaved bool
-	RecordMetaDeld  bool
+	errors.NotFoundErrCheck
+	ExpSaveErr error
+	metas      map[int64]model.ImageMeta
+	refs       map[string]int
+	lastID     int64
 }
 
-func (d *DBMock) SaveMeta(m *model.ImageMeta) error {
-	d.RecordMetaSaved = true
-	if m != nil {
-		m.ID = d.ExpMetaID
+func (d *DBMock) SaveMeta(m model.ImageMeta, storeBlob model.BlobFunc) (int64, error) {
+	if d.ExpSaveErr != nil {
+		return -1, d.ExpSaveErr
 	}
-	return d.ExpSaveErr
-}
-func (d *DBMock) DeleteMeta(int64) error {
-	d.RecordMetaDeld = true
-	return d.ExpDelErr
+	if d.metas == nil {
+		d.metas = make(map[int64]model.ImageMeta)
+		d.refs = make(map[string]int)
+	}
+	if err := d.ref(m, storeBlob); err != nil {
+		return -1, err
+	}
+	d.lastID++
+	m.ID = strconv.FormatInt(d.lastID, 10)
+	d.metas[d.lastID] = m
+	return d.lastID, nil
 }
 
-type FileWriterMock struct {
-	ExpErr              error
-	RecordWriteFilePath string
+func (d *DBMock) UpdateMeta(m model.ImageMeta, storeBlob, removeBlob model.BlobFunc) error {
+	ID, _ := strconv.ParseInt(m.ID, 10, 64)
+	prev, ok := d.metas[ID]
+	if !ok {
+		return errors.NewNotFound("image meta not found")
+	}
+	if m.Digest == prev.Digest {
+		d.metas[ID] = m
+		return nil
+	}
+	if err := d.ref(m, storeBlob); err != nil {
+		return err
+	}
+	d.metas[ID] = m
+	return d.unref(prev, removeBlob)
 }
 
-func (f *FileWriterMock) WriteFile(fPath string, data []byte, perm os.FileMode) error {
-	f.RecordWriteFilePath = fPath
-	return f.ExpErr
+func (d *DBMock) DeleteMeta(ID int64, removeBlob model.BlobFunc) error {
+	m, ok := d.metas[ID]
+	if !ok {
+		return errors.NewNotFound("image meta not found")
+	}
+	delete(d.metas, ID)
+	return d.unref(m, removeBlob)
 }
 
-const imgsDir = "test/images/"
-const imgsURLRoot = "localhost://8080/imagems_test/images/"
-const defFolder = "general"
-
-var validConf = &ConfigMock{ExpImgsDir: imgsDir, ExpImgURLRoot: imgsURLRoot, ExpDefFolder: defFolder}
+func (d *DBMock) ref(m model.ImageMeta, storeBlob model.BlobFunc) error {
+	if m.Digest == "" {
+		return nil
+	}
+	if d.refs[m.Digest] == 0 {
+		if err := storeBlob(m.Digest, m.Type); err != nil {
+			return err
+		}
+	}
+	d.refs[m.Digest]++
+	return nil
+}
 
-func TestNew(t *testing.T) {
-	defer tearDown(t)
-	m, err := model.New(validConf, &DBMock{}, &FileWriterMock{})
-	if err != nil {
-		t.Fatalf("model.New(): %v", err)
+func (d *DBMock) unref(m model.ImageMeta, removeBlob model.BlobFunc) error {
+	if m.Digest == "" {
+		return nil
 	}
-	if m == nil {
-		t.Error("Expected a model but got nil")
+	if d.refs[m.Digest] > 1 {
+		d.refs[m.Digest]--
+		return nil
 	}
+	delete(d.refs, m.Digest)
+	return removeBlob(m.Digest, m.Type)
 }
 
-func TestNew_nilConfig(t *testing.T) {
-	defer tearDown(t)
-	_, err := model.New(nil, &DBMock{}, &FileWriterMock{})
-	if err == nil {
-		t.Fatal("Expected an error but got nil")
+func (d *DBMock) ImageMeta(ID int64) (*model.ImageMeta, error) {
+	m, ok := d.metas[ID]
+	if !ok {
+		return nil, errors.NewNotFound("image meta not found")
 	}
+	return &m, nil
 }
 
-func TestNew_emptyImagesDir(t *testing.T) {
-	defer tearDown(t)
-	_, err := model.New(&ConfigMock{ExpImgURLRoot: imgsURLRoot, ExpDefFolder: defFolder}, &DBMock{}, &FileWriterMock{})
-	if err == nil {
-		t.Fatal("Expected an error but got nil")
+func (d *DBMock) ImageMetasByUserID(userID string, sortBy string, offset, count int64) ([]model.ImageMeta, error) {
+	return d.filter(offset, count, func(m model.ImageMeta) bool {
+		return m.UserID == userID
+	})
+}
+
+func (d *DBMock) ImageMetasByFolder(userID, folder string, offset, count int64) ([]model.ImageMeta, error) {
+	return d.filter(offset, count, func(m model.ImageMeta) bool {
+		return m.UserID == userID && m.Folder == folder
+	})
+}
+
+func (d *DBMock) HashedImageMetasByUserID(userID string) ([]model.ImageMeta, error) {
+	return d.filter(0, int64(len(d.metas)), func(m model.ImageMeta) bool {
+		return m.UserID == userID && m.PHash != ""
+	})
+}
+
+func (d *DBMock) ImageMetasByDigest(digest string) ([]model.ImageMeta, error) {
+	return d.filter(0, int64(len(d.metas)), func(m model.ImageMeta) bool {
+		return m.Digest == digest
+	})
+}
+
+func (d *DBMock) ImageMetasByDominantColor(userID string, c color.RGBA, maxDistance float64, offset, count int64) ([]model.ImageMeta, error) {
+	return nil, errors.NewNotFound("no images found for user near colour")
+}
+
+// filter returns the metas for which keep returns true, oldest first.
+func (d *DBMock) filter(offset, count int64, keep func(model.ImageMeta) bool) ([]model.ImageMeta, error) {
+	var IDs []int64
+	for ID, m := range d.metas {
+		if keep(m) {
+			IDs = append(IDs, ID)
+		}
 	}
+	sort.Slice(IDs, func(i, j int) bool { return IDs[i] < IDs[j] })
+	var ms []model.ImageMeta
+	for i := offset; i < int64(len(IDs)) && i < offset+count; i++ {
+		ms = append(ms, d.metas[IDs[i]])
+	}
+	if len(ms) == 0 {
+		return nil, errors.NewNotFound("no images found")
+	}
+	return ms, nil
 }
 
-func TestNew_emptyDefaultFolderName(t *testing.T) {
-	defer tearDown(t)
-	_, err := model.New(&ConfigMock{ExpImgURLRoot: imgsURLRoot, ExpImgsDir: imgsDir}, &DBMock{}, &FileWriterMock{})
-	if err == nil {
-		t.Fatal("Expected an error but got nil")
+const imgsURLRoot = "http://localhost:8080/imagems_test/images/"
+const defFolder = "general"
+
+var errCheck errors.AllErrCheck
+
+func validConf() *ConfigMock {
+	return &ConfigMock{ExpImgURLRoot: imgsURLRoot, ExpDefFolder: defFolder}
+}
+
+// newModel creates a Model using c, an in memory DB and storage.
+func newModel(t *testing.T, c *ConfigMock, opts ...model.Option) (*model.Model, *DBMock, model.Storage) {
+	db := &DBMock{}
+	s := storage.NewMemory()
+	m, err := model.New(c, &TokenValidatorMock{}, db, s, opts...)
+	if err != nil {
+		t.Fatalf("model.New(): %v", err)
 	}
+	return m, db, s
 }
 
-func TestNew_invalidURLRoot(t *testing.T) {
-	type InvalidURLRootTestCase struct {
-		Desc       string
-		ImgURLRoot string
-	}
-	tcs := []InvalidURLRootTestCase{
-		{
-			Desc:       "empty url",
-			ImgURLRoot: "",
-		},
-		{
-			Desc:       "ambiguous ':'",
-			ImgURLRoot: ":",
-		},
-		{
-			Desc:       "missing protocol",
-			ImgURLRoot: "192.168.1.2:8082/",
-		},
-	}
-	defer tearDown(t)
-	for _, tc := range tcs {
-		confMock := &ConfigMock{
-			ExpImgsDir:    imgsDir,
-			ExpImgURLRoot: tc.ImgURLRoot,
-		}
-		_, err := model.New(confMock, &DBMock{}, &FileWriterMock{})
-		if err == nil {
-			t.Errorf("%s - Expected an error but got nil", tc.Desc)
+// encodePNG returns a w x h PNG filled with c.
+func encodePNG(t *testing.T, w, h int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, c)
 		}
 	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("encode test image: %v", err)
+	}
+	return buf.Bytes()
 }
 
-func TestNew_nilDB(t *testing.T) {
-	defer tearDown(t)
-	_, err := model.New(validConf, nil, &FileWriterMock{})
-	if err == nil {
-		t.Fatal("Expected an error but got nil")
+// upload saves img for userID in folder failing the test on error.
+func upload(t *testing.T, m *model.Model, userID, folder string, img []byte) *model.Upload {
+	_, u, err := m.NewImage(userID, folder, "", ioutil.NopCloser(bytes.NewReader(img)))
+	if err != nil {
+		t.Fatalf("model.NewImage(): %v", err)
 	}
+	return u
 }
 
-func TestNew_nilFileWriter(t *testing.T) {
-	defer tearDown(t)
-	_, err := model.New(validConf, &DBMock{}, nil)
-	if err == nil {
-		t.Fatal("Expected an error but got nil")
+func TestNew(t *testing.T) {
+	tt := []struct {
+		name   string
+		conf   model.Config
+		db     model.DB
+		store  model.Storage
+		expErr bool
+	}{
+		{name: "valid", conf: validConf(), db: &DBMock{}, store: storage.NewMemory()},
+		{name: "nil config", conf: nil, db: &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "empty default folder",
+			conf: &ConfigMock{ExpImgURLRoot: imgsURLRoot},
+			db:   &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "empty URL root",
+			conf: &ConfigMock{ExpDefFolder: defFolder},
+			db:   &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "ambiguous ':' URL root",
+			conf: &ConfigMock{ExpImgURLRoot: ":", ExpDefFolder: defFolder},
+			db:   &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "URL root missing protocol",
+			conf: &ConfigMock{ExpImgURLRoot: "192.168.1.2:8082/", ExpDefFolder: defFolder},
+			db:   &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "invalid variant",
+			conf: &ConfigMock{ExpImgURLRoot: imgsURLRoot, ExpDefFolder: defFolder,
+				ExpVariants: map[string]string{"thumb": "150"}},
+			db: &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "variant named original",
+			conf: &ConfigMock{ExpImgURLRoot: imgsURLRoot, ExpDefFolder: defFolder,
+				ExpVariants: map[string]string{model.VariantOriginal: "150w"}},
+			db: &DBMock{}, store: storage.NewMemory(), expErr: true},
+		{name: "nil DB", conf: validConf(), db: nil, store: storage.NewMemory(), expErr: true},
+		{name: "nil storage", conf: validConf(), db: &DBMock{}, store: nil, expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := model.New(tc.conf, &TokenValidatorMock{}, tc.db, tc.store)
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("Expected an error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("model.New(): %v", err)
+			}
+			if m == nil {
+				t.Error("Expected a model but got nil")
+			}
+		})
 	}
 }
 
 func TestModel_NewImage(t *testing.T) {
-	defer tearDown(t)
-	type NewImageTestCase struct {
-		Desc            string
-		DB              *DBMock
-		FW              *FileWriterMock
-		Token           claim.Auth
-		Image           []byte
-		Folder          string
-		ExpImgURL       string
-		ExpWriteFPath   string
-		ExpErr          bool
-		ExpIsClErr      bool
-		ExpUnauthorized bool
-		ExpDirCreated   bool
-	}
 	img1, err := ioutil.ReadFile("png_sample.png")
 	if err != nil {
 		t.Fatalf("Failed to set up (read test image file): %v", err)
 	}
-	tcs := []NewImageTestCase{
-		{
-			Desc:            "Successful save png",
-			DB:              &DBMock{ExpDelErr: nil, ExpSaveErr: nil, ExpMetaID: 456},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           img1,
-			Folder:          "profile",
-			ExpImgURL:       imgsURLRoot + "123/profile/456.png",
-			ExpWriteFPath:   imgsDir + "123/profile/456.png",
-			ExpErr:          false,
-			ExpIsClErr:      false,
-			ExpUnauthorized: false,
-			ExpDirCreated:   true,
-		},
-		{
-			Desc:            "Successful save png in subfolder",
-			DB:              &DBMock{ExpDelErr: nil, ExpSaveErr: nil, ExpMetaID: 456},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           img1,
-			Folder:          "profile/avatars",
-			ExpImgURL:       imgsURLRoot + "123/profile/avatars/456.png",
-			ExpWriteFPath:   imgsDir + "123/profile/avatars/456.png",
-			ExpErr:          false,
-			ExpIsClErr:      false,
-			ExpUnauthorized: false,
-			ExpDirCreated:   true,
-		},
-		{
-			Desc:            "Successful save empty folder",
-			DB:              &DBMock{ExpDelErr: nil, ExpSaveErr: nil, ExpMetaID: 456},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           img1,
-			Folder:          "",
-			ExpImgURL:       imgsURLRoot + "123/" + defFolder + "/456.png",
-			ExpWriteFPath:   imgsDir + "123/" + defFolder + "/456.png",
-			ExpErr:          false,
-			ExpIsClErr:      false,
-			ExpUnauthorized: false,
-			ExpDirCreated:   true,
-		},
-		{
-			Desc:       "Invalid chars in folder",
-			DB:         &DBMock{ExpDelErr: nil, ExpSaveErr: nil, ExpMetaID: 456},
-			FW:         &FileWriterMock{ExpErr: nil},
-			Token:      claim.Auth{UsrID: 123},
-			Image:      img1,
-			Folder:     "|?>profile*@#avatars!~`+\"",
-			ExpErr:     true,
-			ExpIsClErr: true,
-		},
-		{
-			Desc:            "Nil image",
-			DB:              &DBMock{},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           nil,
-			ExpErr:          true,
-			ExpIsClErr:      true,
-			ExpUnauthorized: false,
-		},
-		{
-			Desc:            "Invalid image",
-			DB:              &DBMock{},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           []byte{0, 100},
-			ExpErr:          true,
-			ExpIsClErr:      true,
-			ExpUnauthorized: false,
-		},
-		{
-			Desc:            "Image size 1byte",
-			DB:              &DBMock{},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           []byte{100},
-			ExpErr:          true,
-			ExpIsClErr:      true,
-			ExpUnauthorized: false,
-		},
-		{
-			Desc:            "Image size 0bytes",
-			DB:              &DBMock{},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           []byte{},
-			ExpErr:          true,
-			ExpIsClErr:      true,
-			ExpUnauthorized: false,
-		},
-		{
-			Desc:            "DB report error",
-			DB:              &DBMock{ExpSaveErr: errors.New("some internal error")},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           img1,
-			ExpImgURL:       imgsURLRoot + "/123/",
-			ExpWriteFPath:   imgsDir + "/123/",
-			ExpErr:          true,
-			ExpIsClErr:      false,
-			ExpUnauthorized: false,
-		},
-		{
-			Desc:            "FileWriter report error",
-			DB:              &DBMock{ExpDelErr: nil, ExpSaveErr: nil, ExpMetaID: 456},
-			FW:              &FileWriterMock{ExpErr: errors.New("Some error")},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           img1,
-			ExpErr:          true,
-			ExpIsClErr:      false,
-			ExpUnauthorized: false,
-		},
-	}
-	for _, tc := range tcs {
-		m, err := model.New(validConf, tc.DB, tc.FW)
-		if err != nil {
-			t.Fatalf("%s - model.New(): %v", tc.Desc, err)
-		}
-		st, imgURL, err := m.NewImage(tc.Token, tc.Folder, tc.Image)
-		if st == "" {
-			t.Errorf("%s - server time was empty", tc.Desc)
-		}
-		if tc.ExpErr {
-			if err == nil {
-				t.Errorf("%s - expected an error but got nil", tc.Desc)
-				continue
+	tt := []struct {
+		name            string
+		tvErr           error
+		dbErr           error
+		image           []byte
+		folder          string
+		expFolder       string
+		expErr          bool
+		expClErr        bool
+		expUnauthorized bool
+	}{
+		{name: "png", image: img1, folder: "profile", expFolder: "profile"},
+		{name: "png in subfolder", image: img1, folder: "profile/avatars",
+			expFolder: "profile/avatars"},
+		{name: "empty folder", image: img1, folder: "", expFolder: defFolder},
+		{name: "invalid chars in folder", image: img1,
+			folder: "|?>profile*@#avatars!~`+\"", expErr: true, expClErr: true},
+		{name: "nil image", image: nil, expErr: true, expClErr: true},
+		{name: "invalid image", image: []byte{0, 100}, expErr: true, expClErr: true},
+		{name: "1 byte image", image: []byte{100}, expErr: true, expClErr: true},
+		{name: "invalid token", image: img1, tvErr: errors.NewAuth("invalid token"),
+			expErr: true, expUnauthorized: true},
+		{name: "DB error", image: img1, dbErr: errors.New("some internal error"),
+			expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := validConf()
+			conf.ExpVariants = map[string]string{"thumb": "20x20 cover", "small": "36w"}
+			db := &DBMock{ExpSaveErr: tc.dbErr}
+			s := storage.NewMemory()
+			m, err := model.New(conf, &TokenValidatorMock{ExpErr: tc.tvErr}, db, s)
+			if err != nil {
+				t.Fatalf("model.New(): %v", err)
 			}
-			if tc.ExpIsClErr && !m.IsClientError(err) {
-				t.Errorf("%s - expected client error but got %v", tc.Desc, err)
+
+			_, u, err := m.NewImage("123", tc.folder, "image/png",
+				ioutil.NopCloser(bytes.NewReader(tc.image)))
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("Expected an error but got nil")
+				}
+				if errCheck.IsClientError(err) != tc.expClErr {
+					t.Errorf("Expected client error %t, got %v", tc.expClErr, err)
+				}
+				if errCheck.IsUnauthorizedError(err) != tc.expUnauthorized {
+					t.Errorf("Expected unauthorized error %t, got %v", tc.expUnauthorized, err)
+				}
+				if len(db.metas) > 0 {
+					t.Errorf("Expected no image meta to be saved, got %d", len(db.metas))
+				}
+				return
 			}
-			if !tc.ExpIsClErr && m.IsClientError(err) {
-				t.Errorf("%s - expected non-client error but got %v", tc.Desc, err)
+			if err != nil {
+				t.Fatalf("model.NewImage(): %v", err)
 			}
-			if tc.ExpUnauthorized && !m.IsAuthError(err) {
-				t.Errorf("%s - expected unauthorized error but got %v", tc.Desc, err)
+
+			meta, err := db.ImageMeta(1)
+			if err != nil {
+				t.Fatalf("Expected the image meta to be saved: %v", err)
 			}
-			if !tc.ExpUnauthorized && m.IsAuthError(err) {
-				t.Errorf("%s - expected non-unauthorized error but got %v", tc.Desc, err)
+			if meta.UserID != "123" || meta.Folder != tc.expFolder || meta.Type != "png" ||
+				meta.Width != 72 || meta.Height != 87 || meta.Size != int64(len(tc.image)) {
+				t.Errorf("Unexpected image meta %+v", meta)
 			}
-			if tc.FW.ExpErr != nil && !tc.DB.RecordMetaDeld {
-				t.Errorf("%s - expected meta rollback on file save error", tc.Desc)
+			if u.ID != "1" {
+				t.Errorf("Expected ID '1', got '%s'", u.ID)
 			}
-			continue
-		}
-		if err != nil {
-			t.Errorf("%s - model.NewImage(): %v", tc.Desc, err)
-		}
-		if imgURL != tc.ExpImgURL {
-			t.Errorf("%s - image URL mismatch: expect '%s', got '%s'", tc.Desc, tc.ExpImgURL, imgURL)
-		}
-		if !tc.DB.RecordMetaSaved {
-			t.Errorf("%s - DB did not record meta saving", tc.Desc)
-		}
-		if tc.FW.RecordWriteFilePath != tc.ExpWriteFPath {
-			t.Errorf("%s - Expected write to file at '%s', got '%s'",
-				tc.Desc, tc.ExpWriteFPath, tc.FW.RecordWriteFilePath)
-		}
-		if _, err := os.Stat(path.Dir(tc.ExpWriteFPath)); tc.ExpDirCreated && err != nil {
-			t.Errorf("%s - Expected parent directory to be created"+
-				" but: %v", tc.Desc, err)
-		}
+			expPaths := map[string]string{
+				"thumb": "123/" + tc.expFolder + "/1_thumb.png",
+				"small": "123/" + tc.expFolder + "/1_small.png",
+			}
+			if len(u.URLs) != len(expPaths)+1 || u.URLs[model.VariantOriginal] == "" {
+				t.Errorf("Expected URLs of the original and each variant, got %v", u.URLs)
+			}
+			for name, expPath := range expPaths {
+				if u.URLs[name] != imgsURLRoot+expPath {
+					t.Errorf("Expected %s URL '%s', got '%s'", name, imgsURLRoot+expPath, u.URLs[name])
+				}
+				if _, err := s.Stat(expPath); err != nil {
+					t.Errorf("Expected %s variant to be stored: %v", name, err)
+				}
+			}
+			original := strings.TrimPrefix(u.URLs[model.VariantOriginal], imgsURLRoot)
+			if expPath := "123/" + tc.expFolder + "/1.png"; original != expPath {
+				t.Errorf("Expected original URL '%s', got '%s'", imgsURLRoot+expPath,
+					u.URLs[model.VariantOriginal])
+			}
+			storePath, err := m.ResolveImage(original)
+			if err != nil {
+				t.Fatalf("model.ResolveImage(): %v", err)
+			}
+			if _, err := s.Stat(storePath); err != nil {
+				t.Errorf("Expected the image at its URL to be stored: %v", err)
+			}
+		})
 	}
 }
 
 func TestModel_NewBase64Image(t *testing.T) {
-	defer tearDown(t)
-	type NewImageTestCase struct {
-		Desc                string
-		DB                  *DBMock
-		FW                  *FileWriterMock
-		Token               claim.Auth
-		Image               string
-		Folder              string
-		ExpImgURLPrefix     string
-		ExpWriteFPathPrefix string
-		ExpDirCreated       bool
-		ExpErr              bool
-		ExpIsClErr          bool
-		ExpUnauthorized     bool
-	}
 	img1, err := ioutil.ReadFile("png_sample.png")
 	if err != nil {
 		t.Fatalf("Failed to set up (read test image file): %v", err)
 	}
-	tcs := []NewImageTestCase{
-		{
-			Desc:                "Successful save png",
-			DB:                  &DBMock{ExpDelErr: nil, ExpSaveErr: nil, ExpMetaID: 456},
-			FW:                  &FileWriterMock{ExpErr: nil},
-			Token:               claim.Auth{UsrID: 123},
-			Image:               base64.StdEncoding.EncodeToString(img1),
-			Folder:              "profile",
-			ExpImgURLPrefix:     imgsURLRoot + "123/profile/456.png",
-			ExpWriteFPathPrefix: imgsDir + "123/profile/456.png",
-			ExpErr:              false,
-			ExpIsClErr:          false,
-			ExpUnauthorized:     false,
-			ExpDirCreated:       true,
-		},
-		{
-			Desc:            "Empty image",
-			DB:              &DBMock{},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           "",
-			ExpErr:          true,
-			ExpIsClErr:      true,
-			ExpUnauthorized: false,
-		},
-		{
-			Desc:            "Invalid base64 encoding",
-			DB:              &DBMock{},
-			FW:              &FileWriterMock{ExpErr: nil},
-			Token:           claim.Auth{UsrID: 123},
-			Image:           "aGV%sb-G8sIHdvcmxkIQ", // note the '%' in the string
-			ExpErr:          true,
-			ExpIsClErr:      true,
-			ExpUnauthorized: false,
-		},
-	}
-	for _, tc := range tcs {
-		m, err := model.New(validConf, tc.DB, tc.FW)
-		if err != nil {
-			t.Fatalf("%s - model.New(): %v", tc.Desc, err)
-		}
-		st, imgURL, err := m.NewBase64Image(tc.Token, tc.Folder, tc.Image)
-		if st == "" {
-			t.Errorf("%s - server time was empty", tc.Desc)
-		}
-		if tc.ExpErr {
-			if err == nil {
-				t.Errorf("%s - expected an error but got nil", tc.Desc)
-				continue
-			}
-			if tc.ExpIsClErr && !m.IsClientError(err) {
-				t.Errorf("%s - expected client error but got %v", tc.Desc, err)
-			}
-			if !tc.ExpIsClErr && m.IsClientError(err) {
-				t.Errorf("%s - expected non-client error but got %v", tc.Desc, err)
-			}
-			if tc.ExpUnauthorized && !m.IsAuthError(err) {
-				t.Errorf("%s - expected unauthorized error but got %v", tc.Desc, err)
+	tt := []struct {
+		name     string
+		image    string
+		expErr   bool
+		expClErr bool
+	}{
+		{name: "png", image: base64.StdEncoding.EncodeToString(img1)},
+		{name: "empty image", image: "", expErr: true, expClErr: true},
+		{name: "invalid base64 encoding", image: "aGV%sb-G8sIHdvcmxkIQ",
+			expErr: true, expClErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			m, db, _ := newModel(t, validConf())
+			_, u, err := m.NewBase64Image("123", "profile", tc.image)
+			if tc.expErr {
+				if err == nil {
+					t.Fatal("Expected an error but got nil")
+				}
+				if errCheck.IsClientError(err) != tc.expClErr {
+					t.Errorf("Expected client error %t, got %v", tc.expClErr, err)
+				}
+				return
 			}
-			if !tc.ExpUnauthorized && m.IsAuthError(err) {
-				t.Errorf("%s - expected non-unauthorized error but got %v", tc.Desc, err)
+			if err != nil {
+				t.Fatalf("model.NewBase64Image(): %v", err)
 			}
-			if tc.FW.ExpErr != nil && !tc.DB.RecordMetaDeld {
-				t.Errorf("%s - expected meta rollback on file save error", tc.Desc)
+			if _, err := db.ImageMeta(1); err != nil || u.ID != "1" {
+				t.Errorf("Expected image meta 1 to be saved, got ID '%s': %v", u.ID, err)
 			}
-			continue
-		}
-		if err != nil {
-			t.Errorf("%s - model.NewImage(): %v", tc.Desc, err)
-		}
-		if imgURL != tc.ExpImgURLPrefix {
-			t.Errorf("%s - image URL mismatch: expect '%s', got '%s'", tc.Desc, tc.ExpImgURLPrefix, imgURL)
-		}
-		if !tc.DB.RecordMetaSaved {
-			t.Errorf("%s - DB did not record meta saving", tc.Desc)
-		}
-		if tc.FW.RecordWriteFilePath != tc.ExpWriteFPathPrefix {
-			t.Errorf("%s - Expected write to file at '%s', got '%s'",
-				tc.Desc, tc.ExpWriteFPathPrefix, tc.FW.RecordWriteFilePath)
-		}
-		if _, err := os.Stat(path.Dir(tc.ExpWriteFPathPrefix)); tc.ExpDirCreated && err != nil {
-			t.Errorf("%s - Expected parent directory to be created"+
-				" but: %v", tc.Desc, err)
-		}
+		})
+	}
+}
+
+func TestModel_DeleteImage_sharedContent(t *testing.T) {
+	m, _, s := newModel(t, validConf())
+	img := encodePNG(t, 10, 10, color.White)
+	first := upload(t, m, "123", "general", img)
+	second := upload(t, m, "456", "general", img)
+	blob, err := m.ResolveImage(strings.TrimPrefix(first.URLs[model.VariantOriginal], imgsURLRoot))
+	if err != nil {
+		t.Fatalf("model.ResolveImage(): %v", err)
+	}
+
+	if err := m.DeleteImage("123", first.ID); err != nil {
+		t.Fatalf("model.DeleteImage(): %v", err)
+	}
+	if _, err := s.Stat(blob); err != nil {
+		t.Errorf("Expected content still referenced to be kept: %v", err)
+	}
+	if err := m.DeleteImage("123", second.ID); !errCheck.IsForbiddenError(err) {
+		t.Errorf("Expected a forbidden error deleting another user's image, got %v", err)
+	}
+	if err := m.DeleteImage("456", second.ID); err != nil {
+		t.Fatalf("model.DeleteImage(): %v", err)
+	}
+	if _, err := s.Stat(blob); !s.IsNotFoundError(err) {
+		t.Errorf("Expected unreferenced content to be removed, got %v", err)
+	}
+}
+
+// failingStorage fails to store files.
+type failingStorage struct {
+	model.Storage
+}
+
+func (s failingStorage) Put(path string, r io.Reader, size int64) error {
+	return errors.New("disk full")
+}
+
+// undeletableStorage fails to delete files.
+type undeletableStorage struct {
+	model.Storage
+}
+
+func (s undeletableStorage) Delete(path string) error {
+	return errors.New("permission denied")
+}
+
+func TestModel_DeleteImage_removeError(t *testing.T) {
+	db := &DBMock{}
+	m, err := model.New(validConf(), &TokenValidatorMock{}, db,
+		undeletableStorage{Storage: storage.NewMemory()})
+	if err != nil {
+		t.Fatalf("model.New(): %v", err)
+	}
+	u := upload(t, m, "123", "general", encodePNG(t, 10, 10, color.White))
+	if err := m.DeleteImage("123", u.ID); err != nil {
+		t.Fatalf("Expected content removal errors not to fail the deletion, got %v", err)
+	}
+	if len(db.metas) > 0 || len(db.refs) > 0 {
+		t.Errorf("Expected the image to be deleted, got metas %v and references %v",
+			db.metas, db.refs)
 	}
 }
 
-func tearDown(t *testing.T) {
-	err := os.RemoveAll("test")
+func TestModel_NewImage_storageError(t *testing.T) {
+	db := &DBMock{}
+	m, err := model.New(validConf(), &TokenValidatorMock{}, db,
+		failingStorage{Storage: storage.NewMemory()})
 	if err != nil {
-		t.Logf("Error tearing down: %v", err)
+		t.Fatalf("model.New(): %v", err)
+	}
+	img := encodePNG(t, 10, 10, color.White)
+	_, _, err = m.NewImage("123", "", "", ioutil.NopCloser(bytes.NewReader(img)))
+	if err == nil {
+		t.Fatal("Expected an error but got nil")
+	}
+	if len(db.metas) > 0 || len(db.refs) > 0 {
+		t.Errorf("Expected nothing to be saved, got metas %v and references %v",
+			db.metas, db.refs)
+	}
+}
+
+// encodeGIF returns a w x h GIF of frames alternating between black and
+// white.
+func encodeGIF(t *testing.T, w, h, frames int) []byte {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Config: image.Config{ColorModel: pal, Width: w, Height: h}}
+	for i := 0; i < frames; i++ {
+		f := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+		for j := range f.Pix {
+			f.Pix[j] = uint8(i % 2)
+		}
+		g.Image = append(g.Image, f)
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatalf("encode test GIF: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestModel_NewImage_gif(t *testing.T) {
+	tt := []struct {
+		name      string
+		image     []byte
+		expFrames int
+		expClErr  bool
+	}{
+		{name: "animated", image: encodeGIF(t, 16, 16, 3), expFrames: 3},
+		{name: "too many frames", image: encodeGIF(t, 1, 1, 1001), expClErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := validConf()
+			conf.ExpVariants = map[string]string{"thumb": "8w"}
+			m, db, s := newModel(t, conf)
+			_, u, err := m.NewImage("123", "", "", ioutil.NopCloser(bytes.NewReader(tc.image)))
+			if tc.expClErr {
+				if !errCheck.IsClientError(err) {
+					t.Fatalf("Expected a client error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("model.NewImage(): %v", err)
+			}
+			meta, _ := db.ImageMeta(1)
+			if meta.Animation == nil || meta.Animation.Frames != tc.expFrames {
+				t.Errorf("Expected %d frames, got %+v", tc.expFrames, meta.Animation)
+			}
+			r, err := s.Get(strings.TrimPrefix(u.URLs["thumb"], imgsURLRoot), 0, -1)
+			if err != nil {
+				t.Fatalf("Get variant: %v", err)
+			}
+			defer r.Close()
+			g, err := gif.DecodeAll(r)
+			if err != nil {
+				t.Fatalf("decode variant: %v", err)
+			}
+			if len(g.Image) != tc.expFrames || g.Config.Width != 8 {
+				t.Errorf("Expected an 8px wide variant of %d frames, got %dpx of %d",
+					tc.expFrames, g.Config.Width, len(g.Image))
+			}
+		})
 	}
 }
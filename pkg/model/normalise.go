@@ -0,0 +1,74 @@
+package model
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/svg"
+)
+
+// sanitiseSVG replaces the SVG content of upload with a copy stripped of
+// anything that a browser would run or fetch, see svg.Sanitise, and
+// returns its dimensions.
+func sanitiseSVG(upload *spooledUpload) (image.Config, error) {
+	data, err := upload.readAll()
+	if err != nil {
+		return image.Config{}, err
+	}
+	clean, conf, err := svg.Sanitise(data)
+	if err != nil {
+		return conf, errors.NewClientf("invalid SVG: %v", err)
+	}
+	return conf, upload.replace(clean)
+}
+
+// normalise applies the EXIF orientation of img to its pixels if
+// m.autoOrient is set and strips EXIF and XMP metadata from img if
+// m.stripMeta is set. It returns the resulting image, its format and its
+// dimensions, which differ from the input's if the image had to be
+// re-encoded.
+func (m *Model) normalise(img []byte, format string, conf image.Config) ([]byte, string, image.Config, error) {
+
+	orientation := 1
+	if m.autoOrient {
+		if e, err := exif.Read(img); err == nil {
+			orientation = e.Orientation()
+		}
+	}
+
+	// Re-encoding drops all metadata. TIFF metadata lives in the same
+	// structure as the image data so re-encoding is the only way to strip it.
+	if orientation != 1 || (m.stripMeta && format == imaging.FormatTIFF) {
+		decoded, _, err := image.Decode(bytes.NewReader(img))
+		if err != nil {
+			return nil, "", conf, errors.NewClientf("unable to decode image: %v", err)
+		}
+		decoded = imaging.Orient(decoded, orientation)
+		format = imaging.EncodableFormat(format)
+		buf := &bytes.Buffer{}
+		if err := imaging.Encode(buf, decoded, format, m.encOpts); err != nil {
+			return nil, "", conf, errors.Newf("encode oriented image: %v", err)
+		}
+		// the pixels are unchanged so their colour space still applies.
+		oriented, err := exif.CopyICCProfile(img, buf.Bytes())
+		if err != nil {
+			return nil, "", conf, errors.NewClientf("unable to read ICC profile: %v", err)
+		}
+		b := decoded.Bounds()
+		conf.Width, conf.Height = b.Dx(), b.Dy()
+		return oriented, format, conf, nil
+	}
+
+	if m.stripMeta {
+		stripped, err := exif.Strip(img)
+		if err != nil {
+			return nil, "", conf, errors.NewClientf("unable to strip image metadata: %v", err)
+		}
+		return stripped, format, conf, nil
+	}
+
+	return img, format, conf, nil
+}
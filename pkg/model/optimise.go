@@ -0,0 +1,63 @@
+package model
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/exif"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// DefaultOptimisedJPEGQuality is the quality JPEGs are re-encoded at when
+// optimising uploads if none is configured.
+const DefaultOptimisedJPEGQuality = 85
+
+// optimiseUpload re-encodes a PNG upload at the best compression and a
+// JPEG upload at m.optQuality, progressive if m.optProgress, replacing the
+// upload's content with the result, metadata included, if it is smaller.
+// img is the decoded upload.
+// Other formats, animated PNGs and CMYK JPEGs, whose colours the encoder
+// would not preserve, are left as they are.
+func (m *Model) optimiseUpload(upload *spooledUpload, img image.Image, format string) error {
+
+	opts := *m.encOpts
+	switch format {
+	case imaging.FormatPNG:
+		// the acTL chunk of animated PNGs precedes the image data.
+		if bytes.Contains(upload.header, []byte("acTL")) {
+			return nil
+		}
+		opts.PNGCompression = png.BestCompression
+	case imaging.FormatJPEG:
+		if _, isCMYK := img.(*image.CMYK); isCMYK {
+			return nil
+		}
+		opts.JPEGQuality = m.optQuality
+		if opts.JPEGQuality == 0 {
+			opts.JPEGQuality = DefaultOptimisedJPEGQuality
+		}
+		opts.JPEGProgressive = m.optProgress
+	default:
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := imaging.Encode(buf, img, format, &opts); err != nil {
+		return errors.Newf("encode optimised image: %v", err)
+	}
+	if int64(buf.Len()) >= upload.size {
+		return nil
+	}
+	orig, err := upload.readAll()
+	if err != nil {
+		return err
+	}
+	optimised, err := exif.CopyMetadata(orig, buf.Bytes())
+	if err != nil || int64(len(optimised)) >= upload.size {
+		// keep the original rather than lose its metadata.
+		return nil
+	}
+	return upload.replace(optimised)
+}
@@ -0,0 +1,124 @@
+package model_test
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+func TestModel_NewImage_optimise(t *testing.T) {
+	tt := []struct {
+		name           string
+		img            []byte
+		disabled       bool
+		quality        int
+		progressive    bool
+		expReplaced    bool
+		expProgressive bool
+	}{
+		{name: "uncompressed PNG", img: encodeNoisePNG(t, png.NoCompression), expReplaced: true},
+		{name: "best compressed PNG", img: encodeNoisePNG(t, png.BestCompression)},
+		{name: "high quality JPEG", img: encodeNoiseJPEG(t, 100), quality: 50, expReplaced: true},
+		{name: "high quality JPEG to progressive", img: encodeNoiseJPEG(t, 100), quality: 50,
+			progressive: true, expReplaced: true, expProgressive: true},
+		{name: "low quality JPEG", img: encodeNoiseJPEG(t, 20), quality: 100},
+		{name: "low quality JPEG to progressive", img: encodeNoiseJPEG(t, 20), quality: 100,
+			progressive: true},
+		{name: "disabled", img: encodeNoisePNG(t, png.NoCompression), disabled: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := validConf()
+			conf.ExpOptimise = !tc.disabled
+			conf.ExpOptQuality = tc.quality
+			conf.ExpOptProgressive = tc.progressive
+			m, db, s := newModel(t, conf)
+			u := upload(t, m, "123", "general", tc.img)
+
+			ID, _ := strconv.ParseInt(u.ID, 10, 64)
+			meta, err := db.ImageMeta(ID)
+			if err != nil {
+				t.Fatalf("db.ImageMeta(): %v", err)
+			}
+			stored := readStored(t, m, s, u)
+			if meta.OriginalSize != int64(len(tc.img)) {
+				t.Errorf("Expected original size %d, got %d", len(tc.img), meta.OriginalSize)
+			}
+			if meta.Size != int64(len(stored)) {
+				t.Errorf("Expected size %d of the stored image, got %d", len(stored), meta.Size)
+			}
+			if replaced := !bytes.Equal(stored, tc.img); replaced != tc.expReplaced {
+				t.Fatalf("Expected the upload to be replaced %t, got %t (%d to %d bytes)",
+					tc.expReplaced, replaced, len(tc.img), len(stored))
+			}
+			if tc.expReplaced && meta.Size >= meta.OriginalSize {
+				t.Errorf("Expected the stored image to be smaller, got %d of %d bytes",
+					meta.Size, meta.OriginalSize)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(stored)); err != nil {
+				t.Errorf("decode stored image: %v", err)
+			}
+			// SOF2 starts a progressive frame.
+			if progressive := bytes.Contains(stored, []byte{0xff, 0xc2}); progressive != tc.expProgressive {
+				t.Errorf("Expected a progressive image %t, got %t", tc.expProgressive, progressive)
+			}
+		})
+	}
+}
+
+// readStored reads the stored content of the image u uploaded to m.
+func readStored(t *testing.T, m *model.Model, s model.Storage, u *model.Upload) []byte {
+	storePath, err := m.ResolveImage(strings.TrimPrefix(u.URLs[model.VariantOriginal], imgsURLRoot))
+	if err != nil {
+		t.Fatalf("model.ResolveImage(): %v", err)
+	}
+	r, err := s.Get(storePath, 0, -1)
+	if err != nil {
+		t.Fatalf("get stored image: %v", err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read stored image: %v", err)
+	}
+	return data
+}
+
+// noiseImage returns a 64 x 64 gradient with some pseudo-random noise so
+// that it neither compresses to nothing nor is incompressible.
+func noiseImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	seed := uint32(1)
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			seed = seed*1664525 + 1013904223
+			i := img.PixOffset(x, y)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] =
+				uint8(4*x), uint8(4*y), uint8(seed>>28), 0xff
+		}
+	}
+	return img
+}
+
+func encodeNoisePNG(t *testing.T, level png.CompressionLevel) []byte {
+	buf := &bytes.Buffer{}
+	if err := (&png.Encoder{CompressionLevel: level}).Encode(buf, noiseImage()); err != nil {
+		t.Fatalf("encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeNoiseJPEG(t *testing.T, quality int) []byte {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, noiseImage(), &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+const (
+	// PaletteSize is the maximum number of colours extracted into an
+	// image's palette.
+	PaletteSize = 5
+	// MaxColorDistance is the euclidean distance between black and white
+	// in RGB space, the furthest apart two colours can be.
+	MaxColorDistance = 441.7
+
+	// paletteSampleSize is the bounding box images are scaled down to
+	// before extracting their palette.
+	paletteSampleSize = 64
+	// minPaletteAlpha is the alpha below which pixels are too transparent
+	// to count towards an image's palette.
+	minPaletteAlpha = 0x80
+	// paletteMergeDistance is the distance within which median-cut boxes
+	// are considered the same colour.
+	paletteMergeDistance = 24
+)
+
+// PaletteColor is one of the colours making up an image.
+type PaletteColor struct {
+	// Color is the colour in the form #rrggbb.
+	Color string `json:"color"`
+	// Weight is the fraction of the image's pixels closest to Color.
+	Weight float64 `json:"weight"`
+}
+
+// HexColor formats the colour with the provided components as #rrggbb.
+func HexColor(r, g, b uint8) string {
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// extractPalette returns up to PaletteSize colours of img heaviest first
+// using median-cut quantisation.
+func extractPalette(img image.Image) []PaletteColor {
+
+	sample := imaging.Resize(img, paletteSampleSize, paletteSampleSize, imaging.FitContain)
+	b := sample.Bounds()
+	pixels := make([]color.RGBA, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(sample.At(x, y)).(color.NRGBA)
+			if c.A < minPaletteAlpha {
+				continue
+			}
+			pixels = append(pixels, color.RGBA{R: c.R, G: c.G, B: c.B, A: 0xff})
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := []colorBox{newColorBox(pixels)}
+	for len(boxes) < PaletteSize {
+		// split the box with the widest channel range.
+		split := -1
+		for i, box := range boxes {
+			if len(box.pixels) > 1 && box.rangeSize > 0 &&
+				(split < 0 || box.rangeSize > boxes[split].rangeSize) {
+				split = i
+			}
+		}
+		if split < 0 {
+			break
+		}
+		lo, hi := boxes[split].split()
+		boxes[split] = lo
+		boxes = append(boxes, hi)
+	}
+
+	// splitting uniform regions leaves boxes of near identical colours,
+	// merge those.
+	var avgs []color.RGBA
+	var counts []int
+merging:
+	for _, box := range boxes {
+		avg := box.average()
+		for i, other := range avgs {
+			if colorDistance(avg, other) <= paletteMergeDistance {
+				avgs[i] = weightedAverage(other, counts[i], avg, len(box.pixels))
+				counts[i] += len(box.pixels)
+				continue merging
+			}
+		}
+		avgs = append(avgs, avg)
+		counts = append(counts, len(box.pixels))
+	}
+
+	palette := make([]PaletteColor, len(avgs))
+	for i, avg := range avgs {
+		palette[i] = PaletteColor{
+			Color:  HexColor(avg.R, avg.G, avg.B),
+			Weight: float64(counts[i]) / float64(len(pixels)),
+		}
+	}
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i].Weight > palette[j].Weight
+	})
+	return palette
+}
+
+func colorDistance(a, b color.RGBA) float64 {
+	dr, dg, db := float64(a.R)-float64(b.R), float64(a.G)-float64(b.G),
+		float64(a.B)-float64(b.B)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+func weightedAverage(a color.RGBA, aWeight int, b color.RGBA, bWeight int) color.RGBA {
+	avg := func(x, y uint8) uint8 {
+		return uint8((int(x)*aWeight + int(y)*bWeight) / (aWeight + bWeight))
+	}
+	return color.RGBA{R: avg(a.R, b.R), G: avg(a.G, b.G), B: avg(a.B, b.B), A: 0xff}
+}
+
+// colorBox is a set of pixels along with the channel over which the
+// pixels' values vary the most.
+type colorBox struct {
+	pixels    []color.RGBA
+	channel   int
+	rangeSize uint8
+}
+
+func newColorBox(pixels []color.RGBA) colorBox {
+	box := colorBox{pixels: pixels}
+	lo := [3]uint8{0xff, 0xff, 0xff}
+	var hi [3]uint8
+	for _, p := range pixels {
+		for ch, v := range [3]uint8{p.R, p.G, p.B} {
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+	for ch := range lo {
+		if r := hi[ch] - lo[ch]; r > box.rangeSize {
+			box.channel, box.rangeSize = ch, r
+		}
+	}
+	return box
+}
+
+// split divides the box at the median of its widest channel.
+func (box colorBox) split() (colorBox, colorBox) {
+	sort.Slice(box.pixels, func(i, j int) bool {
+		return channelValue(box.pixels[i], box.channel) <
+			channelValue(box.pixels[j], box.channel)
+	})
+	mid := len(box.pixels) / 2
+	return newColorBox(box.pixels[:mid]), newColorBox(box.pixels[mid:])
+}
+
+func (box colorBox) average() color.RGBA {
+	var r, g, b int
+	for _, p := range box.pixels {
+		r, g, b = r+int(p.R), g+int(p.G), b+int(p.B)
+	}
+	n := len(box.pixels)
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 0xff}
+}
+
+func channelValue(c color.RGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	}
+	return c.B
+}
+
+// ImageMetasByColor lists metadata of images belonging to the owner of
+// token whose dominant colour is within maxDistance of hexColor, nearest
+// first. maxDistance is the euclidean distance in RGB space.
+func (m *Model) ImageMetasByColor(token, hexColor string, maxDistance float64, offset, count int64) ([]ImageMeta, error) {
+
+	t, err := m.validateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := imaging.ParseHexColor(hexColor)
+	if err != nil {
+		return nil, errors.NewClientf("invalid colour: %v", err)
+	}
+	if maxDistance <= 0 || maxDistance > MaxColorDistance {
+		return nil, errors.NewClientf("colour distance must be greater than 0 and at most %.1f",
+			MaxColorDistance)
+	}
+	if offset < 0 || count < 1 {
+		return nil, errors.NewClient("offset cannot be negative and count must be positive")
+	}
+
+	metas, err := m.db.ImageMetasByDominantColor(t.UsrID, c.(color.RGBA),
+		maxDistance, offset, count)
+	if err != nil {
+		if m.db.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("no images found near colour")
+		}
+		return nil, errors.Newf("get image metas by colour: %v", err)
+	}
+
+	for i := range metas {
+		metas[i].URL = m.metaURL(metas[i])
+	}
+	return metas, nil
+}
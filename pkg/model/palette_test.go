@@ -0,0 +1,134 @@
+package model
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var (
+	red     = color.RGBA{R: 0xff, A: 0xff}
+	darkRed = color.RGBA{R: 0xc8, G: 0x14, B: 0x14, A: 0xff}
+	orange  = color.RGBA{R: 0xff, G: 0x80, A: 0xff}
+	green   = color.RGBA{G: 0xff, A: 0xff}
+	blue    = color.RGBA{B: 0xff, A: 0xff}
+	white   = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	black   = color.RGBA{A: 0xff}
+)
+
+// colorBlock is a vertical strip of an image, width pixels wide, filled
+// with c.
+type colorBlock struct {
+	c     color.RGBA
+	width int
+}
+
+func TestExtractPalette(t *testing.T) {
+	tt := []struct {
+		name   string
+		blocks []colorBlock
+		exp    []PaletteColor
+	}{
+		{name: "single colour", blocks: []colorBlock{{red, 64}},
+			exp: []PaletteColor{{"#ff0000", 1}}},
+		{name: "halves", blocks: []colorBlock{{green, 32}, {blue, 32}},
+			exp: []PaletteColor{{"#00ff00", 0.5}, {"#0000ff", 0.5}}},
+		{name: "heaviest first", blocks: []colorBlock{{blue, 16}, {red, 32}, {green, 16}},
+			exp: []PaletteColor{{"#ff0000", 0.5}, {"#0000ff", 0.25}, {"#00ff00", 0.25}}},
+		{name: "quarters", blocks: []colorBlock{{red, 16}, {green, 16}, {blue, 16}, {white, 16}},
+			exp: []PaletteColor{{"#ff0000", 0.25}, {"#00ff00", 0.25}, {"#0000ff", 0.25},
+				{"#ffffff", 0.25}}},
+		{name: "near colours merged", blocks: []colorBlock{{red, 32}, {color.RGBA{R: 0xeb, A: 0xff}, 32}},
+			exp: []PaletteColor{{"#f50000", 1}}},
+		{name: "transparent pixels ignored", blocks: []colorBlock{{color.RGBA{}, 48}, {green, 16}},
+			exp: []PaletteColor{{"#00ff00", 1}}},
+		{name: "fully transparent", blocks: []colorBlock{{color.RGBA{}, 64}}},
+		{name: "more colours than the palette holds", blocks: []colorBlock{
+			{red, 8}, {green, 8}, {blue, 8}, {white, 8}, {black, 8},
+			{color.RGBA{R: 0xff, G: 0xff, A: 0xff}, 8}, {color.RGBA{G: 0xff, B: 0xff, A: 0xff}, 8},
+			{color.RGBA{R: 0xff, B: 0xff, A: 0xff}, 8},
+		}, exp: []PaletteColor{{"#ff007f", 0.25}, {"#00ff7f", 0.25}, {"#ffff7f", 0.25},
+			{"#000000", 0.125}, {"#0000ff", 0.125}}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := extractPalette(blocksImage(tc.blocks...))
+			if len(got) != len(tc.exp) {
+				t.Fatalf("Expected palette %+v, got %+v", tc.exp, got)
+			}
+			if !sort.SliceIsSorted(got, func(i, j int) bool { return got[i].Weight > got[j].Weight }) {
+				t.Errorf("Expected the heaviest colours first, got %+v", got)
+			}
+			// colours of equal weight may come in any order.
+			if !reflect.DeepEqual(paletteWeights(got), paletteWeights(tc.exp)) {
+				t.Errorf("Expected palette %+v, got %+v", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestColorDistance(t *testing.T) {
+	if d := colorDistance(black, white); math.Abs(d-MaxColorDistance) > 0.05 {
+		t.Errorf("Expected black and white to be %.1f apart, got %f", MaxColorDistance, d)
+	}
+	dominant := []color.RGBA{white, orange, black, red, darkRed}
+	tt := []struct {
+		name        string
+		c           color.RGBA
+		maxDistance float64
+		exp         []color.RGBA
+	}{
+		{name: "all", c: red, maxDistance: MaxColorDistance,
+			exp: []color.RGBA{red, darkRed, orange, black, white}},
+		{name: "near", c: red, maxDistance: 100, exp: []color.RGBA{red, darkRed}},
+		{name: "grey", c: color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}, maxDistance: 221,
+			exp: []color.RGBA{darkRed, orange, white}},
+		{name: "none near", c: blue, maxDistance: 100},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []color.RGBA
+			for _, c := range dominant {
+				if colorDistance(tc.c, c) <= tc.maxDistance {
+					got = append(got, c)
+				}
+			}
+			sort.Slice(got, func(i, j int) bool {
+				return colorDistance(tc.c, got[i]) < colorDistance(tc.c, got[j])
+			})
+			if !reflect.DeepEqual(got, tc.exp) {
+				t.Errorf("Expected %v nearest first, got %v", tc.exp, got)
+			}
+		})
+	}
+}
+
+// blocksImage returns a 64 pixel high image made up of blocks left to
+// right. Blocks adding up to 64 pixels wide keep the image from being
+// resampled before its palette is extracted.
+func blocksImage(blocks ...colorBlock) image.Image {
+	var w int
+	for _, b := range blocks {
+		w += b.width
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, 64))
+	x := 0
+	for _, b := range blocks {
+		draw.Draw(img, image.Rect(x, 0, x+b.width, 64), image.NewUniform(b.c), image.ZP, draw.Src)
+		x += b.width
+	}
+	return img
+}
+
+// paletteWeights maps the colours of palette to their weights.
+func paletteWeights(palette []PaletteColor) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, c := range palette {
+		weights[c.Color] = c.Weight
+	}
+	return weights
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+const (
+	lqipWidth         = 16
+	lqipJPEGQuality   = 60
+	blurHashXComps    = 4
+	blurHashYComps    = 3
+	lqipDataURIPrefix = "data:image/jpeg;base64,"
+)
+
+// Placeholder holds stand-ins for an image that clients can paint while
+// the image itself loads.
+type Placeholder struct {
+	// BlurHash is the image's BlurHash (https://blurha.sh).
+	BlurHash string `json:"blurHash,omitempty"`
+	// LQIP is a data URI of a tiny low quality JPEG version of the image.
+	LQIP string `json:"LQIP,omitempty"`
+}
+
+func (m *Model) placeholder(img image.Image) (Placeholder, error) {
+	hash, err := imaging.BlurHash(img, blurHashXComps, blurHashYComps)
+	if err != nil {
+		return Placeholder{}, err
+	}
+	lqip := &bytes.Buffer{}
+	opts := &imaging.EncodeOptions{JPEGQuality: lqipJPEGQuality,
+		Background: m.encOpts.Background}
+	tiny := imaging.Resize(img, lqipWidth, 0, imaging.FitContain)
+	if err := imaging.Encode(lqip, tiny, imaging.FormatJPEG, opts); err != nil {
+		return Placeholder{}, err
+	}
+	return Placeholder{
+		BlurHash: hash,
+		LQIP:     lqipDataURIPrefix + base64.StdEncoding.EncodeToString(lqip.Bytes()),
+	}, nil
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// UploadPolicy limits the images that may be uploaded. Zero values impose
+// no limit.
+type UploadPolicy struct {
+	// MaxBytes is the largest size of an upload's encoded content.
+	MaxBytes int64
+	// MaxWidth and MaxHeight are the largest dimensions in pixels.
+	MaxWidth  int
+	MaxHeight int
+	// MaxMegapixels is the largest width x height in millions of pixels.
+	MaxMegapixels float64
+	// MaxFrames is the largest number of frames of an animated image.
+	MaxFrames int
+	// MaxTotalPixels is the largest width x height x frames i.e. the
+	// number of pixels held in memory to process all frames.
+	MaxTotalPixels int64
+	// AllowedTypes lists the MIME types that may be uploaded.
+	AllowedTypes []string
+}
+
+func (p UploadPolicy) validate() error {
+	if p.MaxBytes < 0 || p.MaxWidth < 0 || p.MaxHeight < 0 || p.MaxMegapixels < 0 ||
+		p.MaxFrames < 0 || p.MaxTotalPixels < 0 {
+		return errors.New("upload policy limits cannot be negative")
+	}
+	for _, t := range p.AllowedTypes {
+		if imaging.FormatOf(t) == "" {
+			return errors.Newf("upload policy allows unknown MIME type '%s'", t)
+		}
+	}
+	return nil
+}
+
+// check checks the format and dimensions of an image, as read by
+// image.DecodeConfig, against p. It is meant to be called before decoding
+// the image so that images that would take too much memory to decode are
+// rejected.
+func (p UploadPolicy) check(format string, width, height int) error {
+	if len(p.AllowedTypes) > 0 {
+		allowed := false
+		for _, t := range p.AllowedTypes {
+			if imaging.FormatOf(t) == format {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.NewClientf("%s images are not allowed", imaging.MimeType(format))
+		}
+	}
+	if p.MaxWidth > 0 && width > p.MaxWidth {
+		return errors.NewClientf("image width %dpx exceeds the maximum of %dpx",
+			width, p.MaxWidth)
+	}
+	if p.MaxHeight > 0 && height > p.MaxHeight {
+		return errors.NewClientf("image height %dpx exceeds the maximum of %dpx",
+			height, p.MaxHeight)
+	}
+	if mp := float64(width) * float64(height) / 1e6; p.MaxMegapixels > 0 && mp > p.MaxMegapixels {
+		return errors.NewClientf("image has %.1f megapixels which exceeds the maximum of %g",
+			mp, p.MaxMegapixels)
+	}
+	return p.checkFrames(1, width, height)
+}
+
+// checkFrames checks the number of frames of an animated image of the
+// given dimensions against p. It is meant to be called, with the frames
+// counted by imaging.GIFFrameCount, before decoding all the frames.
+func (p UploadPolicy) checkFrames(frames, width, height int) error {
+	if p.MaxFrames > 0 && frames > p.MaxFrames {
+		return errors.NewClientf("image has %d frames which exceeds the maximum of %d",
+			frames, p.MaxFrames)
+	}
+	total := int64(frames) * int64(width) * int64(height)
+	if p.MaxTotalPixels > 0 && total > p.MaxTotalPixels {
+		return errors.NewClientf("image has %d pixels across all frames which exceeds the maximum of %d",
+			total, p.MaxTotalPixels)
+	}
+	return nil
+}
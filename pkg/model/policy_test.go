@@ -0,0 +1,126 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+var clErrCheck errors.ClErrCheck
+
+func TestUploadPolicy_check(t *testing.T) {
+	tt := []struct {
+		name     string
+		policy   UploadPolicy
+		format   string
+		w, h     int
+		expValid bool
+	}{
+		{name: "no limits", format: imaging.FormatPNG, w: 10000, h: 10000, expValid: true},
+		{name: "allowed type", policy: UploadPolicy{AllowedTypes: []string{"image/png"}},
+			format: imaging.FormatPNG, w: 10, h: 10, expValid: true},
+		{name: "type not allowed", policy: UploadPolicy{AllowedTypes: []string{"image/png"}},
+			format: imaging.FormatGIF, w: 10, h: 10},
+		{name: "max width", policy: UploadPolicy{MaxWidth: 10},
+			format: imaging.FormatPNG, w: 10, h: 100, expValid: true},
+		{name: "too wide", policy: UploadPolicy{MaxWidth: 10},
+			format: imaging.FormatPNG, w: 11, h: 1},
+		{name: "too high", policy: UploadPolicy{MaxHeight: 10},
+			format: imaging.FormatPNG, w: 1, h: 11},
+		{name: "max megapixels", policy: UploadPolicy{MaxMegapixels: 1},
+			format: imaging.FormatPNG, w: 1000, h: 1000, expValid: true},
+		{name: "too many megapixels", policy: UploadPolicy{MaxMegapixels: 1},
+			format: imaging.FormatPNG, w: 1000, h: 1001},
+		{name: "too many total pixels", policy: UploadPolicy{MaxTotalPixels: 99},
+			format: imaging.FormatPNG, w: 10, h: 10},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.check(tc.format, tc.w, tc.h)
+			if tc.expValid {
+				if err != nil {
+					t.Fatalf("UploadPolicy.check(): %v", err)
+				}
+				return
+			}
+			if !clErrCheck.IsClientError(err) {
+				t.Fatalf("Expected a client error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadPolicy_checkFrames(t *testing.T) {
+	tt := []struct {
+		name     string
+		policy   UploadPolicy
+		frames   int
+		expValid bool
+	}{
+		{name: "no limits", frames: 1000, expValid: true},
+		{name: "max frames", policy: UploadPolicy{MaxFrames: 10}, frames: 10, expValid: true},
+		{name: "too many frames", policy: UploadPolicy{MaxFrames: 10}, frames: 11},
+		{name: "max total pixels", policy: UploadPolicy{MaxTotalPixels: 1000}, frames: 10, expValid: true},
+		{name: "too many total pixels", policy: UploadPolicy{MaxTotalPixels: 1000}, frames: 11},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.checkFrames(tc.frames, 10, 10)
+			if tc.expValid {
+				if err != nil {
+					t.Fatalf("UploadPolicy.checkFrames(): %v", err)
+				}
+				return
+			}
+			if !clErrCheck.IsClientError(err) {
+				t.Fatalf("Expected a client error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadPolicy_spool(t *testing.T) {
+	content := strings.Repeat("imagems", 100)
+	digest := sha256.Sum256([]byte(content))
+	tt := []struct {
+		name     string
+		maxBytes int64
+		expValid bool
+	}{
+		{name: "no limit", expValid: true},
+		{name: "at limit", maxBytes: int64(len(content)), expValid: true},
+		{name: "over limit", maxBytes: int64(len(content)) - 1},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			su, err := UploadPolicy{MaxBytes: tc.maxBytes}.spool(strings.NewReader(content))
+			if !tc.expValid {
+				if !clErrCheck.IsClientError(err) {
+					t.Fatalf("Expected a client error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadPolicy.spool(): %v", err)
+			}
+			defer su.Close()
+			if su.size != int64(len(content)) {
+				t.Errorf("Expected size %d, got %d", len(content), su.size)
+			}
+			if expDigest := hex.EncodeToString(digest[:]); su.digest != expDigest {
+				t.Errorf("Expected digest %s, got %s", expDigest, su.digest)
+			}
+			data, err := su.readAll()
+			if err != nil {
+				t.Fatalf("spooledUpload.readAll(): %v", err)
+			}
+			if string(data) != content {
+				t.Errorf("Expected spooled content to match the upload")
+			}
+		})
+	}
+}
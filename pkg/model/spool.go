@@ -0,0 +1,146 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+// sniffLen is the number of leading bytes of an upload kept in memory
+// for reading its metadata without loading the whole upload.
+const sniffLen = 512 << 10
+
+// spooledUpload is upload content copied to a temporary file so that
+// it can be read several times without holding it in memory.
+type spooledUpload struct {
+	file   *os.File
+	size   int64
+	digest string
+	// header holds up to the first sniffLen bytes of the content.
+	header []byte
+}
+
+// spool copies r into a temporary file hashing the content and keeping
+// its header on the way. It fails once more than p.MaxBytes are read.
+// The returned upload must be closed to remove the temporary file.
+func (p UploadPolicy) spool(r io.Reader) (*spooledUpload, error) {
+	f, err := ioutil.TempFile("", "imagems-upload-")
+	if err != nil {
+		return nil, errors.Newf("create temporary upload file: %v", err)
+	}
+	su := &spooledUpload{file: f}
+	if p.MaxBytes > 0 {
+		r = io.LimitReader(r, p.MaxBytes+1)
+	}
+	src := &errRecorder{r: r}
+	if err := su.copyFrom(src); err != nil {
+		su.Close()
+		if src.err != nil {
+			return nil, errors.NewClientf("unable to read image content: %v", src.err)
+		}
+		return nil, errors.Newf("write temporary upload file: %v", err)
+	}
+	if p.MaxBytes > 0 && su.size > p.MaxBytes {
+		su.Close()
+		return nil, errors.NewClientf("image exceeds the maximum size of %d bytes",
+			p.MaxBytes)
+	}
+	return su, nil
+}
+
+// reader returns a reader of the content from the beginning.
+func (su *spooledUpload) reader() (io.Reader, error) {
+	if _, err := su.file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Newf("rewind temporary upload file: %v", err)
+	}
+	return bufio.NewReader(su.file), nil
+}
+
+// readAll reads the whole content into memory.
+func (su *spooledUpload) readAll() ([]byte, error) {
+	r, err := su.reader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Newf("read temporary upload file: %v", err)
+	}
+	return data, nil
+}
+
+// replace replaces the content with data.
+func (su *spooledUpload) replace(data []byte) error {
+	if _, err := su.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Newf("rewind temporary upload file: %v", err)
+	}
+	if err := su.file.Truncate(0); err != nil {
+		return errors.Newf("truncate temporary upload file: %v", err)
+	}
+	if err := su.copyFrom(bytes.NewReader(data)); err != nil {
+		return errors.Newf("write temporary upload file: %v", err)
+	}
+	return nil
+}
+
+func (su *spooledUpload) copyFrom(r io.Reader) error {
+	h := sha256.New()
+	header := &prefixWriter{max: sniffLen}
+	var err error
+	su.size, err = io.Copy(io.MultiWriter(su.file, h, header), r)
+	su.digest = hex.EncodeToString(h.Sum(nil))
+	su.header = header.buf
+	return err
+}
+
+// put stores the content at imgPath in s.
+func (su *spooledUpload) put(s Storage, imgPath string) error {
+	r, err := su.reader()
+	if err != nil {
+		return err
+	}
+	return s.Put(imgPath, r, su.size)
+}
+
+// Close removes the temporary file.
+func (su *spooledUpload) Close() error {
+	su.file.Close()
+	return os.Remove(su.file.Name())
+}
+
+// prefixWriter keeps the first max bytes written to it.
+type prefixWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// errRecorder remembers the error returned by r so that read errors can
+// be told apart from write errors when copying.
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errRecorder) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF {
+		er.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,204 @@
+package model
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"io"
+	"path"
+	"time"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// maxTransformDim is the largest width or height a transformation
+// may produce.
+const maxTransformDim = 8192
+
+// maxGIFFrames is the largest number of frames of a GIF that is decoded
+// frame by frame. gif.DecodeAll holds all frames in memory at once.
+const maxGIFFrames = 1000
+
+// Transformation describes alterations to make on a stored image
+// before serving it.
+type Transformation struct {
+	Width  int
+	Height int
+	Fit    imaging.Fit
+	// Format is the image format to convert to e.g. imaging.FormatJPEG.
+	// Empty means keep the stored image's format.
+	Format string
+	// Poster extracts the first frame of an animated image as a still.
+	// Otherwise animations converted to other formats also only keep
+	// their first frame.
+	Poster bool
+	// Filters are applied in order after resizing.
+	Filters []imaging.Filter
+}
+
+// TransformedImage is the encoded result of applying a Transformation.
+type TransformedImage struct {
+	Data     []byte
+	MimeType string
+	// ModTime is the modification time of the source image.
+	ModTime time.Time
+}
+
+// IsZero returns true if t does not alter the image.
+func (t Transformation) IsZero() bool {
+	return t.Width == 0 && t.Height == 0 && t.Format == "" && !t.Poster &&
+		len(t.Filters) == 0
+}
+
+func (t Transformation) validate() error {
+	if t.Width < 0 || t.Height < 0 {
+		return errors.NewClient("width and height cannot be negative")
+	}
+	if t.Width > maxTransformDim || t.Height > maxTransformDim {
+		return errors.NewClientf("width and height cannot exceed %d",
+			maxTransformDim)
+	}
+	if t.Fit != "" && !t.Fit.Valid() {
+		return errors.NewClientf("unknown fit '%s'", t.Fit)
+	}
+	if t.Format != "" && imaging.EncodableFormat(t.Format) != t.Format {
+		return errors.NewClientf("unsupported format '%s'", t.Format)
+	}
+	if len(t.Filters) > imaging.MaxFilters {
+		return errors.NewClientf("at most %d filters may be applied",
+			imaging.MaxFilters)
+	}
+	for _, f := range t.Filters {
+		if err := f.Validate(); err != nil {
+			return errors.NewClient(err)
+		}
+	}
+	return nil
+}
+
+// TransformImage reads the image served at imgPath (relative to the
+// storage root, see ResolveImage), applies t and any view watermark to it
+// and returns the re-encoded image.
+func (m *Model) TransformImage(imgPath string, t Transformation) (*TransformedImage, error) {
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	imgPath = path.Clean("/" + imgPath)[1:]
+	storePath, err := m.ResolveImage(imgPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := m.store.Stat(storePath)
+	if err != nil {
+		if m.store.IsNotFoundError(err) {
+			return nil, errors.NewNotFound("image not found")
+		}
+		return nil, errors.Newf("stat image: %v", err)
+	}
+	data, err := m.readFile(storePath)
+	if err != nil {
+		return nil, err
+	}
+	wm, err := m.viewWatermarkFor(storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// conversions, unlike other transformations, are made whenever the
+	// format is negotiated so they are cached.
+	var convPath string
+	if t.isConversion() {
+		t.Format = imaging.EncodableFormat(t.Format)
+		convPath = m.conversionPath(data, t.Format, wm)
+		converted, err := m.readConversion(convPath)
+		if err != nil {
+			return nil, err
+		}
+		if converted != nil {
+			return &TransformedImage{
+				Data:     converted,
+				MimeType: imaging.MimeType(t.Format),
+				ModTime:  info.ModTime,
+			}, nil
+		}
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.NewClient("transformations are not supported for this file")
+	}
+	img = m.toSRGB(img, colorSpace(data))
+
+	transform := func(img image.Image) image.Image {
+		img = imaging.Resize(img, t.Width, t.Height, t.Fit)
+		img = imaging.ApplyFilters(img, t.Filters)
+		if wm != nil {
+			img = wm.apply(img)
+		}
+		return img
+	}
+
+	animated := format == imaging.FormatGIF && !t.Poster &&
+		(t.Format == "" || t.Format == imaging.FormatGIF)
+	if t.Format != "" {
+		format = t.Format
+	}
+	format = imaging.EncodableFormat(format)
+	buf := &bytes.Buffer{}
+	if animated {
+		g, err := decodeGIF(bytes.NewReader(data), nil)
+		if err != nil {
+			return nil, err
+		}
+		err = gif.EncodeAll(buf, imaging.MapGIF(g, transform))
+	} else {
+		err = imaging.Encode(buf, transform(img), format, m.encOpts)
+	}
+	if err != nil {
+		return nil, errors.Newf("encode transformed image: %v", err)
+	}
+	if convPath != "" {
+		if err := m.writeConversion(convPath, buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return &TransformedImage{
+		Data:     buf.Bytes(),
+		MimeType: imaging.MimeType(format),
+		ModTime:  info.ModTime,
+	}, nil
+}
+
+// decodeGIF decodes all frames of the GIF read from r. The frames are
+// counted before any is decoded so that GIFs with more than maxGIFFrames
+// frames, or for which checkFrames, if not nil, fails, are refused
+// without holding their frames in memory.
+func decodeGIF(r io.ReadSeeker, checkFrames func(frames int) error) (*gif.GIF, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Newf("rewind GIF: %v", err)
+	}
+	n, err := imaging.GIFFrameCount(r)
+	if err != nil {
+		return nil, errors.NewClientf("invalid GIF: %v", err)
+	}
+	if n > maxGIFFrames {
+		return nil, errors.NewClientf("GIFs with more than %d frames are not supported",
+			maxGIFFrames)
+	}
+	if checkFrames != nil {
+		if err := checkFrames(n); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Newf("rewind GIF: %v", err)
+	}
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, errors.NewClientf("invalid GIF: %v", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,100 @@
+package model_test
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+func TestModel_TransformImage_conversionCache(t *testing.T) {
+	m, _, s := newModel(t, validConf())
+	u := upload(t, m, "123", "general", encodePNG(t, 8, 8, color.White))
+	imgPath := strings.TrimPrefix(u.URLs[model.VariantOriginal], imgsURLRoot)
+
+	tt := []struct {
+		name      string
+		t         model.Transformation
+		expCached bool
+	}{
+		{name: "conversion", t: model.Transformation{Format: imaging.FormatJPEG}, expCached: true},
+		{name: "resize", t: model.Transformation{Width: 4}},
+		{name: "resize and conversion", t: model.Transformation{Width: 4, Format: imaging.FormatGIF}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			before := listCache(t, s)
+			first, err := m.TransformImage(imgPath, tc.t)
+			if err != nil {
+				t.Fatalf("model.TransformImage(): %v", err)
+			}
+			cached := listCache(t, s)
+			if tc.expCached != (len(cached) == len(before)+1) {
+				t.Fatalf("Expected cached %t, cache went from %d to %d files",
+					tc.expCached, len(before), len(cached))
+			}
+			second, err := m.TransformImage(imgPath, tc.t)
+			if err != nil {
+				t.Fatalf("model.TransformImage(): %v", err)
+			}
+			if !bytes.Equal(first.Data, second.Data) || first.MimeType != second.MimeType {
+				t.Errorf("Expected repeated transformations to match")
+			}
+			if !tc.expCached {
+				return
+			}
+			if len(listCache(t, s)) != len(cached) {
+				t.Errorf("Expected the cached conversion to be reused")
+			}
+			if _, err := m.ResolveImage(cached[0]); !errCheck.IsNotFoundError(err) {
+				t.Errorf("Expected cached conversions not to be served, got %v", err)
+			}
+		})
+	}
+
+	if err := m.DeleteImage("123", u.ID); err != nil {
+		t.Fatalf("model.DeleteImage(): %v", err)
+	}
+	if cached := listCache(t, s); len(cached) > 0 {
+		t.Errorf("Expected conversions of removed content to be removed, got %v", cached)
+	}
+}
+
+func TestModel_TransformImage_conversionCacheSettings(t *testing.T) {
+	m, db, s := newModel(t, validConf())
+	u := upload(t, m, "123", "general", encodePNG(t, 8, 8, color.White))
+	imgPath := strings.TrimPrefix(u.URLs[model.VariantOriginal], imgsURLRoot)
+	conf := validConf()
+	conf.ExpSRGB = true
+	converting, err := model.New(conf, &TokenValidatorMock{}, db, s)
+	if err != nil {
+		t.Fatalf("model.New(): %v", err)
+	}
+
+	conversion := model.Transformation{Format: imaging.FormatJPEG}
+	if _, err := m.TransformImage(imgPath, conversion); err != nil {
+		t.Fatalf("model.TransformImage(): %v", err)
+	}
+	if _, err := converting.TransformImage(imgPath, conversion); err != nil {
+		t.Fatalf("model.TransformImage(): %v", err)
+	}
+	if cached := listCache(t, s); len(cached) != 2 {
+		t.Errorf("Expected a cached conversion per sRGB setting, got %v", cached)
+	}
+}
+
+// listCache lists the paths of cached conversions in s.
+func listCache(t *testing.T, s model.Storage) []string {
+	files, err := s.List("cache/")
+	if err != nil {
+		t.Fatalf("list cache: %v", err)
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	return paths
+}
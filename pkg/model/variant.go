@@ -0,0 +1,72 @@
+package model
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// VariantOriginal is the name under which the URL of the uploaded image
+// itself is reported alongside its variants.
+const VariantOriginal = "original"
+
+var (
+	variantNameRegex = regexp.MustCompile("^\\w+$")
+	variantBoxRegex  = regexp.MustCompile("^(\\d+)x(\\d+)$")
+	variantSideRegex = regexp.MustCompile("^(\\d+)(w|h)$")
+)
+
+// ParseVariant parses a variant spec into the Transformation it describes.
+// Specs take one of the forms:
+//
+//	150x150 cover  - width x height followed by an optional imaging.Fit
+//	800w           - width only, height scaled to match
+//	600h           - height only, width scaled to match
+func ParseVariant(spec string) (Transformation, error) {
+	t := Transformation{}
+	parts := strings.Fields(spec)
+	if len(parts) == 0 || len(parts) > 2 {
+		return t, errors.Newf("invalid variant spec '%s'", spec)
+	}
+	if m := variantBoxRegex.FindStringSubmatch(parts[0]); m != nil {
+		t.Width, _ = strconv.Atoi(m[1])
+		t.Height, _ = strconv.Atoi(m[2])
+		if len(parts) == 2 {
+			t.Fit = imaging.Fit(parts[1])
+		}
+	} else if m := variantSideRegex.FindStringSubmatch(parts[0]); m != nil && len(parts) == 1 {
+		dim, _ := strconv.Atoi(m[1])
+		if m[2] == "w" {
+			t.Width = dim
+		} else {
+			t.Height = dim
+		}
+	} else {
+		return t, errors.Newf("invalid variant spec '%s'", spec)
+	}
+	if t.IsZero() {
+		return t, errors.Newf("variant spec '%s' has no size", spec)
+	}
+	if err := t.validate(); err != nil {
+		return t, errors.Newf("variant spec '%s': %v", spec, err)
+	}
+	return t, nil
+}
+
+func parseVariants(specs map[string]string) (map[string]Transformation, error) {
+	variants := make(map[string]Transformation)
+	for name, spec := range specs {
+		if !variantNameRegex.MatchString(name) || name == VariantOriginal {
+			return nil, errors.Newf("invalid variant name '%s'", name)
+		}
+		t, err := ParseVariant(spec)
+		if err != nil {
+			return nil, err
+		}
+		variants[name] = t
+	}
+	return variants, nil
+}
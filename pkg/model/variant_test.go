@@ -0,0 +1,47 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+func TestParseVariant(t *testing.T) {
+	tt := []struct {
+		name   string
+		spec   string
+		exp    model.Transformation
+		expErr bool
+	}{
+		{name: "box", spec: "150x100", exp: model.Transformation{Width: 150, Height: 100}},
+		{name: "box with fit", spec: "150x150 cover",
+			exp: model.Transformation{Width: 150, Height: 150, Fit: imaging.FitCover}},
+		{name: "width", spec: "800w", exp: model.Transformation{Width: 800}},
+		{name: "height", spec: " 300h ", exp: model.Transformation{Height: 300}},
+		{name: "empty", spec: "", expErr: true},
+		{name: "no unit", spec: "150", expErr: true},
+		{name: "side with fit", spec: "800w cover", expErr: true},
+		{name: "unknown fit", spec: "150x150 stretch", expErr: true},
+		{name: "too many parts", spec: "150x150 cover extra", expErr: true},
+		{name: "zero size", spec: "0x0", expErr: true},
+		{name: "too large", spec: "9000w", expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := model.ParseVariant(tc.spec)
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %+v", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("model.ParseVariant(): %v", err)
+			}
+			if v.Width != tc.exp.Width || v.Height != tc.exp.Height || v.Fit != tc.exp.Fit {
+				t.Errorf("Expected %+v, got %+v", tc.exp, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"image"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
+)
+
+// Modes in which a Watermark is applied.
+const (
+	// WatermarkOnUpload bakes the watermark into the variants generated
+	// at upload. The uploaded image itself is stored clean.
+	WatermarkOnUpload = "upload"
+	// WatermarkOnView composites the watermark whenever the image or its
+	// variants are served, transformed or not. SVGs are served unmarked.
+	WatermarkOnView = "view"
+)
+
+// Defaults for Watermark options left empty.
+const (
+	DefWatermarkOpacity  = 0.5
+	DefWatermarkScale    = 0.25
+	DefWatermarkPosition = imaging.PositionBottomRight
+)
+
+// Watermark configures an image to composite onto images of selected
+// users and folders.
+type Watermark struct {
+	// ImagePath is the path to the watermark image file.
+	ImagePath string
+	// Users restricts the watermark to images of these user IDs.
+	// Empty means all users.
+	Users []string
+	// Folders restricts the watermark to images in these folders.
+	// Empty means all folders.
+	Folders []string
+	// Mode is one of WatermarkOnUpload or WatermarkOnView.
+	Mode string
+	imaging.WatermarkOptions
+}
+
+type watermark struct {
+	Watermark
+	img image.Image
+}
+
+func loadWatermarks(wms []Watermark) ([]watermark, error) {
+	loaded := make([]watermark, 0, len(wms))
+	for i, wm := range wms {
+		if wm.Mode != WatermarkOnUpload && wm.Mode != WatermarkOnView {
+			return nil, errors.Newf("watermark %d: mode must be '%s' or '%s'",
+				i, WatermarkOnUpload, WatermarkOnView)
+		}
+		if wm.Opacity == 0 {
+			wm.Opacity = DefWatermarkOpacity
+		}
+		if wm.Scale == 0 {
+			wm.Scale = DefWatermarkScale
+		}
+		if wm.Position == "" {
+			wm.Position = DefWatermarkPosition
+		}
+		if wm.Opacity < 0 || wm.Opacity > 1 {
+			return nil, errors.Newf("watermark %d: opacity must be between 0 and 1", i)
+		}
+		if wm.Scale < 0 || wm.Scale > 1 {
+			return nil, errors.Newf("watermark %d: scale must be between 0 and 1", i)
+		}
+		if wm.Margin < 0 {
+			return nil, errors.Newf("watermark %d: margin cannot be negative", i)
+		}
+		if !wm.Position.Valid() {
+			return nil, errors.Newf("watermark %d: unknown position '%s'", i, wm.Position)
+		}
+		f, err := os.Open(wm.ImagePath)
+		if err != nil {
+			return nil, errors.Newf("watermark %d: open image: %v", i, err)
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Newf("watermark %d: decode image: %v", i, err)
+		}
+		loaded = append(loaded, watermark{Watermark: wm, img: img})
+	}
+	return loaded, nil
+}
+
+func (wm watermark) matches(mode, userID, folder string) bool {
+	return wm.Mode == mode && matchesAny(wm.Users, userID) &&
+		matchesAny(wm.Folders, folder)
+}
+
+// apply composites wm onto img.
+func (wm watermark) apply(img image.Image) image.Image {
+	return imaging.Watermark(img, wm.img, wm.WatermarkOptions)
+}
+
+// watermarkFor returns the first watermark to apply in mode to images
+// of userID in folder or nil if none applies.
+func (m *Model) watermarkFor(mode, userID, folder string) *watermark {
+	for i := range m.watermarks {
+		if m.watermarks[i].matches(mode, userID, folder) {
+			return &m.watermarks[i]
+		}
+	}
+	return nil
+}
+
+// viewWatermarkFor returns the watermark to apply when serving the image
+// at imgPath (relative to the storage root) or nil if none applies.
+// Content stored by digest is shared by its owners' images so it is
+// watermarked if the image of any of its owners is.
+func (m *Model) viewWatermarkFor(imgPath string) (*watermark, error) {
+	if len(m.watermarks) == 0 {
+		return nil, nil
+	}
+	parts := strings.Split(strings.Trim(path.Clean("/"+imgPath), "/"), "/")
+	if len(parts) == 3 && parts[0] == blobsDir {
+		digest := strings.TrimSuffix(parts[2], path.Ext(parts[2]))
+		metas, err := m.db.ImageMetasByDigest(digest)
+		if err != nil && !m.db.IsNotFoundError(err) {
+			return nil, errors.Newf("get image metas by digest: %v", err)
+		}
+		for _, meta := range metas {
+			if wm := m.watermarkFor(WatermarkOnView, meta.UserID, meta.Folder); wm != nil {
+				return wm, nil
+			}
+		}
+		return m.watermarkFor(WatermarkOnView, "", ""), nil
+	}
+	if len(parts) < 3 {
+		return m.watermarkFor(WatermarkOnView, "", ""), nil
+	}
+	folder := path.Join(parts[1 : len(parts)-1]...)
+	return m.watermarkFor(WatermarkOnView, parts[0], folder), nil
+}
+
+// IsWatermarked returns true if a watermark is composited onto the image
+// served at imgPath (relative to the storage root, see ResolveImage), in
+// which case it has to be served through TransformImage even if it is not
+// otherwise transformed. SVGs cannot be watermarked and are never
+// reported as watermarked.
+func (m *Model) IsWatermarked(imgPath string) (bool, error) {
+	ext := path.Ext(imgPath)
+	if ext == "" || ext == "."+imaging.FormatSVG {
+		return false, nil
+	}
+	storePath, err := m.ResolveImage(imgPath)
+	if err != nil {
+		return false, err
+	}
+	wm, err := m.viewWatermarkFor(storePath)
+	return wm != nil, err
+}
+
+// matchesAny returns true if val is in vals or vals is empty.
+func matchesAny(vals []string, val string) bool {
+	if len(vals) == 0 {
+		return true
+	}
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
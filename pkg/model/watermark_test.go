@@ -0,0 +1,88 @@
+package model_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/imaging"
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+// writeWatermark writes a black watermark image to a temporary file
+// returning its path.
+func writeWatermark(t *testing.T) string {
+	f, err := ioutil.TempFile("", "imagems-watermark-")
+	if err != nil {
+		t.Fatalf("create watermark file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(encodePNG(t, 4, 4, color.Black)); err != nil {
+		t.Fatalf("write watermark file: %v", err)
+	}
+	return f.Name()
+}
+
+func TestModel_IsWatermarked(t *testing.T) {
+	wmPath := writeWatermark(t)
+	defer os.Remove(wmPath)
+	conf := validConf()
+	conf.ExpVariants = map[string]string{"thumb": "8w"}
+	conf.ExpWatermarks = []model.Watermark{{
+		ImagePath:        wmPath,
+		Users:            []string{"123"},
+		Mode:             model.WatermarkOnView,
+		WatermarkOptions: imaging.WatermarkOptions{Opacity: 1, Scale: 1},
+	}}
+	m, _, _ := newModel(t, conf)
+
+	white := encodePNG(t, 16, 16, color.White)
+	marked := upload(t, m, "123", "general", white)
+	shared := upload(t, m, "456", "general", white)
+	clean := upload(t, m, "456", "general", encodePNG(t, 16, 16, color.Gray{Y: 128}))
+	relPath := func(URL string) string {
+		return strings.TrimPrefix(URL, imgsURLRoot)
+	}
+
+	tt := []struct {
+		name    string
+		imgPath string
+		exp     bool
+	}{
+		{name: "owner's content", imgPath: relPath(marked.URLs[model.VariantOriginal]), exp: true},
+		{name: "content shared with owner", imgPath: relPath(shared.URLs[model.VariantOriginal]), exp: true},
+		{name: "other user's content", imgPath: relPath(clean.URLs[model.VariantOriginal])},
+		{name: "owner's variant", imgPath: relPath(marked.URLs["thumb"]), exp: true},
+		{name: "uncleaned owner's variant", imgPath: "/456/../" + relPath(marked.URLs["thumb"]), exp: true},
+		{name: "other user's variant", imgPath: relPath(clean.URLs["thumb"])},
+		{name: "folder", imgPath: "123/general/"},
+		{name: "svg", imgPath: "123/general/9.svg"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			watermarked, err := m.IsWatermarked(tc.imgPath)
+			if err != nil {
+				t.Fatalf("model.IsWatermarked(): %v", err)
+			}
+			if watermarked != tc.exp {
+				t.Errorf("Expected watermarked %t, got %t", tc.exp, watermarked)
+			}
+		})
+	}
+
+	served, err := m.TransformImage(relPath(marked.URLs[model.VariantOriginal]), model.Transformation{})
+	if err != nil {
+		t.Fatalf("model.TransformImage(): %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(served.Data))
+	if err != nil {
+		t.Fatalf("decode served image: %v", err)
+	}
+	if r, _, _, _ := img.At(8, 8).RGBA(); r > 0x1000 {
+		t.Errorf("Expected the untransformed image to be watermarked, got %v", img.At(8, 8))
+	}
+}
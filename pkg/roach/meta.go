@@ -1,40 +1,600 @@
 package roach
 
 import (
+	"context"
+	"database/sql"
+	"image/color"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach-go/crdb"
+	"github.com/lib/pq"
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/imaging"
 	"github.com/tomogoma/imagems/pkg/model"
 )
 
-func (r *Roach) SaveMeta(m model.ImageMeta) (int64, error) {
+var (
+	metaCols = ColDesc(
+		TblImageMeta+"."+ColID, TblImageMeta+"."+ColUserID, ColFolder, ColType,
+		ColMimeType, ColWidth, ColHeight, ColPHash, ColDigest, ColBlurHash,
+		ColLQIP, ColFrames, ColLoopCount, ColDuration, ColSize, ColOriginalSize,
+		ColColorSpace,
+		TblImageMeta+"."+ColCreateDate,
+		TblImageMeta+"."+ColUpdateDate,
+	)
+	exifCols = ColDesc(ColMake, ColModel, ColCaptureDate, ColExposureTime,
+		ColFNumber, ColISO, ColFocalLength, ColLatitude, ColLongitude)
+
+	metaWithExifFrom = TblImageMeta + `
+		LEFT JOIN ` + TblImageExif + `
+			ON ` + TblImageExif + `.` + ColImageID + `=` + TblImageMeta + `.` + ColID
+)
+
+// SaveMeta saves m and adds a reference to its blob, calling storeBlob
+// to store the blob's content if m is its first reference. Nothing is
+// saved if storeBlob fails.
+func (r *Roach) SaveMeta(m model.ImageMeta, storeBlob model.BlobFunc) (int64, error) {
 
 	if err := r.InitDBIfNot(); err != nil {
 		return -1, err
 	}
 
-	cols := ColDesc(ColUserID, ColType, ColMimeType, ColWidth, ColHeight,
-		ColCreateDate, ColUpdateDate)
-	q := `
-	INSERT INTO ` + TblImageMeta + ` (` + cols + `)
-		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING ` + ColID + `
-	`
 	var ID int64
-	err := r.db.QueryRow(q, m.UserID, m.Type, m.MimeType, m.Width, m.Height).
-		Scan(&ID)
+	err := crdb.ExecuteTx(context.Background(), r.db, nil, func(tx *sql.Tx) error {
+		if m.Digest != "" {
+			if err := refBlob(tx, m.Digest, m.Type, storeBlob); err != nil {
+				return err
+			}
+		}
+		cols := ColDesc(ColUserID, ColFolder, ColType, ColMimeType, ColWidth,
+			ColHeight, ColPHash, ColDigest, ColBlurHash, ColLQIP, ColFrames,
+			ColLoopCount, ColDuration, ColSize, ColOriginalSize, ColColorSpace,
+			ColCreateDate, ColUpdateDate)
+		q := `
+		INSERT INTO ` + TblImageMeta + ` (` + cols + `)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
+				$14, $15, $16, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			RETURNING ` + ColID + `
+		`
+		var frames, loopCount, duration sql.NullInt64
+		if m.Animation != nil {
+			frames = sql.NullInt64{Int64: int64(m.Animation.Frames), Valid: true}
+			loopCount = sql.NullInt64{Int64: int64(m.Animation.LoopCount), Valid: true}
+			duration = sql.NullInt64{Int64: int64(m.Animation.Duration), Valid: true}
+		}
+		err := tx.QueryRow(q, m.UserID, m.Folder, m.Type, m.MimeType, m.Width,
+			m.Height, m.PHash, m.Digest, m.BlurHash, m.LQIP, frames, loopCount,
+			duration, m.Size, m.OriginalSize, m.ColorSpace).Scan(&ID)
+		if err != nil {
+			return err
+		}
+		if err := insertPalette(tx, ID, m.Palette); err != nil {
+			return err
+		}
+		if m.Exif == nil {
+			return nil
+		}
+		return insertExif(tx, ID, *m.Exif)
+	})
 
 	return ID, err
 }
 
-func (r *Roach) DeleteMeta(id int64) error {
+// DeleteMeta marks the image meta with the provided id as deleted and
+// releases its reference to its blob, calling removeBlob to remove the
+// blob's content once committed if that was the last reference. The meta
+// stays deleted if removeBlob fails; its error is returned.
+func (r *Roach) DeleteMeta(id int64, removeBlob model.BlobFunc) error {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return err
+	}
+
+	var digest, typ string
+	var unrefd bool
+	err := crdb.ExecuteTx(context.Background(), r.db, nil, func(tx *sql.Tx) error {
+		unrefd = false
+		q := `
+		UPDATE ` + TblImageMeta + `
+			SET ` + ColDeleted + `=TRUE, ` + ColUpdateDate + `=CURRENT_TIMESTAMP
+			WHERE ` + ColID + `=$1 AND ` + ColDeleted + `=FALSE
+			RETURNING ` + ColDigest + `, ` + ColType + `
+		`
+		if err := tx.QueryRow(q, id).Scan(&digest, &typ); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.NewNotFound("image meta not found")
+			}
+			return err
+		}
+		if digest == "" {
+			return nil
+		}
+		var err error
+		unrefd, err = unrefBlob(tx, digest)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return removeUnrefd(unrefd, digest, typ, removeBlob)
+}
+
+// UpdateMeta replaces the content derived fields (type, dimensions,
+// hashes, placeholder, sizes, colour space and palette) of the (none-deleted) image meta
+// identified by m.ID and moves its blob reference to m.Digest. storeBlob
+// is called to store the content of m's blob if it was not referenced
+// and removeBlob, once committed, to remove the content of the previous
+// blob if the meta held its last reference. The meta is not updated if
+// storeBlob fails. It stays updated if removeBlob fails; its error is
+// returned.
+func (r *Roach) UpdateMeta(m model.ImageMeta, storeBlob, removeBlob model.BlobFunc) error {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return err
+	}
+
+	ID, err := strconv.ParseInt(m.ID, 10, 64)
+	if err != nil {
+		return errors.NewNotFound("image meta not found")
+	}
+
+	var prevDigest, prevType string
+	var unrefd bool
+	err = crdb.ExecuteTx(context.Background(), r.db, nil, func(tx *sql.Tx) error {
+		unrefd = false
+		q := `
+		SELECT ` + ColDigest + `, ` + ColType + `
+			FROM ` + TblImageMeta + `
+			WHERE ` + ColID + `=$1 AND ` + ColDeleted + `=FALSE
+			FOR UPDATE
+		`
+		if err := tx.QueryRow(q, ID).Scan(&prevDigest, &prevType); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.NewNotFound("image meta not found")
+			}
+			return err
+		}
+		if m.Digest != prevDigest && m.Digest != "" {
+			if err := refBlob(tx, m.Digest, m.Type, storeBlob); err != nil {
+				return err
+			}
+		}
+		q = `
+		UPDATE ` + TblImageMeta + `
+			SET ` + ColType + `=$1, ` + ColMimeType + `=$2, ` + ColWidth + `=$3,
+				` + ColHeight + `=$4, ` + ColPHash + `=$5, ` + ColDigest + `=$6,
+				` + ColBlurHash + `=$7, ` + ColLQIP + `=$8, ` + ColSize + `=$9,
+				` + ColOriginalSize + `=$10, ` + ColColorSpace + `=$11,
+				` + ColUpdateDate + `=CURRENT_TIMESTAMP
+			WHERE ` + ColID + `=$12
+		`
+		rslt, err := tx.Exec(q, m.Type, m.MimeType, m.Width, m.Height, m.PHash,
+			m.Digest, m.BlurHash, m.LQIP, m.Size, m.OriginalSize, m.ColorSpace, ID)
+		if err := checkRowsAffected(rslt, err, 1); err != nil {
+			return err
+		}
+		q = `DELETE FROM ` + TblImagePalette + ` WHERE ` + ColImageID + `=$1`
+		if _, err := tx.Exec(q, ID); err != nil {
+			return err
+		}
+		if err := insertPalette(tx, ID, m.Palette); err != nil {
+			return err
+		}
+		if m.Digest == prevDigest || prevDigest == "" {
+			return nil
+		}
+		unrefd, err = unrefBlob(tx, prevDigest)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return removeUnrefd(unrefd, prevDigest, prevType, removeBlob)
+}
+
+// ImageMeta returns the (none-deleted) image meta with the provided id
+// along with its EXIF data if any.
+func (r *Roach) ImageMeta(id int64) (*model.ImageMeta, error) {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT ` + metaCols + `, ` + exifCols + `
+		FROM ` + metaWithExifFrom + `
+		WHERE ` + TblImageMeta + `.` + ColID + `=$1
+			AND ` + ColDeleted + `=FALSE
+	`
+	m, err := scanMeta(r.db.QueryRow(q, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound("image meta not found")
+		}
+		return nil, err
+	}
+	if err := r.loadPalettes([]model.ImageMeta{*m}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImageMetasByUserID returns (none-deleted) image metas belonging to userID
+// ordered by sortBy (one of model.Sort...) starting with the newest.
+func (r *Roach) ImageMetasByUserID(userID string, sortBy string, offset, count int64) ([]model.ImageMeta, error) {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return nil, err
+	}
+
+	usrID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, errors.NewNotFound("only numeric IDs stored here")
+	}
+
+	orderBy := TblImageMeta + `.` + ColCreateDate + ` DESC`
+	if sortBy == model.SortCaptureDate {
+		orderBy = ColCaptureDate + ` IS NULL, ` + ColCaptureDate + ` DESC, ` + orderBy
+	}
+
+	q := `
+	SELECT ` + metaCols + `, ` + exifCols + `
+		FROM ` + metaWithExifFrom + `
+		WHERE ` + TblImageMeta + `.` + ColUserID + `=$1
+			AND ` + ColDeleted + `=FALSE
+		ORDER BY ` + orderBy + `
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.db.Query(q, usrID, count, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanMetas(rows, "no images found for user")
+}
+
+// ImageMetasByFolder returns (none-deleted) image metas belonging to
+// userID in folder, oldest first.
+func (r *Roach) ImageMetasByFolder(userID, folder string, offset, count int64) ([]model.ImageMeta, error) {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return nil, err
+	}
+
+	usrID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, errors.NewNotFound("only numeric IDs stored here")
+	}
+
+	q := `
+	SELECT ` + metaCols + `, ` + exifCols + `
+		FROM ` + metaWithExifFrom + `
+		WHERE ` + TblImageMeta + `.` + ColUserID + `=$1
+			AND ` + ColFolder + `=$2
+			AND ` + ColDeleted + `=FALSE
+		ORDER BY ` + TblImageMeta + `.` + ColCreateDate + `, ` + TblImageMeta + `.` + ColID + `
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := r.db.Query(q, usrID, folder, count, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanMetas(rows, "no images found in folder")
+}
+
+// HashedImageMetasByUserID returns all (none-deleted) image metas
+// belonging to userID that have a perceptual hash, oldest first.
+func (r *Roach) HashedImageMetasByUserID(userID string) ([]model.ImageMeta, error) {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return nil, err
+	}
+
+	usrID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, errors.NewNotFound("only numeric IDs stored here")
+	}
+
+	q := `
+	SELECT ` + metaCols + `, ` + exifCols + `
+		FROM ` + metaWithExifFrom + `
+		WHERE ` + TblImageMeta + `.` + ColUserID + `=$1
+			AND ` + ColDeleted + `=FALSE
+			AND ` + ColPHash + ` != ''
+		ORDER BY ` + TblImageMeta + `.` + ColCreateDate + `
+	`
+	rows, err := r.db.Query(q, usrID)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanMetas(rows, "no hashed images found for user")
+}
+
+// ImageMetasByDigest returns all (none-deleted) image metas referencing
+// the blob identified by digest, oldest first.
+func (r *Roach) ImageMetasByDigest(digest string) ([]model.ImageMeta, error) {
 
 	if err := r.InitDBIfNot(); err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT ` + metaCols + `, ` + exifCols + `
+		FROM ` + metaWithExifFrom + `
+		WHERE ` + ColDigest + `=$1
+			AND ` + ColDeleted + `=FALSE
+		ORDER BY ` + TblImageMeta + `.` + ColCreateDate + `
+	`
+	rows, err := r.db.Query(q, digest)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanMetas(rows, "no images found with digest")
+}
+
+// ImageMetasByDominantColor returns (none-deleted) image metas belonging
+// to userID whose dominant palette colour is within maxDistance of c,
+// nearest first. Distance is euclidean in RGB space.
+func (r *Roach) ImageMetasByDominantColor(userID string, c color.RGBA, maxDistance float64, offset, count int64) ([]model.ImageMeta, error) {
+
+	if err := r.InitDBIfNot(); err != nil {
+		return nil, err
+	}
+
+	usrID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, errors.NewNotFound("only numeric IDs stored here")
+	}
+
+	distance := `SQRT(
+		POWER(` + ColRed + ` - $2, 2) + POWER(` + ColGreen + ` - $3, 2) + POWER(` + ColBlue + ` - $4, 2)
+	)`
+	q := `
+	SELECT ` + metaCols + `, ` + exifCols + `
+		FROM ` + metaWithExifFrom + `
+		INNER JOIN ` + TblImagePalette + `
+			ON ` + TblImagePalette + `.` + ColImageID + `=` + TblImageMeta + `.` + ColID + `
+				AND ` + TblImagePalette + `.` + ColPosition + `=0
+		WHERE ` + TblImageMeta + `.` + ColUserID + `=$1
+			AND ` + ColDeleted + `=FALSE
+			AND ` + distance + ` <= $5
+		ORDER BY ` + distance + `, ` + TblImageMeta + `.` + ColCreateDate + ` DESC
+		LIMIT $6 OFFSET $7
+	`
+	rows, err := r.db.Query(q, usrID, int(c.R), int(c.G), int(c.B), maxDistance,
+		count, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanMetas(rows, "no images found for user near colour")
+}
+
+// refBlob adds a reference to the blob identified by digest creating it
+// and calling store, if not nil, if it does not exist. The upsert locks
+// the blob's row until tx ends so that a concurrent unrefBlob cannot
+// remove the content in between.
+func refBlob(tx *sql.Tx, digest, typ string, store model.BlobFunc) error {
+	cols := ColDesc(ColDigest, ColRefCount, ColCreateDate, ColUpdateDate)
+	q := `
+	INSERT INTO ` + TblBlobs + ` (` + cols + `)
+		VALUES ($1, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (` + ColDigest + `)
+		DO UPDATE SET
+			` + ColRefCount + `=` + TblBlobs + `.` + ColRefCount + `+1,
+			` + ColUpdateDate + `=CURRENT_TIMESTAMP
+		RETURNING ` + ColRefCount + `
+	`
+	var refCount int
+	if err := tx.QueryRow(q, digest).Scan(&refCount); err != nil {
 		return err
 	}
+	if refCount > 1 || store == nil {
+		return nil
+	}
+	return store(digest, typ)
+}
+
+// unrefBlob removes a reference to the blob identified by digest deleting
+// the blob's row if it was the last one, in which case it returns true.
+// The update locks the blob's row until tx ends. The blob's content is
+// not removed here since tx may yet fail to commit or be retried; see
+// removeUnrefd.
+func unrefBlob(tx *sql.Tx, digest string) (bool, error) {
+	q := `
+	UPDATE ` + TblBlobs + `
+		SET ` + ColRefCount + `=` + ColRefCount + `-1, ` + ColUpdateDate + `=CURRENT_TIMESTAMP
+		WHERE ` + ColDigest + `=$1
+		RETURNING ` + ColRefCount + `
+	`
+	var refCount int
+	if err := tx.QueryRow(q, digest).Scan(&refCount); err != nil {
+		if err == sql.ErrNoRows {
+			return false, errors.Newf("blob %s not found", digest)
+		}
+		return false, err
+	}
+	if refCount > 0 {
+		return false, nil
+	}
+	q = `DELETE FROM ` + TblBlobs + ` WHERE ` + ColDigest + `=$1`
+	rslt, err := tx.Exec(q, digest)
+	if err := checkRowsAffected(rslt, err, 1); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
+// removeUnrefd calls remove, if not nil, to remove the content of the
+// blob identified by digest if unrefd reports that the committed
+// transaction released its last reference.
+func removeUnrefd(unrefd bool, digest, typ string, remove model.BlobFunc) error {
+	if !unrefd || remove == nil {
+		return nil
+	}
+	return remove(digest, typ)
+}
+
+func insertPalette(tx *sql.Tx, imageID int64, palette []model.PaletteColor) error {
+	cols := ColDesc(ColImageID, ColPosition, ColRed, ColGreen, ColBlue,
+		ColWeight, ColCreateDate, ColUpdateDate)
 	q := `
-	UPDATE ` + TblImageMeta + `
-		SET ` + ColDeleted + `=TRUE, ` + ColUpdateDate + `=CURRENT_TIMESTAMP
-		WHERE ` + ColID + `=$1
+	INSERT INTO ` + TblImagePalette + ` (` + cols + `)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
-	rslt, err := r.db.Exec(q, id)
+	for i, pc := range palette {
+		c, err := imaging.ParseHexColor(pc.Color)
+		if err != nil {
+			return errors.Newf("palette colour %d: %v", i, err)
+		}
+		rgba := c.(color.RGBA)
+		rslt, err := tx.Exec(q, imageID, i, int(rgba.R), int(rgba.G), int(rgba.B), pc.Weight)
+		if err := checkRowsAffected(rslt, err, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPalettes populates the Palette of each of ms.
+func (r *Roach) loadPalettes(ms []model.ImageMeta) error {
+
+	IDs := make([]int64, len(ms))
+	idx := make(map[int64]int, len(ms))
+	for i, m := range ms {
+		ID, err := strconv.ParseInt(m.ID, 10, 64)
+		if err != nil {
+			return errors.Newf("invalid image meta ID '%s': %v", m.ID, err)
+		}
+		IDs[i] = ID
+		idx[ID] = i
+	}
+
+	cols := ColDesc(ColImageID, ColRed, ColGreen, ColBlue, ColWeight)
+	q := `
+	SELECT ` + cols + `
+		FROM ` + TblImagePalette + `
+		WHERE ` + ColImageID + ` = ANY($1)
+		ORDER BY ` + ColImageID + `, ` + ColPosition + `
+	`
+	rows, err := r.db.Query(q, pq.Array(IDs))
+	if err != nil {
+		return errors.Newf("get palettes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ID int64
+		var red, green, blue uint8
+		var pc model.PaletteColor
+		if err := rows.Scan(&ID, &red, &green, &blue, &pc.Weight); err != nil {
+			return errors.Newf("scan palette row: %v", err)
+		}
+		pc.Color = model.HexColor(red, green, blue)
+		ms[idx[ID]].Palette = append(ms[idx[ID]].Palette, pc)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Newf("iterating palette result set: %v", err)
+	}
+	return nil
+}
+
+func insertExif(tx *sql.Tx, imageID int64, e model.ImageExif) error {
+	cols := ColDesc(ColImageID, exifCols, ColCreateDate, ColUpdateDate)
+	q := `
+	INSERT INTO ` + TblImageExif + ` (` + cols + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	rslt, err := tx.Exec(q, imageID, e.Make, e.Model, e.CaptureDate,
+		e.ExposureTime, e.FNumber, e.ISO, e.FocalLength, e.Latitude, e.Longitude)
 	return checkRowsAffected(rslt, err, 1)
 }
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+// scanMetas scans and closes rows, loading each meta's palette. It returns
+// a not found error with notFoundMsg if there were no rows.
+func (r *Roach) scanMetas(rows *sql.Rows, notFoundMsg string) ([]model.ImageMeta, error) {
+	defer rows.Close()
+
+	var ms []model.ImageMeta
+	for rows.Next() {
+		m, err := scanMeta(rows)
+		if err != nil {
+			return nil, errors.Newf("scan result set row: %v", err)
+		}
+		ms = append(ms, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Newf("iterating result set: %v", err)
+	}
+	// release the connection before loading palettes.
+	rows.Close()
+	if len(ms) == 0 {
+		return nil, errors.NewNotFound(notFoundMsg)
+	}
+	if err := r.loadPalettes(ms); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// scanMeta scans a row of metaCols followed by exifCols.
+func scanMeta(s scanner) (*model.ImageMeta, error) {
+
+	m := model.ImageMeta{}
+	var width, height float64
+	var mk, mdl sql.NullString
+	var captureDate pq.NullTime
+	var exposure, fNumber, focal, lat, long sql.NullFloat64
+	var iso, frames, loopCount, duration sql.NullInt64
+
+	err := s.Scan(&m.ID, &m.UserID, &m.Folder, &m.Type, &m.MimeType,
+		&width, &height, &m.PHash, &m.Digest, &m.BlurHash, &m.LQIP,
+		&frames, &loopCount, &duration, &m.Size, &m.OriginalSize,
+		&m.ColorSpace, &m.CreateDate, &m.UpdateDate,
+		&mk, &mdl, &captureDate, &exposure, &fNumber, &iso, &focal, &lat, &long)
+	if err != nil {
+		return nil, err
+	}
+	m.Width, m.Height = int(width), int(height)
+	if frames.Valid {
+		m.Animation = &model.ImageAnimation{Frames: int(frames.Int64),
+			LoopCount: int(loopCount.Int64), Duration: int(duration.Int64)}
+	}
+
+	// mk is only NULL when there is no joined EXIF row.
+	if !mk.Valid {
+		return &m, nil
+	}
+	m.Exif = &model.ImageExif{
+		Make:         mk.String,
+		Model:        mdl.String,
+		CaptureDate:  nullTimePtr(captureDate),
+		ExposureTime: nullFloatPtr(exposure),
+		FNumber:      nullFloatPtr(fNumber),
+		FocalLength:  nullFloatPtr(focal),
+		Latitude:     nullFloatPtr(lat),
+		Longitude:    nullFloatPtr(long),
+	}
+	if iso.Valid {
+		isoVal := int(iso.Int64)
+		m.Exif.ISO = &isoVal
+	}
+	return &m, nil
+}
+
+func nullTimePtr(t pq.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func nullFloatPtr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
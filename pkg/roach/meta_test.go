@@ -1,13 +1,17 @@
 package roach_test
 
 import (
+	"errors"
 	"flag"
-	"testing"
-	"github.com/tomogoma/imagems/pkg/roach"
 	"github.com/tomogoma/imagems/pkg/model"
+	"github.com/tomogoma/imagems/pkg/roach"
+	"image/color"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
 )
 
-
 func init() {
 	flag.Parse()
 }
@@ -18,7 +22,7 @@ func TestDB_SaveMeta(t *testing.T) {
 
 	d := roach.New(getOpts(conf)...)
 	meta := model.ImageMeta{UserID: "1234"}
-	ID, err := d.SaveMeta(meta)
+	ID, err := d.SaveMeta(meta, nil)
 	if err != nil {
 		t.Fatalf("db.SaveMeta(): %v", err)
 	}
@@ -33,11 +37,341 @@ func TestDB_DeleteMeta(t *testing.T) {
 
 	d := roach.New(getOpts(conf)...)
 	meta := model.ImageMeta{UserID: "1234"}
-	ID, err := d.SaveMeta(meta)
+	ID, err := d.SaveMeta(meta, nil)
 	if err != nil {
 		t.Fatalf("db.SaveMeta(): %v", err)
 	}
-	if err := d.DeleteMeta(ID); err != nil {
+	if err := d.DeleteMeta(ID, nil); err != nil {
 		t.Fatalf("db.DeleteMeta(): %v", err)
 	}
 }
+
+func TestRoach_DeleteMeta_blobRefs(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	blobs := &blobRecorder{}
+	firstID, err := d.SaveMeta(model.ImageMeta{UserID: "1234", Type: "png", Digest: digest}, blobs.store)
+	if err != nil {
+		t.Fatalf("db.SaveMeta(first): %v", err)
+	}
+	secondID, err := d.SaveMeta(model.ImageMeta{UserID: "5678", Type: "png", Digest: digest}, blobs.store)
+	if err != nil {
+		t.Fatalf("db.SaveMeta(second): %v", err)
+	}
+	if blobs.stored != 1 {
+		t.Errorf("Expected blob to be stored once, got %d", blobs.stored)
+	}
+
+	if err := d.DeleteMeta(firstID, blobs.remove); err != nil {
+		t.Fatalf("db.DeleteMeta(first): %v", err)
+	}
+	if blobs.removed != 0 {
+		t.Errorf("Expected blob to still be referenced after first delete")
+	}
+	if err := d.DeleteMeta(firstID, blobs.remove); !d.IsNotFoundError(err) {
+		t.Errorf("Expected not found error deleting twice, got %v", err)
+	}
+
+	blobs.err = errors.New("remove failed")
+	if err := d.DeleteMeta(secondID, blobs.remove); err == nil {
+		t.Fatalf("Expected an error when the blob cannot be removed")
+	}
+	if _, err := d.ImageMeta(secondID); !d.IsNotFoundError(err) {
+		t.Errorf("Expected meta to stay deleted when the blob cannot be removed, got %v", err)
+	}
+	if blobs.removed != 1 || blobs.digest != digest || blobs.ext != "png" {
+		t.Errorf("Expected last reference to blob %s.png to have been removed, got %+v",
+			digest, blobs)
+	}
+}
+
+// blobRecorder records calls to its model.BlobFunc methods.
+type blobRecorder struct {
+	stored, removed int
+	digest, ext     string
+	err             error
+}
+
+func (b *blobRecorder) store(digest, ext string) error {
+	b.stored++
+	b.digest, b.ext = digest, ext
+	return b.err
+}
+
+func (b *blobRecorder) remove(digest, ext string) error {
+	b.removed++
+	b.digest, b.ext = digest, ext
+	return b.err
+}
+
+func TestRoach_UpdateMeta(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	oldDigest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	newDigest := "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+	ID := saveMeta(t, d, model.ImageMeta{UserID: "1234", Type: "jpeg",
+		Width: 40, Height: 20, Digest: oldDigest, ColorSpace: "Adobe RGB (1998)",
+		Palette: []model.PaletteColor{{Color: "#ff0000", Weight: 1}}})
+
+	upd := model.ImageMeta{ID: strconv.FormatInt(ID, 10), Type: "png",
+		MimeType: "image/png", Width: 20, Height: 40, Digest: newDigest,
+		Palette: []model.PaletteColor{{Color: "#00ff00", Weight: 1}}}
+	stored, removed := &blobRecorder{}, &blobRecorder{}
+	if err := d.UpdateMeta(upd, stored.store, removed.remove); err != nil {
+		t.Fatalf("db.UpdateMeta(): %v", err)
+	}
+	if stored.stored != 1 || stored.digest != newDigest || stored.ext != "png" {
+		t.Errorf("Expected new blob to have been stored, got %+v", stored)
+	}
+	if removed.removed != 1 || removed.digest != oldDigest || removed.ext != "jpeg" {
+		t.Errorf("Expected last reference to old blob to have been removed, got %+v", removed)
+	}
+	got, err := d.ImageMeta(ID)
+	if err != nil {
+		t.Fatalf("db.ImageMeta(): %v", err)
+	}
+	if got.Digest != newDigest || got.Type != "png" || got.Width != 20 || got.Height != 40 ||
+		got.ColorSpace != "" {
+		t.Errorf("Meta not updated: got %+v", got)
+	}
+	if len(got.Palette) != 1 || got.Palette[0].Color != "#00ff00" {
+		t.Errorf("Palette not replaced: got %+v", got.Palette)
+	}
+	if !got.UpdateDate.After(got.CreateDate) {
+		t.Errorf("Expected update date after create date")
+	}
+
+	upd.ID = "0"
+	if err := d.UpdateMeta(upd, nil, nil); !d.IsNotFoundError(err) {
+		t.Errorf("Expected not found error for missing meta, got %v", err)
+	}
+}
+
+func TestRoach_ImageMeta(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	capture := time.Date(2017, 8, 3, 9, 4, 6, 0, time.UTC)
+	lat := -1.2921
+	tt := []struct {
+		name string
+		meta model.ImageMeta
+	}{
+		{
+			name: "animated without exif",
+			meta: model.ImageMeta{UserID: "1234", Folder: "general", Type: "gif",
+				Animation: &model.ImageAnimation{Frames: 12, LoopCount: 0, Duration: 1200}},
+		},
+		{
+			name: "with exif",
+			meta: model.ImageMeta{UserID: "1234", Folder: "general", Type: "jpeg",
+				PHash: "f0e1d2c3b4a59687", Size: 1024, OriginalSize: 4096,
+				ColorSpace: "Display P3",
+				Palette: []model.PaletteColor{
+					{Color: "#ff8800", Weight: 0.75}, {Color: "#0000ff", Weight: 0.25},
+				},
+				Exif: &model.ImageExif{Make: "Acme", CaptureDate: &capture, Latitude: &lat}},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ID, err := d.SaveMeta(tc.meta, nil)
+			if err != nil {
+				t.Fatalf("Error setting up: db.SaveMeta(): %v", err)
+			}
+			act, err := d.ImageMeta(ID)
+			if err != nil {
+				t.Fatalf("db.ImageMeta(): %v", err)
+			}
+			if act.UserID != tc.meta.UserID || act.Folder != tc.meta.Folder ||
+				act.Type != tc.meta.Type || act.PHash != tc.meta.PHash ||
+				act.Size != tc.meta.Size || act.OriginalSize != tc.meta.OriginalSize ||
+				act.ColorSpace != tc.meta.ColorSpace {
+				t.Errorf("Meta mismatch:\nExpect:\t%+v\nGot:\t%+v", tc.meta, *act)
+			}
+			if !reflect.DeepEqual(act.Palette, tc.meta.Palette) {
+				t.Errorf("Palette mismatch: expect %+v, got %+v",
+					tc.meta.Palette, act.Palette)
+			}
+			if !reflect.DeepEqual(act.Animation, tc.meta.Animation) {
+				t.Errorf("Animation mismatch: expect %+v, got %+v",
+					tc.meta.Animation, act.Animation)
+			}
+			if (tc.meta.Exif == nil) != (act.Exif == nil) {
+				t.Fatalf("Exif mismatch: expect %+v, got %+v", tc.meta.Exif, act.Exif)
+			}
+			if tc.meta.Exif == nil {
+				return
+			}
+			if act.Exif.Make != tc.meta.Exif.Make {
+				t.Errorf("Make mismatch: expect %s, got %s",
+					tc.meta.Exif.Make, act.Exif.Make)
+			}
+			if act.Exif.CaptureDate == nil || !act.Exif.CaptureDate.Equal(capture) {
+				t.Errorf("CaptureDate mismatch: expect %v, got %v",
+					capture, act.Exif.CaptureDate)
+			}
+			if act.Exif.Latitude == nil || *act.Exif.Latitude != lat {
+				t.Errorf("Latitude mismatch: expect %v, got %v",
+					lat, act.Exif.Latitude)
+			}
+			if act.Exif.Longitude != nil {
+				t.Errorf("Expected nil Longitude, got %v", *act.Exif.Longitude)
+			}
+		})
+	}
+}
+
+func TestRoach_ImageMetasByUserID(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	older := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	olderID := saveMeta(t, d, model.ImageMeta{UserID: "1234",
+		Exif: &model.ImageExif{CaptureDate: &older}})
+	newerID := saveMeta(t, d, model.ImageMeta{UserID: "1234",
+		Exif: &model.ImageExif{CaptureDate: &newer}})
+	noExifID := saveMeta(t, d, model.ImageMeta{UserID: "1234"})
+
+	tt := []struct {
+		name        string
+		userID      string
+		sortBy      string
+		expIDs      []int64
+		expNotFound bool
+	}{
+		{name: "upload date", userID: "1234", sortBy: model.SortUploadDate,
+			expIDs: []int64{noExifID, newerID, olderID}},
+		{name: "capture date", userID: "1234", sortBy: model.SortCaptureDate,
+			expIDs: []int64{newerID, olderID, noExifID}},
+		{name: "not found", userID: "5678", sortBy: model.SortUploadDate,
+			expNotFound: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			metas, err := d.ImageMetasByUserID(tc.userID, tc.sortBy, 0, 10)
+			if tc.expNotFound {
+				if !d.IsNotFoundError(err) {
+					t.Fatalf("Expected not found error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("db.ImageMetasByUserID(): %v", err)
+			}
+			if len(metas) != len(tc.expIDs) {
+				t.Fatalf("Expected %d metas, got %d", len(tc.expIDs), len(metas))
+			}
+			for i, expID := range tc.expIDs {
+				if metas[i].ID != strconv.FormatInt(expID, 10) {
+					t.Errorf("Order mismatch at %d: expect ID %d, got %s",
+						i, expID, metas[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestRoach_ImageMetasByFolder(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	firstID := saveMeta(t, d, model.ImageMeta{UserID: "1234", Folder: "trips"})
+	saveMeta(t, d, model.ImageMeta{UserID: "1234", Folder: "general"})
+	saveMeta(t, d, model.ImageMeta{UserID: "5678", Folder: "trips"})
+	secondID := saveMeta(t, d, model.ImageMeta{UserID: "1234", Folder: "trips"})
+
+	metas, err := d.ImageMetasByFolder("1234", "trips", 0, 10)
+	if err != nil {
+		t.Fatalf("db.ImageMetasByFolder(): %v", err)
+	}
+	expIDs := []string{strconv.FormatInt(firstID, 10), strconv.FormatInt(secondID, 10)}
+	var actIDs []string
+	for _, m := range metas {
+		actIDs = append(actIDs, m.ID)
+	}
+	if !reflect.DeepEqual(actIDs, expIDs) {
+		t.Errorf("IDs mismatch: expect %v, got %v", expIDs, actIDs)
+	}
+	if _, err := d.ImageMetasByFolder("1234", "empty", 0, 10); !d.IsNotFoundError(err) {
+		t.Errorf("Expected not found error for empty folder, got %v", err)
+	}
+}
+
+func TestRoach_ImageMetasByDigest(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	firstID := saveMeta(t, d, model.ImageMeta{UserID: "1234", Type: "png", Digest: digest})
+	saveMeta(t, d, model.ImageMeta{UserID: "1234", Type: "png",
+		Digest: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"})
+	deletedID := saveMeta(t, d, model.ImageMeta{UserID: "5678", Type: "png", Digest: digest})
+	secondID := saveMeta(t, d, model.ImageMeta{UserID: "5678", Folder: "trips", Type: "png", Digest: digest})
+	if err := d.DeleteMeta(deletedID, nil); err != nil {
+		t.Fatalf("Error setting up: db.DeleteMeta(): %v", err)
+	}
+
+	metas, err := d.ImageMetasByDigest(digest)
+	if err != nil {
+		t.Fatalf("db.ImageMetasByDigest(): %v", err)
+	}
+	expIDs := []string{strconv.FormatInt(firstID, 10), strconv.FormatInt(secondID, 10)}
+	var actIDs []string
+	for _, m := range metas {
+		actIDs = append(actIDs, m.ID)
+	}
+	if !reflect.DeepEqual(actIDs, expIDs) {
+		t.Errorf("IDs mismatch: expect %v, got %v", expIDs, actIDs)
+	}
+	if _, err := d.ImageMetasByDigest("none"); !d.IsNotFoundError(err) {
+		t.Errorf("Expected not found error for unknown digest, got %v", err)
+	}
+}
+
+func saveMeta(t *testing.T, d *roach.Roach, m model.ImageMeta) int64 {
+	ID, err := d.SaveMeta(m, nil)
+	if err != nil {
+		t.Fatalf("Error setting up: db.SaveMeta(): %v", err)
+	}
+	return ID
+}
+
+func TestRoach_ImageMetasByDominantColor(t *testing.T) {
+	conf, tearDown := setup(t)
+	defer tearDown()
+
+	d := roach.New(getOpts(conf)...)
+	orangeID := saveMeta(t, d, model.ImageMeta{UserID: "1234",
+		Palette: []model.PaletteColor{{Color: "#ff8800", Weight: 1}}})
+	redID := saveMeta(t, d, model.ImageMeta{UserID: "1234",
+		Palette: []model.PaletteColor{{Color: "#ff0000", Weight: 1}}})
+	saveMeta(t, d, model.ImageMeta{UserID: "1234",
+		Palette: []model.PaletteColor{{Color: "#0000ff", Weight: 1}}})
+
+	red := color.RGBA{R: 0xff, A: 0xff}
+	metas, err := d.ImageMetasByDominantColor("1234", red, 200, 0, 10)
+	if err != nil {
+		t.Fatalf("db.ImageMetasByDominantColor(): %v", err)
+	}
+	expIDs := []int64{redID, orangeID}
+	if len(metas) != len(expIDs) {
+		t.Fatalf("Expected %d metas, got %d", len(expIDs), len(metas))
+	}
+	for i, expID := range expIDs {
+		if metas[i].ID != strconv.FormatInt(expID, 10) {
+			t.Errorf("Order mismatch at %d: expect ID %d, got %s",
+				i, expID, metas[i].ID)
+		}
+	}
+}
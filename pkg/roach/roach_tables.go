@@ -1,24 +1,59 @@
 package roach
 
 const (
-	Version = 1
+	Version = 9
 
 	TblConfigurations = "configurations"
 	TblImageMeta      = "image_meta"
 	TblAPIKeys        = "api_keys"
+	TblImageExif      = "image_exif"
+	TblBlobs          = "blobs"
+	TblImagePalette   = "image_palette"
 
 	ColID         = "ID"
 	ColUserID     = "user_id"
+	ColImageID    = "image_id"
+	ColFolder     = "folder"
 	ColType       = "type"
 	ColMimeType   = "mime_type"
 	ColWidth      = "width"
 	ColHeight     = "height"
+	ColPHash      = "p_hash"
+	ColDigest     = "digest"
+	ColRefCount   = "ref_count"
+	ColBlurHash   = "blur_hash"
+	ColLQIP       = "lqip"
+	ColFrames     = "frames"
+	ColLoopCount  = "loop_count"
+	ColDuration   = "duration"
+	ColSize       = "size"
 	ColDeleted    = "deleted"
 	ColKey        = "key"
 	ColValue      = "value"
 	ColCreateDate = "create_date"
 	ColUpdateDate = "update_date"
 
+	ColOriginalSize = "original_size"
+	ColColorSpace   = "color_space"
+
+	// EXIF columns
+	ColMake         = "make"
+	ColModel        = "model"
+	ColCaptureDate  = "capture_date"
+	ColExposureTime = "exposure_time"
+	ColFNumber      = "f_number"
+	ColISO          = "iso"
+	ColFocalLength  = "focal_length"
+	ColLatitude     = "latitude"
+	ColLongitude    = "longitude"
+
+	// palette columns
+	ColPosition = "position"
+	ColRed      = "red"
+	ColGreen    = "green"
+	ColBlue     = "blue"
+	ColWeight   = "weight"
+
 	// CREATE TABLE DESCRIPTIONS
 	TblDescConfigurations = `
 	CREATE TABLE IF NOT EXISTS ` + TblConfigurations + ` (
@@ -43,15 +78,66 @@ const (
 	CREATE TABLE IF NOT EXISTS ` + TblImageMeta + ` (
 		` + ColID + ` BIGSERIAL PRIMARY KEY NOT NULL CHECK (` + ColID + `>0),
 		` + ColUserID + ` BIGINT NOT NULL CHECK (` + ColUserID + `>0),
+		` + ColFolder + ` VARCHAR(256) NOT NULL DEFAULT '',
 		` + ColType + ` VARCHAR(256),
 		` + ColMimeType + ` VARCHAR(256),
 		` + ColWidth + ` FLOAT,
 		` + ColHeight + ` FLOAT,
+		` + ColPHash + ` VARCHAR(16) NOT NULL DEFAULT '',
+		` + ColDigest + ` VARCHAR(64) NOT NULL DEFAULT '',
+		` + ColBlurHash + ` VARCHAR(128) NOT NULL DEFAULT '',
+		` + ColLQIP + ` TEXT NOT NULL DEFAULT '',
+		` + ColFrames + ` INT,
+		` + ColLoopCount + ` INT,
+		` + ColDuration + ` INT,
+		` + ColSize + ` BIGINT NOT NULL DEFAULT 0,
+		` + ColOriginalSize + ` BIGINT NOT NULL DEFAULT 0,
+		` + ColColorSpace + ` VARCHAR(256) NOT NULL DEFAULT '',
 		` + ColCreateDate + ` TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		` + ColUpdateDate + ` TIMESTAMPTZ NOT NULL,
 		` + ColDeleted + ` BOOL NOT NULL DEFAULT FALSE
 	);
 	`
+
+	TblDescBlobs = `
+	CREATE TABLE IF NOT EXISTS ` + TblBlobs + ` (
+		` + ColDigest + ` VARCHAR(64) PRIMARY KEY NOT NULL CHECK (` + ColDigest + ` != ''),
+		` + ColRefCount + ` INT NOT NULL CHECK (` + ColRefCount + ` >= 0),
+		` + ColCreateDate + ` TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		` + ColUpdateDate + ` TIMESTAMPTZ NOT NULL
+	);
+	`
+
+	TblDescImagePalette = `
+	CREATE TABLE IF NOT EXISTS ` + TblImagePalette + ` (
+		` + ColImageID + ` BIGINT NOT NULL REFERENCES ` + TblImageMeta + ` (` + ColID + `),
+		` + ColPosition + ` INT NOT NULL CHECK (` + ColPosition + ` >= 0),
+		` + ColRed + ` INT NOT NULL CHECK (` + ColRed + ` BETWEEN 0 AND 255),
+		` + ColGreen + ` INT NOT NULL CHECK (` + ColGreen + ` BETWEEN 0 AND 255),
+		` + ColBlue + ` INT NOT NULL CHECK (` + ColBlue + ` BETWEEN 0 AND 255),
+		` + ColWeight + ` FLOAT NOT NULL,
+		` + ColCreateDate + ` TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		` + ColUpdateDate + ` TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (` + ColImageID + `, ` + ColPosition + `)
+	);
+	`
+
+	TblDescImageExif = `
+	CREATE TABLE IF NOT EXISTS ` + TblImageExif + ` (
+		` + ColImageID + ` BIGINT PRIMARY KEY NOT NULL REFERENCES ` + TblImageMeta + ` (` + ColID + `),
+		` + ColMake + ` VARCHAR(256) NOT NULL DEFAULT '',
+		` + ColModel + ` VARCHAR(256) NOT NULL DEFAULT '',
+		` + ColCaptureDate + ` TIMESTAMPTZ,
+		` + ColExposureTime + ` FLOAT,
+		` + ColFNumber + ` FLOAT,
+		` + ColISO + ` INT,
+		` + ColFocalLength + ` FLOAT,
+		` + ColLatitude + ` FLOAT,
+		` + ColLongitude + ` FLOAT,
+		` + ColCreateDate + ` TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		` + ColUpdateDate + ` TIMESTAMPTZ NOT NULL
+	);
+	`
 )
 
 var (
@@ -60,7 +146,10 @@ var (
 	TblNames = []string{
 		TblConfigurations,
 		TblAPIKeys,
+		TblBlobs,
 		TblImageMeta,
+		TblImageExif,
+		TblImagePalette,
 	}
 
 	// TblDescs lists all CREATE TABLE DESCRIPTIONS in order of dependency
@@ -68,6 +157,9 @@ var (
 	TblDescs = []string{
 		TblDescConfigurations,
 		TblDescAPIKeys,
+		TblDescBlobs,
 		TblDescImageMeta,
+		TblDescImageExif,
+		TblDescImagePalette,
 	}
 )
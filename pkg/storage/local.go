@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+// tmpPrefix prefixes the names of files being written by Local.Put.
+const tmpPrefix = ".put-"
+
+// Local stores files in a directory on the local file system.
+// Use NewLocal() to instantiate.
+type Local struct {
+	errors.NotFoundErrCheck
+	root string
+}
+
+// NewLocal creates a Local storing files under the directory root,
+// creating it if it does not exist.
+func NewLocal(root string) (*Local, error) {
+	if root == "" {
+		return nil, errors.New("storage root directory was empty")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.Newf("create storage root directory: %v", err)
+	}
+	return &Local{root: root}, nil
+}
+
+// Put writes size bytes read from r to p replacing any existing file.
+// The content is written to a temporary file first so that readers never
+// see a partially written file.
+func (l *Local) Put(p string, r io.Reader, size int64) error {
+	fPath := l.filePath(p)
+	if err := os.MkdirAll(filepath.Dir(fPath), 0755); err != nil {
+		return errors.Newf("create directory: %v", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fPath), tmpPrefix)
+	if err != nil {
+		return errors.Newf("create temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return errors.Newf("write file: %v", err)
+	}
+	if n != size {
+		f.Close()
+		return errors.Newf("expected %d bytes but read %d", size, n)
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return errors.Newf("set file permissions: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Newf("write file: %v", err)
+	}
+	if err := os.Rename(f.Name(), fPath); err != nil {
+		return errors.Newf("move file into place: %v", err)
+	}
+	return nil
+}
+
+// Get returns a reader of length bytes of the file at p starting at
+// offset. A negative length reads to the end of the file.
+func (l *Local) Get(p string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(l.filePath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.NewNotFoundf("%s not found", p)
+		}
+		return nil, errors.Newf("open file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Newf("stat file: %v", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, errors.NewNotFoundf("%s not found", p)
+	}
+	if length, err = readRange(info.Size(), offset, length); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, errors.Newf("seek file: %v", err)
+	}
+	return ReadCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// Stat describes the file at p.
+func (l *Local) Stat(p string) (*model.ObjectInfo, error) {
+	info, err := os.Stat(l.filePath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.NewNotFoundf("%s not found", p)
+		}
+		return nil, errors.Newf("stat file: %v", err)
+	}
+	if info.IsDir() {
+		return nil, errors.NewNotFoundf("%s not found", p)
+	}
+	return &model.ObjectInfo{Path: CleanPath(p), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete removes the file at p. Deleting a file that does not exist is
+// not an error.
+func (l *Local) Delete(p string) error {
+	err := os.Remove(l.filePath(p))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Newf("remove file: %v", err)
+	}
+	return nil
+}
+
+// List describes the files whose paths begin with prefix sorted by path.
+func (l *Local) List(prefix string) ([]model.ObjectInfo, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	// only walk the deepest directory containing every match.
+	dir := CleanPath(prefix)
+	if !strings.HasSuffix(prefix, "/") {
+		dir = path.Dir(dir)
+	}
+	var infos []model.ObjectInfo
+	err := filepath.Walk(l.filePath(dir), func(fPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), tmpPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, fPath)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		if strings.HasPrefix(p, prefix) {
+			infos = append(infos, model.ObjectInfo{Path: p, Size: info.Size(), ModTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Newf("list files: %v", err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+	return infos, nil
+}
+
+// ListFolder describes the files directly within folder and returns the
+// paths of its subfolders, each ending with "/", both sorted by path.
+func (l *Local) ListFolder(folder string) ([]model.ObjectInfo, []string, error) {
+	prefix := FolderPrefix(folder)
+	entries, err := ioutil.ReadDir(l.filePath(prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, errors.Newf("list folder: %v", err)
+	}
+	var infos []model.ObjectInfo
+	var folders []string
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), tmpPrefix):
+		case e.IsDir():
+			folders = append(folders, prefix+e.Name()+"/")
+		default:
+			infos = append(infos, model.ObjectInfo{Path: prefix + e.Name(),
+				Size: e.Size(), ModTime: e.ModTime()})
+		}
+	}
+	return infos, folders, nil
+}
+
+func (l *Local) filePath(p string) string {
+	return filepath.Join(l.root, filepath.FromSlash(CleanPath(p)))
+}
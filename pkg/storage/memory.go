@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/model"
+)
+
+// Memory stores files in memory. It suits tests and short lived
+// instances; its content is lost once the process exits.
+// Use NewMemory() to instantiate.
+type Memory struct {
+	errors.NotFoundErrCheck
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string]memoryFile)}
+}
+
+// Put stores size bytes read from r at p replacing any existing file.
+func (m *Memory) Put(p string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Newf("read file: %v", err)
+	}
+	if int64(len(data)) != size {
+		return errors.Newf("expected %d bytes but read %d", size, len(data))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[CleanPath(p)] = memoryFile{data: data, modTime: time.Now()}
+	return nil
+}
+
+// Get returns a reader of length bytes of the file at p starting at
+// offset. A negative length reads to the end of the file.
+func (m *Memory) Get(p string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	f, ok := m.files[CleanPath(p)]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, errors.NewNotFoundf("%s not found", p)
+	}
+	length, err := readRange(int64(len(f.data)), offset, length)
+	if err != nil {
+		return nil, err
+	}
+	// Put replaces rather than modifies data so it is safe to share.
+	return ioutil.NopCloser(bytes.NewReader(f.data[offset : offset+length])), nil
+}
+
+// Stat describes the file at p.
+func (m *Memory) Stat(p string) (*model.ObjectInfo, error) {
+	p = CleanPath(p)
+	m.mu.RLock()
+	f, ok := m.files[p]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, errors.NewNotFoundf("%s not found", p)
+	}
+	return &model.ObjectInfo{Path: p, Size: int64(len(f.data)), ModTime: f.modTime}, nil
+}
+
+// Delete removes the file at p. Deleting a file that does not exist is
+// not an error.
+func (m *Memory) Delete(p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, CleanPath(p))
+	return nil
+}
+
+// List describes the files whose paths begin with prefix sorted by path.
+func (m *Memory) List(prefix string) ([]model.ObjectInfo, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	m.mu.RLock()
+	var infos []model.ObjectInfo
+	for p, f := range m.files {
+		if strings.HasPrefix(p, prefix) {
+			infos = append(infos, model.ObjectInfo{Path: p, Size: int64(len(f.data)), ModTime: f.modTime})
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+	return infos, nil
+}
+
+// ListFolder describes the files directly within folder and returns the
+// paths of its subfolders, each ending with "/", both sorted by path.
+func (m *Memory) ListFolder(folder string) ([]model.ObjectInfo, []string, error) {
+	prefix := FolderPrefix(folder)
+	m.mu.RLock()
+	var infos []model.ObjectInfo
+	seen := make(map[string]bool)
+	var folders []string
+	for p, f := range m.files {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if i := strings.Index(p[len(prefix):], "/"); i >= 0 {
+			sub := p[:len(prefix)+i+1]
+			if !seen[sub] {
+				seen[sub] = true
+				folders = append(folders, sub)
+			}
+			continue
+		}
+		infos = append(infos, model.ObjectInfo{Path: p, Size: int64(len(f.data)), ModTime: f.modTime})
+	}
+	m.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+	sort.Strings(folders)
+	return infos, folders, nil
+}
@@ -0,0 +1,529 @@
+// Package s3 stores image files as objects in a bucket of an S3 compatible
+// object store e.g. Amazon S3 or MinIO, see model.Storage. Requests are
+// authenticated with AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomogoma/go-typed-errors"
+	"github.com/tomogoma/imagems/pkg/model"
+	"github.com/tomogoma/imagems/pkg/storage"
+)
+
+const (
+	// MinPartSize is the smallest part of a multipart upload S3 accepts,
+	// except for the last part.
+	MinPartSize = 5 << 20
+	// MaxPartSize is the largest part of a multipart upload S3 accepts.
+	MaxPartSize = 5 << 30
+	// DefaultPartSize is the size of the parts files larger than it are
+	// uploaded in unless set using WithPartSize.
+	DefaultPartSize = 16 << 20
+	DefaultRegion   = "us-east-1"
+)
+
+// Bucket stores files as objects in an S3 bucket.
+// Use New() to instantiate.
+type Bucket struct {
+	errors.NotFoundErrCheck
+	endpoint    *url.URL
+	name        string
+	region      string
+	accessKeyID string
+	secretKey   string
+	prefix      string
+	pathStyle   bool
+	partSize    int64
+	client      *http.Client
+	now         func() time.Time
+}
+
+// Option allows extra configuration for instantiating Bucket. Use the
+// With... functions to set options.
+type Option func(*Bucket)
+
+// WithRegion sets the region the bucket is in, DefaultRegion by default.
+func WithRegion(region string) Option {
+	return func(b *Bucket) {
+		b.region = region
+	}
+}
+
+// WithCredentials sets the access key pair requests are signed with.
+// Required.
+func WithCredentials(accessKeyID, secretAccessKey string) Option {
+	return func(b *Bucket) {
+		b.accessKeyID = accessKeyID
+		b.secretKey = secretAccessKey
+	}
+}
+
+// WithPrefix stores files under prefix within the bucket so that the
+// bucket can be shared e.g. with the prefix "images" the file at
+// "blobs/9f/9f86d08.png" is stored as "images/blobs/9f/9f86d08.png".
+func WithPrefix(prefix string) Option {
+	return func(b *Bucket) {
+		b.prefix = storage.CleanPath(prefix)
+		if b.prefix != "" {
+			b.prefix += "/"
+		}
+	}
+}
+
+// WithPathStyle addresses the bucket as a path of the endpoint, as most
+// self hosted stores expect, rather than as a subdomain of the endpoint.
+func WithPathStyle(pathStyle bool) Option {
+	return func(b *Bucket) {
+		b.pathStyle = pathStyle
+	}
+}
+
+// WithPartSize sets the size of the parts of multipart uploads. Files no
+// larger than size are uploaded in a single request.
+func WithPartSize(size int64) Option {
+	return func(b *Bucket) {
+		b.partSize = size
+	}
+}
+
+// WithHTTPClient sets the client requests are sent with,
+// http.DefaultClient by default.
+func WithHTTPClient(c *http.Client) Option {
+	return func(b *Bucket) {
+		b.client = c
+	}
+}
+
+// New creates a Bucket storing files in the bucket called name at
+// endpoint e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000.
+// The Amazon S3 endpoint of the region is used if endpoint is empty.
+func New(endpoint, name string, opts ...Option) (*Bucket, error) {
+	b := &Bucket{
+		name:     name,
+		region:   DefaultRegion,
+		partSize: DefaultPartSize,
+		client:   http.DefaultClient,
+		now:      time.Now,
+	}
+	for _, f := range opts {
+		f(b)
+	}
+	if name == "" {
+		return nil, errors.New("bucket name was empty")
+	}
+	if b.region == "" {
+		return nil, errors.New("region was empty")
+	}
+	if b.accessKeyID == "" || b.secretKey == "" {
+		return nil, errors.New("S3 credentials were empty")
+	}
+	if b.partSize < MinPartSize || b.partSize > MaxPartSize {
+		return nil, errors.Newf("part size must be between %d and %d bytes",
+			MinPartSize, MaxPartSize)
+	}
+	if b.client == nil {
+		return nil, errors.New("HTTP client was nil")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + b.region + ".amazonaws.com"
+	}
+	var err error
+	if b.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, errors.Newf("parse endpoint: %v", err)
+	}
+	if b.endpoint.Scheme == "" || b.endpoint.Host == "" {
+		return nil, errors.New("endpoint was missing the protocol/scheme e.g http://")
+	}
+	return b, nil
+}
+
+// Put stores size bytes read from r at p replacing any existing file.
+// Files larger than the part size are uploaded in parts.
+func (b *Bucket) Put(p string, r io.Reader, size int64) error {
+	key, ok := b.key(p)
+	if !ok {
+		return errors.New("path was empty")
+	}
+	if size < 0 {
+		return errors.New("size cannot be negative")
+	}
+	if size > b.partSize {
+		return b.putMultipart(key, r, size)
+	}
+	data := make([]byte, size)
+	if err := readExactly(r, data); err != nil {
+		return err
+	}
+	if err := checkEOF(r); err != nil {
+		return err
+	}
+	h := http.Header{}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	resp, err := b.do(http.MethodPut, key, nil, h, data)
+	if err != nil {
+		return errors.Newf("put object: %v", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get returns a reader of length bytes of the file at p starting at
+// offset. A negative length reads to the end of the file.
+func (b *Bucket) Get(p string, offset, length int64) (io.ReadCloser, error) {
+	key, ok := b.key(p)
+	if !ok {
+		return nil, errors.NewNotFoundf("%s not found", p)
+	}
+	if offset < 0 {
+		return nil, errors.Newf("offset %d is outside the file", offset)
+	}
+	if length == 0 {
+		// a range cannot select zero bytes.
+		return b.emptyAt(p, offset)
+	}
+	h := http.Header{}
+	if offset > 0 || length > 0 {
+		rng := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if length > 0 {
+			rng += strconv.FormatInt(offset+length-1, 10)
+		}
+		h.Set("Range", rng)
+	}
+	resp, err := b.send(http.MethodGet, key, nil, h, nil)
+	if err != nil {
+		return nil, errors.Newf("get object: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the range was ignored.
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, errors.Newf("skip to offset: %v", err)
+		}
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errors.NewNotFoundf("%s not found", p)
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		// S3 rejects ranges starting at the end of the file.
+		return b.emptyAt(p, offset)
+	default:
+		return nil, errors.Newf("get object: %v", responseError(resp))
+	}
+	if length < 0 {
+		return resp.Body, nil
+	}
+	return storage.ReadCloser{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+}
+
+// emptyAt returns an empty reader if offset is within the file at p.
+func (b *Bucket) emptyAt(p string, offset int64) (io.ReadCloser, error) {
+	info, err := b.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if offset > info.Size {
+		return nil, errors.Newf("offset %d is outside the file's %d bytes", offset, info.Size)
+	}
+	return ioutil.NopCloser(bytes.NewReader(nil)), nil
+}
+
+// Stat describes the file at p.
+func (b *Bucket) Stat(p string) (*model.ObjectInfo, error) {
+	key, ok := b.key(p)
+	if !ok {
+		return nil, errors.NewNotFoundf("%s not found", p)
+	}
+	resp, err := b.send(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, errors.Newf("head object: %v", err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errors.NewNotFoundf("%s not found", p)
+	default:
+		return nil, errors.Newf("head object: %v", responseError(resp))
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &model.ObjectInfo{Path: key[len(b.prefix):], Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Delete removes the file at p. Deleting a file that does not exist is
+// not an error.
+func (b *Bucket) Delete(p string) error {
+	key, ok := b.key(p)
+	if !ok {
+		return nil
+	}
+	resp, err := b.send(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return errors.Newf("delete object: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return errors.Newf("delete object: %v", responseError(resp))
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List describes the files whose paths begin with prefix sorted by path.
+func (b *Bucket) List(prefix string) ([]model.ObjectInfo, error) {
+	infos, _, err := b.list(strings.TrimPrefix(prefix, "/"), false)
+	return infos, err
+}
+
+// ListFolder describes the files directly within folder and returns the
+// paths of its subfolders, each ending with "/", both sorted by path.
+// Subfolders are the common prefixes S3 groups keys into when listing
+// with the "/" delimiter so the folder's content is not listed.
+func (b *Bucket) ListFolder(folder string) ([]model.ObjectInfo, []string, error) {
+	return b.list(storage.FolderPrefix(folder), true)
+}
+
+// list describes the files whose paths begin with prefix, and, if
+// delimited, only those not within a subfolder returning the subfolders'
+// paths. S3 lists keys and common prefixes in UTF-8 binary order i.e.
+// sorted.
+func (b *Bucket) list(prefix string, delimited bool) ([]model.ObjectInfo, []string, error) {
+	q := url.Values{
+		"list-type": {"2"},
+		"prefix":    {b.prefix + prefix},
+	}
+	if delimited {
+		q.Set("delimiter", "/")
+	}
+	var infos []model.ObjectInfo
+	var folders []string
+	for {
+		resp, err := b.do(http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return nil, nil, errors.Newf("list objects: %v", err)
+		}
+		var res listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, errors.Newf("decode object list: %v", err)
+		}
+		for _, c := range res.Contents {
+			infos = append(infos, model.ObjectInfo{Path: c.Key[len(b.prefix):],
+				Size: c.Size, ModTime: c.LastModified})
+		}
+		for _, cp := range res.CommonPrefixes {
+			folders = append(folders, cp.Prefix[len(b.prefix):])
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return infos, folders, nil
+		}
+		q.Set("continuation-token", res.NextContinuationToken)
+	}
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads size bytes read from r to key in parts of
+// b.partSize bytes aborting the upload on failure.
+func (b *Bucket) putMultipart(key string, r io.Reader, size int64) error {
+	h := http.Header{}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	resp, err := b.do(http.MethodPost, key, url.Values{"uploads": {""}}, h, nil)
+	if err != nil {
+		return errors.Newf("create multipart upload: %v", err)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return errors.Newf("decode multipart upload ID: %v", err)
+	}
+
+	if err := b.uploadParts(key, initiated.UploadID, r, size); err != nil {
+		resp, abortErr := b.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil)
+		if abortErr != nil {
+			return errors.Newf("%v ...further while aborting the upload: %v", err, abortErr)
+		}
+		resp.Body.Close()
+		return err
+	}
+	return nil
+}
+
+func (b *Bucket) uploadParts(key, uploadID string, r io.Reader, size int64) error {
+	complete := completeMultipartUpload{}
+	buf := make([]byte, b.partSize)
+	for read := int64(0); read < size; {
+		part := buf
+		if rem := size - read; rem < int64(len(part)) {
+			part = part[:rem]
+		}
+		if err := readExactly(r, part); err != nil {
+			return err
+		}
+		read += int64(len(part))
+		n := len(complete.Parts) + 1
+		q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+		resp, err := b.do(http.MethodPut, key, q, nil, part)
+		if err != nil {
+			return errors.Newf("upload part %d: %v", n, err)
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+	if err := checkEOF(r); err != nil {
+		return err
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return errors.Newf("encode completed parts: %v", err)
+	}
+	resp, err := b.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return errors.Newf("complete multipart upload: %v", err)
+	}
+	defer resp.Body.Close()
+	// completing may fail after the response status has been sent.
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Newf("read complete multipart upload response: %v", err)
+	}
+	if err := parseError(respBody); err != nil {
+		return errors.Newf("complete multipart upload: %v", err)
+	}
+	return nil
+}
+
+// do sends a request returning an error, rather than the response, if the
+// response status is not 2xx.
+func (b *Bucket) do(method, key string, q url.Values, h http.Header, body []byte) (*http.Response, error) {
+	resp, err := b.send(method, key, q, h, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// send sends a signed request for key with query q, headers h and body.
+func (b *Bucket) send(method, key string, q url.Values, h http.Header, body []byte) (*http.Response, error) {
+	u := *b.endpoint
+	p := strings.TrimSuffix(u.Path, "/") + "/"
+	if b.pathStyle {
+		p += b.name + "/"
+	} else {
+		u.Host = b.name + "." + u.Host
+	}
+	u.Path = p + key
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(q)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	b.sign(req, hex.EncodeToString(sum[:]), b.now())
+	return b.client.Do(req)
+}
+
+// key returns the object key of the file at p or false if p is the
+// storage root.
+func (b *Bucket) key(p string) (string, bool) {
+	p = storage.CleanPath(p)
+	return b.prefix + p, p != ""
+}
+
+// errorResponse is the body of S3 error responses.
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// responseError reads and closes the body of resp returning the error it
+// describes.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := parseError(body); err != nil {
+		return err
+	}
+	return errors.Newf("unexpected response status %s", resp.Status)
+}
+
+// parseError returns the error described by body if it is an error
+// response.
+func parseError(body []byte) error {
+	var e errorResponse
+	if xml.Unmarshal(body, &e) != nil {
+		return nil
+	}
+	return errors.Newf("%s: %s", e.Code, e.Message)
+}
+
+// readExactly fills p from r failing if r does not have enough bytes.
+func readExactly(r io.Reader, p []byte) error {
+	if n, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.Newf("expected more bytes but read %d", n)
+		}
+		return errors.Newf("read file: %v", err)
+	}
+	return nil
+}
+
+// checkEOF returns an error if r has more bytes.
+func checkEOF(r io.Reader) error {
+	if n, _ := io.ReadFull(r, make([]byte, 1)); n > 0 {
+		return errors.New("read more bytes than expected")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package storage provides backends for storing image files, see
+// model.Storage. Files are addressed by slash separated paths relative to
+// the storage root e.g. "blobs/9f/9f86d08.png".
+package storage
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+// CleanPath returns p relative to the storage root with any ".." elements
+// that would climb above it removed.
+func CleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// readRange validates a Get of length bytes starting at offset from a file
+// of size bytes returning the number of bytes to read. A negative length
+// reads to the end of the file.
+func readRange(size, offset, length int64) (int64, error) {
+	if offset < 0 || offset > size {
+		return 0, errors.Newf("offset %d is outside the file's %d bytes", offset, size)
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	return length, nil
+}
+
+// FolderPrefix returns the prefix of the paths of files within folder.
+func FolderPrefix(folder string) string {
+	folder = CleanPath(folder)
+	if folder == "" {
+		return ""
+	}
+	return folder + "/"
+}
+
+// ReadCloser reads from Reader and closes Closer once done e.g. to close
+// a file read through an io.LimitReader.
+type ReadCloser struct {
+	io.Reader
+	io.Closer
+}
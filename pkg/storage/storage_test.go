@@ -0,0 +1,173 @@
+package storage_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/model"
+	"github.com/tomogoma/imagems/pkg/storage"
+)
+
+func TestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagems-storage-test-")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := storage.NewLocal(dir)
+	if err != nil {
+		t.Fatalf("storage.NewLocal(): %v", err)
+	}
+	for name, s := range map[string]model.Storage{"local": local, "memory": storage.NewMemory()} {
+		t.Run(name, func(t *testing.T) {
+			testStorage(t, s)
+		})
+	}
+}
+
+func testStorage(t *testing.T, s model.Storage) {
+	files := map[string]string{
+		"blobs/ab/abcd.png":     "0123456789",
+		"1/general/2_thumb.png": "thumb",
+		"1/general/sub/3.png":   "sub",
+	}
+	for p, content := range files {
+		if err := s.Put(p, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Put(%s): %v", p, err)
+		}
+	}
+	if err := s.Put("short.png", strings.NewReader("abc"), 4); err == nil {
+		t.Errorf("Expected an error putting fewer bytes than declared")
+	}
+
+	getTT := []struct {
+		name           string
+		path           string
+		offset, length int64
+		exp            string
+		expNotFound    bool
+		expErr         bool
+	}{
+		{name: "whole", path: "blobs/ab/abcd.png", length: -1, exp: "0123456789"},
+		{name: "range", path: "blobs/ab/abcd.png", offset: 2, length: 3, exp: "234"},
+		{name: "to end", path: "blobs/ab/abcd.png", offset: 7, length: -1, exp: "789"},
+		{name: "length clipped", path: "blobs/ab/abcd.png", offset: 8, length: 100, exp: "89"},
+		{name: "uncleaned path", path: "/1/../blobs/ab/abcd.png", length: 2, exp: "01"},
+		{name: "offset out of range", path: "blobs/ab/abcd.png", offset: 11, length: -1, expErr: true},
+		{name: "folder", path: "1/general", length: -1, expNotFound: true},
+		{name: "missing", path: "blobs/ab/none.png", length: -1, expNotFound: true},
+	}
+	for _, tc := range getTT {
+		t.Run("get "+tc.name, func(t *testing.T) {
+			r, err := s.Get(tc.path, tc.offset, tc.length)
+			if tc.expNotFound || tc.expErr {
+				if err == nil {
+					r.Close()
+					t.Fatalf("Expected an error, got nil")
+				}
+				if s.IsNotFoundError(err) != tc.expNotFound {
+					t.Errorf("Expected not found %t, got error: %v", tc.expNotFound, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			defer r.Close()
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if string(data) != tc.exp {
+				t.Errorf("Expected '%s', got '%s'", tc.exp, data)
+			}
+		})
+	}
+
+	info, err := s.Stat("/blobs/ab/abcd.png")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Path != "blobs/ab/abcd.png" || info.Size != 10 || info.ModTime.IsZero() {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if _, err := s.Stat("blobs/ab"); !s.IsNotFoundError(err) {
+		t.Errorf("Expected a not found error for a folder, got %v", err)
+	}
+
+	listTT := []struct {
+		prefix string
+		exp    []string
+	}{
+		{prefix: "1/general/", exp: []string{"1/general/2_thumb.png", "1/general/sub/3.png"}},
+		{prefix: "1/general/2_", exp: []string{"1/general/2_thumb.png"}},
+		{prefix: "blobs", exp: []string{"blobs/ab/abcd.png"}},
+		{prefix: "2/", exp: nil},
+	}
+	for _, tc := range listTT {
+		t.Run("list "+tc.prefix, func(t *testing.T) {
+			infos, err := s.List(tc.prefix)
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			var paths []string
+			for _, info := range infos {
+				paths = append(paths, info.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tc.exp, ",") {
+				t.Errorf("Expected %v, got %v", tc.exp, paths)
+			}
+		})
+	}
+
+	listFolderTT := []struct {
+		folder     string
+		exp        []string
+		expFolders []string
+	}{
+		{folder: "", expFolders: []string{"1/", "blobs/"}},
+		{folder: "1/general", exp: []string{"1/general/2_thumb.png"},
+			expFolders: []string{"1/general/sub/"}},
+		{folder: "/1/general/", exp: []string{"1/general/2_thumb.png"},
+			expFolders: []string{"1/general/sub/"}},
+		{folder: "1/general/sub", exp: []string{"1/general/sub/3.png"}},
+		{folder: "2", exp: nil},
+	}
+	for _, tc := range listFolderTT {
+		t.Run("list folder "+tc.folder, func(t *testing.T) {
+			infos, folders, err := s.ListFolder(tc.folder)
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			var paths []string
+			for _, info := range infos {
+				paths = append(paths, info.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(tc.exp, ",") {
+				t.Errorf("Expected files %v, got %v", tc.exp, paths)
+			}
+			if strings.Join(folders, ",") != strings.Join(tc.expFolders, ",") {
+				t.Errorf("Expected folders %v, got %v", tc.expFolders, folders)
+			}
+		})
+	}
+
+	if err := s.Put("blobs/ab/abcd.png", bytes.NewReader([]byte("new")), 3); err != nil {
+		t.Fatalf("Put replacing: %v", err)
+	}
+	if info, err := s.Stat("blobs/ab/abcd.png"); err != nil || info.Size != 3 {
+		t.Errorf("Expected the replaced file to be 3 bytes, got %+v, %v", info, err)
+	}
+	if err := s.Delete("blobs/ab/abcd.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat("blobs/ab/abcd.png"); !s.IsNotFoundError(err) {
+		t.Errorf("Expected a not found error after Delete, got %v", err)
+	}
+	if err := s.Delete("blobs/ab/abcd.png"); err != nil {
+		t.Errorf("Expected deleting a missing file to succeed, got %v", err)
+	}
+}
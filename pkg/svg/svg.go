@@ -0,0 +1,306 @@
+// Package svg sanitises SVG images so that they are safe to serve to
+// browsers.
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// forbiddenElements are dropped together with their content. Names are
+// lower case.
+var forbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// animationElements can set attributes, including links, when run.
+var animationElements = map[string]bool{
+	"animate":          true,
+	"animatemotion":    true,
+	"animatetransform": true,
+	"set":              true,
+}
+
+var (
+	cssURL      = regexp.MustCompile(`(?i)url\(\s*['"]?\s*([^'")\s]*)`)
+	cssImport   = regexp.MustCompile(`(?i)@import`)
+	embeddedImg = regexp.MustCompile(`(?i)^data:image/(png|jpeg|gif|webp)[;,]`)
+)
+
+// Is returns true if data, which may be just the start of a file, looks
+// like an SVG image i.e. it is XML whose root element is svg.
+func Is(data []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tkn, err := d.RawToken()
+		if err != nil {
+			return false
+		}
+		switch tkn := tkn.(type) {
+		case xml.StartElement:
+			return strings.EqualFold(tkn.Name.Local, "svg")
+		case xml.CharData:
+			if len(bytes.TrimSpace(tkn)) > 0 {
+				return false
+			}
+		}
+	}
+}
+
+// Sanitise parses data as an SVG image and returns a copy of it without
+// scripts, event handler attributes, foreign objects, comments, processing
+// instructions and references to external resources. Only references to
+// fragments within the image and embedded raster images are kept.
+//
+// The returned config holds the image's dimensions which are taken from
+// its viewBox or, failing that, its width and height in pixels.
+// Its ColorModel is nil.
+func Sanitise(data []byte) ([]byte, image.Config, error) {
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	out := &bytes.Buffer{}
+	var conf image.Config
+	var open []xml.Name
+	skipDepth := 0
+	rootSeen := false
+	for {
+		tkn, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, conf, err
+		}
+		switch tkn := tkn.(type) {
+		case xml.StartElement:
+			if len(open) == 0 {
+				if rootSeen {
+					return nil, conf, errors.New("more than one root element")
+				}
+				if !strings.EqualFold(tkn.Name.Local, "svg") {
+					return nil, conf, errors.New("root element is not svg")
+				}
+				if conf, err = dimensions(tkn); err != nil {
+					return nil, conf, err
+				}
+				rootSeen = true
+			}
+			open = append(open, tkn.Name)
+			if skipDepth > 0 || isForbidden(tkn) {
+				skipDepth++
+				continue
+			}
+			if strings.EqualFold(tkn.Name.Local, "style") {
+				css, err := readText(d, tkn.Name)
+				if err != nil {
+					return nil, conf, err
+				}
+				open = open[:len(open)-1]
+				if !isExternalCSS(css) {
+					writeStart(out, tkn)
+					xml.EscapeText(out, []byte(css))
+					writeEnd(out, tkn.Name)
+				}
+				continue
+			}
+			writeStart(out, tkn)
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != tkn.Name {
+				return nil, conf, fmt.Errorf("unexpected end element %s", qName(tkn.Name))
+			}
+			open = open[:len(open)-1]
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			writeEnd(out, tkn.Name)
+		case xml.CharData:
+			if skipDepth > 0 || len(open) == 0 {
+				continue
+			}
+			xml.EscapeText(out, tkn)
+		}
+	}
+	if !rootSeen {
+		return nil, conf, errors.New("no svg element found")
+	}
+	if len(open) > 0 {
+		return nil, conf, errors.New("unexpected end of file")
+	}
+	return out.Bytes(), conf, nil
+}
+
+// isForbidden returns true if el must be dropped with its content.
+func isForbidden(el xml.StartElement) bool {
+	name := strings.ToLower(el.Name.Local)
+	if forbiddenElements[name] {
+		return true
+	}
+	if !animationElements[name] {
+		return false
+	}
+	for _, attr := range el.Attr {
+		if strings.EqualFold(attr.Name.Local, "attributeName") &&
+			strings.HasSuffix(strings.ToLower(attr.Value), "href") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSafeAttr returns false for event handlers and attributes that refer
+// to external resources. xml:base is removed as it would resolve
+// otherwise local references against another document.
+func isSafeAttr(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	if strings.HasPrefix(name, "on") {
+		return false
+	}
+	if strings.ToLower(attr.Name.Space) == "xml" && name == "base" {
+		return false
+	}
+	if name == "href" || name == "src" {
+		return isLocalRef(attr.Value)
+	}
+	return !isExternalCSS(attr.Value)
+}
+
+// isLocalRef returns true if ref is a fragment within the image or an
+// embedded raster image.
+func isLocalRef(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	return strings.HasPrefix(ref, "#") || embeddedImg.MatchString(ref)
+}
+
+// isExternalCSS returns true if css imports style sheets or has url()s
+// that are not local references.
+func isExternalCSS(css string) bool {
+	if cssImport.MatchString(css) {
+		return true
+	}
+	for _, m := range cssURL.FindAllStringSubmatch(css, -1) {
+		if !isLocalRef(m[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readText reads the text content of the element named name up to and
+// including its end element. Nested elements are not allowed.
+func readText(d *xml.Decoder, name xml.Name) (string, error) {
+	text := &strings.Builder{}
+	for {
+		tkn, err := d.RawToken()
+		if err == io.EOF {
+			return "", errors.New("unexpected end of file")
+		}
+		if err != nil {
+			return "", err
+		}
+		switch tkn := tkn.(type) {
+		case xml.CharData:
+			text.Write(tkn)
+		case xml.EndElement:
+			if tkn.Name != name {
+				return "", fmt.Errorf("unexpected end element %s", qName(tkn.Name))
+			}
+			return text.String(), nil
+		case xml.StartElement:
+			return "", fmt.Errorf("unexpected element %s in %s",
+				qName(tkn.Name), qName(name))
+		}
+	}
+}
+
+// dimensions reads the size of the image from the root svg element.
+func dimensions(root xml.StartElement) (image.Config, error) {
+	var viewBox, width, height string
+	for _, attr := range root.Attr {
+		switch attr.Name.Local {
+		case "viewBox":
+			viewBox = attr.Value
+		case "width":
+			width = attr.Value
+		case "height":
+			height = attr.Value
+		}
+	}
+	if viewBox != "" {
+		vals := strings.FieldsFunc(viewBox, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+		if len(vals) != 4 {
+			return image.Config{}, fmt.Errorf("invalid viewBox '%s'", viewBox)
+		}
+		w, errW := strconv.ParseFloat(vals[2], 64)
+		h, errH := strconv.ParseFloat(vals[3], 64)
+		if errW != nil || errH != nil || !isLength(w) || !isLength(h) {
+			return image.Config{}, fmt.Errorf("invalid viewBox '%s'", viewBox)
+		}
+		return image.Config{Width: pixels(w), Height: pixels(h)}, nil
+	}
+	w, errW := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(width), "px"), 64)
+	h, errH := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(height), "px"), 64)
+	if errW != nil || errH != nil || !isLength(w) || !isLength(h) {
+		return image.Config{}, errors.New("svg needs a viewBox or a width and height in pixels")
+	}
+	return image.Config{Width: pixels(w), Height: pixels(h)}, nil
+}
+
+// isLength returns true if v is a positive, finite length. ParseFloat
+// accepts "NaN" and "Inf" which would otherwise pass a v > 0 check or
+// convert to an arbitrary int.
+func isLength(v float64) bool {
+	return v > 0 && !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func pixels(v float64) int {
+	if math.IsNaN(v) || v <= 0 {
+		return 0
+	}
+	if math.IsInf(v, 1) || v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(v))
+}
+
+func writeStart(out *bytes.Buffer, el xml.StartElement) {
+	out.WriteString("<" + qName(el.Name))
+	for _, attr := range el.Attr {
+		if !isSafeAttr(attr) {
+			continue
+		}
+		out.WriteString(" " + qName(attr.Name) + `="`)
+		xml.EscapeText(out, []byte(attr.Value))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+}
+
+func writeEnd(out *bytes.Buffer, name xml.Name) {
+	out.WriteString("</" + qName(name) + ">")
+}
+
+// qName returns the name as written in the document. RawToken leaves the
+// namespace prefix, rather than the namespace, in name.Space.
+func qName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,125 @@
+package svg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tomogoma/imagems/pkg/svg"
+)
+
+func TestIs(t *testing.T) {
+	tt := []struct {
+		name string
+		in   string
+		exp  bool
+	}{
+		{name: "svg", in: `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, exp: true},
+		{name: "prolog", in: `<?xml version="1.0"?>
+<!-- icon -->
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg viewBox="0 0 1 1">`, exp: true},
+		{name: "html", in: `<html><svg></svg></html>`},
+		{name: "text", in: `hello <svg>`},
+		{name: "binary", in: "\x89PNG\r\n\x1a\n"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if is := svg.Is([]byte(tc.in)); is != tc.exp {
+				t.Errorf("Expected %t, got %t", tc.exp, is)
+			}
+		})
+	}
+}
+
+func TestSanitise(t *testing.T) {
+	tt := []struct {
+		name      string
+		in        string
+		expW      int
+		expH      int
+		expKeep   []string
+		expRemove []string
+		expErr    bool
+	}{
+		{
+			name: "safe content kept",
+			in: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" ` +
+				`xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 24 12.5">` +
+				`<defs><linearGradient id="g"/></defs>` +
+				`<style>rect { fill: url(#g) }</style>` +
+				`<rect width="10" height="5" fill="url(#g)"/>` +
+				`<use xlink:href="#g"/><text>a &lt; b</text></svg>`,
+			expW: 24, expH: 13,
+			expKeep: []string{`xmlns:xlink="http://www.w3.org/1999/xlink"`,
+				`rect { fill: url(#g) }`, `fill="url(#g)"`, `xlink:href="#g"`,
+				`a &lt; b`},
+			expRemove: []string{"<?xml"},
+		},
+		{
+			name: "dangerous content removed",
+			in: `<svg xmlns="http://www.w3.org/2000/svg" width="16px" height="8" onload="alert(1)">` +
+				`<!-- comment --><script>alert(2)</script>` +
+				`<foreignObject><div>html</div></foreignObject>` +
+				`<a href="javascript:alert(3)"><circle r="1" OnClick="alert(4)"/></a>` +
+				`<image href="https://example.com/track.png"/>` +
+				`<image href="data:image/png;base64,AAAA"/>` +
+				`<rect style="fill: url('https://example.com/x.svg#p')"/>` +
+				`<style>@import url(https://example.com/x.css);</style>` +
+				`<set attributeName="href" to="javascript:alert(5)"/></svg>`,
+			expW: 16, expH: 8,
+			expKeep:   []string{"<circle", `href="data:image/png;base64,AAAA"`},
+			expRemove: []string{"alert", "comment", "html", "example.com", "<set", "<style"},
+		},
+		{
+			name: "xml:base removed",
+			in: `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 4 4" ` +
+				`xml:base="https://example.com/x.svg"><g xml:base="https://example.com/">` +
+				`<use href="#g"/></g></svg>`,
+			expW: 4, expH: 4,
+			expKeep:   []string{"<g>", `href="#g"`},
+			expRemove: []string{"xml:base", "example.com"},
+		},
+		{name: "not svg", in: `<html></html>`, expErr: true},
+		{name: "no dimensions", in: `<svg width="100%"></svg>`, expErr: true},
+		{name: "bad viewBox", in: `<svg viewBox="0 0 -1 10"></svg>`, expErr: true},
+		{name: "NaN viewBox", in: `<svg viewBox="0 0 NaN 10"></svg>`, expErr: true},
+		{name: "infinite viewBox", in: `<svg viewBox="0 0 10 Inf"></svg>`, expErr: true},
+		{name: "NaN width", in: `<svg width="NaN" height="10"></svg>`, expErr: true},
+		{name: "infinite height", in: `<svg width="10" height="+Inf"></svg>`, expErr: true},
+		{name: "malformed", in: `<svg viewBox="0 0 1 1"><g></svg>`, expErr: true},
+		{name: "truncated", in: `<svg viewBox="0 0 1 1"><g>`, expErr: true},
+		{name: "entity", in: `<!DOCTYPE svg [<!ENTITY x "y">]><svg viewBox="0 0 1 1">&x;</svg>`,
+			expErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			out, conf, err := svg.Sanitise([]byte(tc.in))
+			if tc.expErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+			if conf.Width != tc.expW || conf.Height != tc.expH {
+				t.Errorf("Dimensions mismatch: expect %dx%d, got %dx%d",
+					tc.expW, tc.expH, conf.Width, conf.Height)
+			}
+			for _, s := range tc.expKeep {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("Expected '%s' in %s", s, out)
+				}
+			}
+			for _, s := range tc.expRemove {
+				if strings.Contains(string(out), s) {
+					t.Errorf("Did not expect '%s' in %s", s, out)
+				}
+			}
+			if !svg.Is(out) {
+				t.Errorf("Sanitised output is not an SVG: %s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Package urlsign signs and verifies image URLs with an HMAC so that
+// only holders of the signing key can issue URLs that transform images.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/tomogoma/go-typed-errors"
+)
+
+// URL query parameters added by Sign.
+const (
+	ParamSignature = "sig"
+	ParamExpires   = "expires"
+)
+
+// Signer signs and verifies URLs using a secret key.
+type Signer struct {
+	errors.AuthErrCheck
+	key []byte
+}
+
+func New(key []byte) (*Signer, error) {
+	if len(key) == 0 {
+		return nil, errors.New("URL signing key was empty")
+	}
+	return &Signer{key: key}, nil
+}
+
+// Sign returns a copy of q carrying the signature of imgPath, which is
+// relative to the image URL root e.g. /blobs/9f/9f86d08.png, and q. If
+// expiry is not zero the signature is only valid until expiry.
+// The signature covers every parameter in q so parameters cannot be
+// added, removed or altered once signed.
+func (s *Signer) Sign(imgPath string, q url.Values, expiry time.Time) url.Values {
+	signed := url.Values{}
+	for k, v := range q {
+		signed[k] = append([]string{}, v...)
+	}
+	signed.Del(ParamSignature)
+	signed.Del(ParamExpires)
+	if !expiry.IsZero() {
+		signed.Set(ParamExpires, strconv.FormatInt(expiry.Unix(), 10))
+	}
+	signed.Set(ParamSignature, s.signature(imgPath, signed))
+	return signed
+}
+
+// Verify returns a forbidden error if q does not carry a valid signature
+// of imgPath and q or if the signature has expired by now.
+func (s *Signer) Verify(imgPath string, q url.Values, now time.Time) error {
+	sig := q.Get(ParamSignature)
+	if sig == "" {
+		return errors.NewForbidden("transformations require a signed URL")
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(imgPath, q))) {
+		return errors.NewForbidden("invalid URL signature")
+	}
+	if expStr := q.Get(ParamExpires); expStr != "" {
+		exp, err := strconv.ParseInt(expStr, 10, 64)
+		if err != nil {
+			return errors.NewForbidden("invalid URL expiry")
+		}
+		if now.Unix() > exp {
+			return errors.NewForbidden("signed URL has expired")
+		}
+	}
+	return nil
+}
+
+// signature returns the URL safe base64 HMAC-SHA256 of the cleaned
+// imgPath and the sorted, encoded q without its signature.
+func (s *Signer) signature(imgPath string, q url.Values) string {
+	unsigned := url.Values{}
+	for k, v := range q {
+		if k != ParamSignature {
+			unsigned[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path.Clean("/" + imgPath)))
+	mac.Write([]byte("?" + unsigned.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
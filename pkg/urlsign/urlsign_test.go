@@ -0,0 +1,52 @@
+package urlsign_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tomogoma/imagems/pkg/urlsign"
+)
+
+func TestSigner_Verify(t *testing.T) {
+	s, err := urlsign.New([]byte("secret"))
+	if err != nil {
+		t.Fatalf("urlsign.New(): %v", err)
+	}
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	imgPath := "/blobs/9f/9f86d08.png"
+	transform := url.Values{"w": {"200"}, "ops": {"grayscale"}}
+	signed := s.Sign(imgPath, transform, time.Time{})
+	expiring := s.Sign(imgPath, transform, now.Add(time.Hour))
+	tampered := s.Sign(imgPath, transform, time.Time{})
+	tampered.Set("w", "4000")
+	tt := []struct {
+		name         string
+		imgPath      string
+		q            url.Values
+		now          time.Time
+		expForbidden bool
+	}{
+		{name: "signed", imgPath: imgPath, q: signed, now: now},
+		{name: "uncleaned path", imgPath: "blobs/9f/../9f/9f86d08.png", q: signed, now: now},
+		{name: "not expired", imgPath: imgPath, q: expiring, now: now},
+		{name: "expired", imgPath: imgPath, q: expiring, now: now.Add(2 * time.Hour), expForbidden: true},
+		{name: "unsigned", imgPath: imgPath, q: transform, now: now, expForbidden: true},
+		{name: "tampered", imgPath: imgPath, q: tampered, now: now, expForbidden: true},
+		{name: "other image", imgPath: "/blobs/60/60303ae.png", q: signed, now: now, expForbidden: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.Verify(tc.imgPath, tc.q, tc.now)
+			if tc.expForbidden {
+				if !s.IsForbiddenError(err) {
+					t.Fatalf("Expected a forbidden error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error: %v", err)
+			}
+		})
+	}
+}